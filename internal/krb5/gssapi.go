@@ -28,14 +28,22 @@ import (
 	"encoding/binary"
 	"fmt"
 	"strings"
+	"time"
 
+	"github.com/jcmturner/gofork/encoding/asn1"
+	"github.com/jcmturner/gokrb5/v8/asn1tools"
 	"github.com/jcmturner/gokrb5/v8/client"
 	"github.com/jcmturner/gokrb5/v8/config"
 	"github.com/jcmturner/gokrb5/v8/credentials"
 	"github.com/jcmturner/gokrb5/v8/crypto"
 	"github.com/jcmturner/gokrb5/v8/gssapi"
+	"github.com/jcmturner/gokrb5/v8/iana/asnAppTag"
 	"github.com/jcmturner/gokrb5/v8/iana/chksumtype"
 	"github.com/jcmturner/gokrb5/v8/iana/flags"
+	"github.com/jcmturner/gokrb5/v8/iana/keyusage"
+	"github.com/jcmturner/gokrb5/v8/iana/msgtype"
+	"github.com/jcmturner/gokrb5/v8/iana/nametype"
+	"github.com/jcmturner/gokrb5/v8/iana/patype"
 	"github.com/jcmturner/gokrb5/v8/keytab"
 	"github.com/jcmturner/gokrb5/v8/messages"
 	"github.com/jcmturner/gokrb5/v8/spnego"
@@ -53,15 +61,17 @@ const (
 	InitiatorReady
 )
 
-func NewKrb5InitiatorClientWithPassword(username, password, krb5Conf string) (kcl Krb5InitiatorClient, err error) {
+func NewKrb5InitiatorClientWithPassword(username, realm, password, krb5Conf string) (kcl Krb5InitiatorClient, err error) {
 	c, err := config.Load(krb5Conf)
 	if err != nil {
 		return
 	}
 
-	defaultRealm := c.LibDefaults.DefaultRealm
+	if realm == "" {
+		realm = c.LibDefaults.DefaultRealm
+	}
 
-	cl := client.NewWithPassword(username, defaultRealm, password, c)
+	cl := client.NewWithPassword(username, realm, password, c)
 	err = cl.Login()
 	if err != nil {
 		return
@@ -77,7 +87,7 @@ func NewKrb5InitiatorClientWithPassword(username, password, krb5Conf string) (kc
 	}, nil
 }
 
-func NewKrb5InitiatorClientWithKeytab(username string, krb5Conf, keytabConf string) (kcl Krb5InitiatorClient, err error) {
+func NewKrb5InitiatorClientWithKeytab(username, realm string, krb5Conf, keytabConf string) (kcl Krb5InitiatorClient, err error) {
 	c, err := config.Load(krb5Conf)
 	if err != nil {
 		return
@@ -89,9 +99,11 @@ func NewKrb5InitiatorClientWithKeytab(username string, krb5Conf, keytabConf stri
 		return kcl, fmt.Errorf("unmarshal keytabConf failed: %w", err)
 	}
 
-	defaultRealm := c.LibDefaults.DefaultRealm
+	if realm == "" {
+		realm = c.LibDefaults.DefaultRealm
+	}
 
-	cl := client.NewWithKeytab(username, defaultRealm, cache, c)
+	cl := client.NewWithKeytab(username, realm, cache, c)
 	err = cl.Login()
 	if err != nil {
 		return
@@ -134,14 +146,186 @@ func NewKrb5InitiatorClientWithCache(krb5Conf, cacheFile string) (kcl Krb5Initia
 
 	return Krb5InitiatorClient{
 		client: cl,
+		ccache: cache,
 		state:  InitiatorStart,
 	}, nil
 }
 
 type Krb5InitiatorClient struct {
-	state  Krb5ClientState
-	client *client.Client
-	subkey types.EncryptionKey
+	state     Krb5ClientState
+	client    *client.Client
+	ccache    *credentials.CCache
+	subkey    types.EncryptionKey
+	forwarded *messages.TGSRep
+}
+
+// krbCredInfo is the KrbCredInfo of RFC 4120 section 5.8.1, the realms are encoded as GeneralString.
+type krbCredInfo struct {
+	Key       types.EncryptionKey `asn1:"explicit,tag:0"`
+	PRealm    string              `asn1:"generalstring,optional,explicit,tag:1"`
+	PName     types.PrincipalName `asn1:"optional,explicit,tag:2"`
+	Flags     asn1.BitString      `asn1:"optional,explicit,tag:3"`
+	AuthTime  time.Time           `asn1:"generalized,optional,explicit,tag:4"`
+	StartTime time.Time           `asn1:"generalized,optional,explicit,tag:5"`
+	EndTime   time.Time           `asn1:"generalized,optional,explicit,tag:6"`
+	RenewTill time.Time           `asn1:"generalized,optional,explicit,tag:7"`
+	SRealm    string              `asn1:"generalstring,optional,explicit,tag:8"`
+	SName     types.PrincipalName `asn1:"optional,explicit,tag:9"`
+}
+
+type encKrbCredPart struct {
+	TicketInfo []krbCredInfo `asn1:"explicit,tag:0"`
+}
+
+type krbCred struct {
+	PVNO    int                 `asn1:"explicit,tag:0"`
+	MsgType int                 `asn1:"explicit,tag:1"`
+	Tickets asn1.RawValue       `asn1:"explicit,tag:2"`
+	EncPart types.EncryptedData `asn1:"explicit,tag:3"`
+}
+
+// forwardableConfig returns a copy of the client config which requests forwardable tickets.
+func (k *Krb5InitiatorClient) forwardableConfig() *config.Config {
+	c := *k.client.Config
+	libDefaults := c.LibDefaults
+	libDefaults.Forwardable = true
+	c.LibDefaults = libDefaults
+	return &c
+}
+
+// getTGT returns the TGT and its session key from the credentials cache,
+// or acquires a new forwardable one for the keytab or the password.
+func (k *Krb5InitiatorClient) getTGT(realm string, krbtgt types.PrincipalName) (messages.Ticket, types.EncryptionKey, error) {
+	if k.ccache != nil {
+		cred, ok := k.ccache.GetEntry(krbtgt)
+		if !ok {
+			return messages.Ticket{}, types.EncryptionKey{}, fmt.Errorf("no TGT of realm [%s] in the credentials cache", realm)
+		}
+		var tgt messages.Ticket
+		if err := tgt.Unmarshal(cred.Ticket); err != nil {
+			return messages.Ticket{}, types.EncryptionKey{}, fmt.Errorf("unmarshal the cached TGT failed: %w", err)
+		}
+		return tgt, cred.Key, nil
+	}
+
+	asReq, err := messages.NewASReqForTGT(realm, k.forwardableConfig(), k.client.Credentials.CName())
+	if err != nil {
+		return messages.Ticket{}, types.EncryptionKey{}, fmt.Errorf("new AS_REQ failed: %w", err)
+	}
+	asRep, err := k.client.ASExchange(realm, asReq, 0)
+	if err != nil {
+		return messages.Ticket{}, types.EncryptionKey{}, fmt.Errorf("acquire a forwardable TGT failed: %w", err)
+	}
+	return asRep.Ticket, asRep.DecryptedEncPart.Key, nil
+}
+
+// DelegateCredentials acquires a forwarded TGT, which will be delegated to the server
+// in the checksum of the authenticator, see RFC 4121 section 4.1.1.
+func (k *Krb5InitiatorClient) DelegateCredentials() error {
+	realm := k.client.Credentials.Domain()
+	krbtgt := types.PrincipalName{NameType: nametype.KRB_NT_SRV_INST, NameString: []string{"krbtgt", realm}}
+	tgt, sessionKey, err := k.getTGT(realm, krbtgt)
+	if err != nil {
+		return err
+	}
+
+	tgsReq, err := messages.NewTGSReq(k.client.Credentials.CName(), realm, k.forwardableConfig(), tgt, sessionKey, krbtgt, false)
+	if err != nil {
+		return fmt.Errorf("new TGS_REQ failed: %w", err)
+	}
+	// gokrb5 does not request the forwarded flag, so set it and sign the modified request body again
+	types.SetFlag(&tgsReq.ReqBody.KDCOptions, flags.Forwarded)
+	if err := signTGSReq(&tgsReq, tgt, sessionKey); err != nil {
+		return err
+	}
+
+	_, tgsRep, err := k.client.TGSExchange(tgsReq, realm, tgt, sessionKey, 0)
+	if err != nil {
+		return fmt.Errorf("acquire a forwarded TGT failed: %w", err)
+	}
+	if !types.IsFlagSet(&tgsRep.DecryptedEncPart.Flags, flags.Forwarded) {
+		return fmt.Errorf("the KDC did not issue a forwarded TGT")
+	}
+	k.forwarded = &tgsRep
+	return nil
+}
+
+// signTGSReq sets the PA-TGS-REQ of the request, whose authenticator contains the checksum of the request body.
+func signTGSReq(tgsReq *messages.TGSReq, tgt messages.Ticket, sessionKey types.EncryptionKey) error {
+	body, err := tgsReq.ReqBody.Marshal()
+	if err != nil {
+		return fmt.Errorf("marshal TGS_REQ body failed: %w", err)
+	}
+	etype, err := crypto.GetEtype(sessionKey.KeyType)
+	if err != nil {
+		return err
+	}
+	chksum, err := etype.GetChecksumHash(sessionKey.KeyValue, body, keyusage.TGS_REQ_PA_TGS_REQ_AP_REQ_AUTHENTICATOR_CHKSUM)
+	if err != nil {
+		return fmt.Errorf("checksum TGS_REQ body failed: %w", err)
+	}
+	auth, err := types.NewAuthenticator(tgt.Realm, tgsReq.ReqBody.CName)
+	if err != nil {
+		return fmt.Errorf("new authenticator failed: %w", err)
+	}
+	auth.Cksum = types.Checksum{
+		CksumType: etype.GetHashID(),
+		Checksum:  chksum,
+	}
+	apReq, err := messages.NewAPReq(tgt, sessionKey, auth)
+	if err != nil {
+		return fmt.Errorf("new AP_REQ failed: %w", err)
+	}
+	b, err := apReq.Marshal()
+	if err != nil {
+		return fmt.Errorf("marshal AP_REQ failed: %w", err)
+	}
+	tgsReq.PAData = types.PADataSequence{types.PAData{PADataType: patype.PA_TGS_REQ, PADataValue: b}}
+	return nil
+}
+
+// newKRBCred creates the KRB_CRED of the forwarded TGT encrypted with the key.
+func (k *Krb5InitiatorClient) newKRBCred(key types.EncryptionKey) ([]byte, error) {
+	creds := k.client.Credentials
+	part := k.forwarded.DecryptedEncPart
+	encPart := encKrbCredPart{TicketInfo: []krbCredInfo{{
+		Key:       part.Key,
+		PRealm:    creds.Domain(),
+		PName:     creds.CName(),
+		Flags:     part.Flags,
+		AuthTime:  part.AuthTime,
+		StartTime: part.StartTime,
+		EndTime:   part.EndTime,
+		RenewTill: part.RenewTill,
+		SRealm:    part.SRealm,
+		SName:     part.SName,
+	}}}
+	b, err := asn1.Marshal(encPart)
+	if err != nil {
+		return nil, fmt.Errorf("marshal EncKrbCredPart failed: %w", err)
+	}
+	b = asn1tools.AddASNAppTag(b, asnAppTag.EncKrbCredPart)
+	encData, err := crypto.GetEncryptedData(b, key, keyusage.KRB_CRED_ENCPART, 0)
+	if err != nil {
+		return nil, fmt.Errorf("encrypt EncKrbCredPart failed: %w", err)
+	}
+
+	tickets, err := messages.MarshalTicketSequence([]messages.Ticket{k.forwarded.Ticket})
+	if err != nil {
+		return nil, err
+	}
+	tickets.Tag = 2
+	cred := krbCred{
+		PVNO:    5,
+		MsgType: msgtype.KRB_CRED,
+		Tickets: tickets,
+		EncPart: encData,
+	}
+	b, err = asn1.Marshal(cred)
+	if err != nil {
+		return nil, fmt.Errorf("marshal KRB_CRED failed: %w", err)
+	}
+	return asn1tools.AddASNAppTag(b, asnAppTag.KRBCred), nil
 }
 
 // Create new authenticator checksum for kerberos MechToken
@@ -166,7 +350,7 @@ func (k *Krb5InitiatorClient) InitSecContext(target string, token []byte, isGSSD
 		gssapi.ContextFlagInteg,
 		gssapi.ContextFlagMutual,
 	}
-	if isGSSDelegCreds {
+	if isGSSDelegCreds || k.forwarded != nil {
 		GSSAPIFlags = append(GSSAPIFlags, gssapi.ContextFlagDeleg)
 	}
 	APOptions := []int{flags.APOptionMutualRequired}
@@ -189,15 +373,26 @@ func (k *Krb5InitiatorClient) InitSecContext(target string, token []byte, isGSSD
 		if err != nil {
 			return nil, false, fmt.Errorf("error generating new authenticator: %w", err)
 		}
-		auth.Cksum = types.Checksum{
-			CksumType: chksumtype.GSSAPI,
-			Checksum:  k.newAuthenticatorChksum(GSSAPIFlags),
-		}
 		etype, _ := crypto.GetEtype(sessionKey.KeyType)
 		if err := auth.GenerateSeqNumberAndSubKey(sessionKey.KeyType, etype.GetKeyByteSize()); err != nil {
 			return nil, false, err
 		}
 		k.subkey = auth.SubKey
+		chksum := k.newAuthenticatorChksum(GSSAPIFlags)
+		if k.forwarded != nil {
+			// the KRB_CRED is encrypted with the subkey of the authenticator as MIT krb5 does
+			cred, err := k.newKRBCred(auth.SubKey)
+			if err != nil {
+				return nil, false, fmt.Errorf("error generating KRB_CRED: %w", err)
+			}
+			binary.LittleEndian.PutUint16(chksum[24:26], 1)
+			binary.LittleEndian.PutUint16(chksum[26:28], uint16(len(cred)))
+			chksum = append(chksum, cred...)
+		}
+		auth.Cksum = types.Checksum{
+			CksumType: chksumtype.GSSAPI,
+			Checksum:  chksum,
+		}
 
 		APReq, err := messages.NewAPReq(
 			tkt,
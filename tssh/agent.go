@@ -25,6 +25,7 @@ SOFTWARE.
 package tssh
 
 import (
+	"bufio"
 	"fmt"
 	"os"
 	"strings"
@@ -77,12 +78,95 @@ func getAgentClient(param *sshParam) agent.ExtendedAgent {
 		agentClient = agent.NewClient(conn)
 		debug("new ssh agent client [%s] success", addr)
 
-		addAfterLoginFunc(func() { _ = conn.Close(); agentClient = nil })
+		addAfterLoginFunc(func() {
+			_ = conn.Close()
+			agentClient = nil
+			agentOnce = sync.Once{} // allow to login again in LazyForward mode
+		})
 	})
 	return agentClient
 }
 
-func forwardToRemote(client SshClient, addr string) error {
+// getAddKeysToAgent returns a function which adds the decrypted keys to the agent
+// according to AddKeysToAgent, or nil if the keys should not be added.
+func getAddKeysToAgent(param *sshParam) func(path string, priKey, passphrase []byte) {
+	value := getOptionConfig(param.args, "AddKeysToAgent")
+	if value == "" {
+		return nil
+	}
+	var ask, confirm bool
+	var lifetime uint32
+	for field := range strings.FieldsSeq(strings.ToLower(value)) {
+		switch field {
+		case "no", "false":
+			return nil
+		case "yes", "true":
+		case "ask":
+			ask = true
+		case "confirm":
+			confirm = true
+		default:
+			seconds, err := convertSshTime(field)
+			if err != nil {
+				warning("invalid AddKeysToAgent [%s]: %v", value, err)
+				return nil
+			}
+			lifetime = seconds
+		}
+	}
+
+	return func(path string, priKey, passphrase []byte) {
+		agentClient := getAgentClient(param)
+		if agentClient == nil {
+			debug("no ssh agent to add key [%s]", path)
+			return
+		}
+		if ask && !confirmYesNo(fmt.Sprintf("Add key %s to the agent?", path)) {
+			debug("adding key [%s] to agent is not confirmed", path)
+			return
+		}
+		key, err := ssh.ParseRawPrivateKeyWithPassphrase(priKey, passphrase)
+		if err != nil {
+			debug("parse private key [%s] for agent failed: %v", path, err)
+			return
+		}
+		if err := agentClient.Add(agent.AddedKey{
+			PrivateKey:       key,
+			Comment:          path,
+			LifetimeSecs:     lifetime,
+			ConfirmBeforeUse: confirm,
+		}); err != nil {
+			warning("add key [%s] to agent failed: %v", path, err)
+			return
+		}
+		debug("identity added to agent: %s lifetime %ds confirm %v", path, lifetime, confirm)
+	}
+}
+
+func confirmYesNo(prompt string) bool {
+	if batchMode.Load() {
+		return false
+	}
+	if askpass := getAskpassProgram(); askpass != "" {
+		_, err := runAskpass(askpass, prompt, true)
+		return err == nil
+	}
+
+	stdin, closer, err := getKeyboardInput()
+	if err != nil {
+		return false
+	}
+	defer closer()
+
+	_, _ = os.Stderr.WriteString(prompt + " (yes/no) ")
+	input, err := bufio.NewReader(stdin).ReadString('\n')
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(strings.TrimSpace(input), "yes")
+}
+
+func forwardToRemote(client SshClient, addr string, filter *agentFilter) error {
 	channels := client.HandleChannelOpen(kAgentChannelType)
 	if channels == nil {
 		return fmt.Errorf("agent: already have handler for %s", kAgentChannelType)
@@ -101,20 +185,27 @@ func forwardToRemote(client SshClient, addr string) error {
 				continue
 			}
 			go ssh.DiscardRequests(reqs)
-			go forwardAgentRequest(channel, addr)
+			go forwardAgentRequest(channel, addr, filter)
 		}
 	}()
 	return nil
 }
 
-func forwardAgentRequest(channel ssh.Channel, addr string) {
+func forwardAgentRequest(channel ssh.Channel, addr string, filter *agentFilter) {
 	conn, err := dialAgent(addr)
 	if err != nil {
 		debug("ssh agent dial [%s] failed: %v", addr, err)
 		return
 	}
 
-	forwardChannel(channel, conn)
+	if filter == nil {
+		forwardChannel(channel, conn)
+		return
+	}
+
+	defer func() { _ = conn.Close() }()
+	defer func() { _ = channel.Close() }()
+	_ = agent.ServeAgent(filter.wrap(agent.NewClient(conn)), channel)
 }
 
 func sshAgentForward(sshConn *sshConnection) {
@@ -131,7 +222,7 @@ func sshAgentForward(sshConn *sshConnection) {
 		warning("forward agent but the socket address is not set")
 		return
 	}
-	if err := forwardToRemote(sshConn.client, addr); err != nil {
+	if err := forwardToRemote(sshConn.client, addr, getAgentFilter(sshConn.param)); err != nil {
 		warning("forward to agent [%s] failed: %v", addr, err)
 		return
 	}
@@ -0,0 +1,189 @@
+/*
+MIT License
+
+Copyright (c) 2023-2026 The Trzsz SSH Authors.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package tssh
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+var errAgentRequestDenied = errors.New("agent: request denied by tssh agent filter")
+
+// agentFilter restricts the keys and the requests the remote host can use
+// through the forwarded agent.
+type agentFilter struct {
+	host         string
+	fingerprints []string
+	comments     []*regexp.Regexp
+	confirm      bool
+	confirmFunc  func(prompt string) bool
+}
+
+// getAgentFilter returns nil if neither ForwardAgentKeys nor ForwardAgentConfirm is configured,
+// which means forwarding the whole agent as before.
+func getAgentFilter(param *sshParam) *agentFilter {
+	args := param.args
+	filter := &agentFilter{
+		host:        param.host,
+		confirm:     strings.EqualFold(getExOptionConfig(args, "ForwardAgentConfirm"), "yes"),
+		confirmFunc: confirmYesNo,
+	}
+	keys := getAllExOptionConfig(args, "ForwardAgentKeys", false)
+	for _, value := range keys {
+		for pattern := range strings.FieldsSeq(value) {
+			if strings.HasPrefix(pattern, "SHA256:") {
+				filter.fingerprints = append(filter.fingerprints, pattern)
+				continue
+			}
+			expr := "^" + wildcardToRegexp(pattern) + "$"
+			re, err := regexp.Compile(expr)
+			if err != nil {
+				warning("compile ForwardAgentKeys [%s] regexp [%s] failed: %v", pattern, expr, err)
+				continue
+			}
+			filter.comments = append(filter.comments, re)
+		}
+	}
+	if len(keys) == 0 && !filter.confirm {
+		return nil
+	}
+	if len(keys) > 0 && len(filter.fingerprints) == 0 && len(filter.comments) == 0 {
+		warning("no valid keys in ForwardAgentKeys, no keys will be forwarded")
+	}
+	return filter
+}
+
+func (f *agentFilter) isAllowed(key *agent.Key) bool {
+	if f.fingerprints == nil && f.comments == nil {
+		return true
+	}
+	fingerprint := ssh.FingerprintSHA256(key)
+	for _, fp := range f.fingerprints {
+		if fp == fingerprint {
+			return true
+		}
+	}
+	for _, re := range f.comments {
+		if re.MatchString(key.Comment) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *agentFilter) wrap(upstream agent.ExtendedAgent) agent.ExtendedAgent {
+	return &filteredAgent{filter: f, upstream: upstream}
+}
+
+type filteredAgent struct {
+	filter   *agentFilter
+	upstream agent.ExtendedAgent
+}
+
+func (a *filteredAgent) List() ([]*agent.Key, error) {
+	keys, err := a.upstream.List()
+	if err != nil {
+		return nil, err
+	}
+	var allowed []*agent.Key
+	for _, key := range keys {
+		if a.filter.isAllowed(key) {
+			allowed = append(allowed, key)
+		}
+	}
+	return allowed, nil
+}
+
+func (a *filteredAgent) findKey(key ssh.PublicKey) (*agent.Key, error) {
+	keys, err := a.List()
+	if err != nil {
+		return nil, err
+	}
+	wanted := string(key.Marshal())
+	for _, k := range keys {
+		if string(k.Marshal()) == wanted {
+			return k, nil
+		}
+	}
+	debug("agent filter denied signing with key %s for [%s]", ssh.FingerprintSHA256(key), a.filter.host)
+	return nil, errAgentRequestDenied
+}
+
+func (a *filteredAgent) Sign(key ssh.PublicKey, data []byte) (*ssh.Signature, error) {
+	return a.SignWithFlags(key, data, 0)
+}
+
+func (a *filteredAgent) SignWithFlags(key ssh.PublicKey, data []byte, flags agent.SignatureFlags) (*ssh.Signature, error) {
+	k, err := a.findKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if a.filter.confirm {
+		prompt := fmt.Sprintf("Allow remote host %s to use key %s?\nKey fingerprint %s.",
+			a.filter.host, k.Comment, ssh.FingerprintSHA256(key))
+		if !a.filter.confirmFunc(prompt) {
+			debug("agent filter signing with key %s for [%s] not confirmed", ssh.FingerprintSHA256(key), a.filter.host)
+			return nil, errAgentRequestDenied
+		}
+	}
+	return a.upstream.SignWithFlags(key, data, flags)
+}
+
+func (a *filteredAgent) Add(key agent.AddedKey) error {
+	return errAgentRequestDenied
+}
+
+func (a *filteredAgent) Remove(key ssh.PublicKey) error {
+	return errAgentRequestDenied
+}
+
+func (a *filteredAgent) RemoveAll() error {
+	return errAgentRequestDenied
+}
+
+func (a *filteredAgent) Lock(passphrase []byte) error {
+	return errAgentRequestDenied
+}
+
+func (a *filteredAgent) Unlock(passphrase []byte) error {
+	return errAgentRequestDenied
+}
+
+func (a *filteredAgent) Signers() ([]ssh.Signer, error) {
+	return nil, errAgentRequestDenied
+}
+
+func (a *filteredAgent) Extension(extensionType string, contents []byte) ([]byte, error) {
+	// session binding only restricts the usage of the keys further
+	if extensionType == "session-bind@openssh.com" {
+		return a.upstream.Extension(extensionType, contents)
+	}
+	return nil, agent.ErrExtensionUnsupported
+}
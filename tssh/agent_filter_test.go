@@ -0,0 +1,140 @@
+/*
+MIT License
+
+Copyright (c) 2023-2026 The Trzsz SSH Authors.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package tssh
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestAgentFilter(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	oriUserConfig := userConfig
+	userConfig = &tsshConfig{}
+	defer func() { userConfig = oriUserConfig }()
+
+	assert.Nil(getAgentFilter(&sshParam{args: &sshArgs{}}))
+
+	upstream := agent.NewKeyring().(agent.ExtendedAgent)
+	_, workKey, err := ed25519.GenerateKey(rand.Reader)
+	require.Nil(err)
+	_, homeKey, err := ed25519.GenerateKey(rand.Reader)
+	require.Nil(err)
+	_, otherKey, err := ed25519.GenerateKey(rand.Reader)
+	require.Nil(err)
+	require.Nil(upstream.Add(agent.AddedKey{PrivateKey: workKey, Comment: "work@laptop"}))
+	require.Nil(upstream.Add(agent.AddedKey{PrivateKey: homeKey, Comment: "home@laptop"}))
+	require.Nil(upstream.Add(agent.AddedKey{PrivateKey: otherKey, Comment: "other"}))
+	otherSigner, err := ssh.NewSignerFromKey(otherKey)
+	require.Nil(err)
+
+	args := &sshArgs{}
+	require.Nil(args.Option.UnmarshalText([]byte("ForwardAgentKeys=work* " + ssh.FingerprintSHA256(otherSigner.PublicKey()))))
+	require.Nil(args.Option.UnmarshalText([]byte("ForwardAgentConfirm=yes")))
+	filter := getAgentFilter(&sshParam{args: args, host: "jump"})
+	require.NotNil(filter)
+	var prompts []string
+	allow := true
+	filter.confirmFunc = func(prompt string) bool {
+		prompts = append(prompts, prompt)
+		return allow
+	}
+
+	client, server := net.Pipe()
+	defer func() { _ = client.Close() }()
+	go func() { _ = agent.ServeAgent(filter.wrap(upstream), server) }()
+	agentClient := agent.NewClient(client)
+
+	keys, err := agentClient.List()
+	require.Nil(err)
+	require.Len(keys, 2)
+	assert.Equal("work@laptop", keys[0].Comment)
+	assert.Equal("other", keys[1].Comment)
+
+	data := []byte("session data")
+	signature, err := agentClient.Sign(keys[0], data)
+	require.Nil(err)
+	assert.Nil(keys[0].Verify(data, signature))
+	require.Len(prompts, 1)
+	assert.Contains(prompts[0], "jump")
+	assert.Contains(prompts[0], "work@laptop")
+
+	allow = false
+	_, err = agentClient.Sign(keys[1], data)
+	assert.NotNil(err)
+
+	homeSigner, err := ssh.NewSignerFromKey(homeKey)
+	require.Nil(err)
+	_, err = agentClient.Sign(homeSigner.PublicKey(), data)
+	assert.NotNil(err)
+	assert.Len(prompts, 2)
+
+	assert.NotNil(agentClient.Add(agent.AddedKey{PrivateKey: homeKey}))
+	assert.NotNil(agentClient.Remove(keys[0]))
+	assert.NotNil(agentClient.RemoveAll())
+	assert.NotNil(agentClient.Lock([]byte("secret")))
+	all, err := upstream.List()
+	require.Nil(err)
+	assert.Len(all, 3)
+}
+
+func TestAgentFilterConfirm(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the stub askpass is a unix command")
+	}
+	assert := assert.New(t)
+	oriUserConfig := userConfig
+	userConfig = &tsshConfig{}
+	defer func() { userConfig = oriUserConfig }()
+	defer batchMode.Store(batchMode.Load())
+	batchMode.Store(false)
+
+	args := &sshArgs{}
+	require.Nil(t, args.Option.UnmarshalText([]byte("ForwardAgentConfirm=yes")))
+	filter := getAgentFilter(&sshParam{args: args, host: "jump"})
+	require.NotNil(t, filter)
+
+	// the client confirms like the other prompts, through the askpass or the terminal
+	t.Setenv("SSH_ASKPASS", "true")
+	t.Setenv("SSH_ASKPASS_REQUIRE", "force")
+	assert.True(filter.confirmFunc("Allow use of key?"))
+	t.Setenv("SSH_ASKPASS", "false")
+	assert.False(filter.confirmFunc("Allow use of key?"))
+
+	// the signing requests are refused in batch mode
+	t.Setenv("SSH_ASKPASS", "true")
+	batchMode.Store(true)
+	assert.False(filter.confirmFunc("Allow use of key?"))
+}
@@ -0,0 +1,262 @@
+/*
+MIT License
+
+Copyright (c) 2023-2026 The Trzsz SSH Authors.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package tssh
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"runtime"
+	"sync"
+	"syscall"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+var errAgentConfirmDenied = errors.New("agent: signing request denied by user")
+
+// tsshAgent is the built-in ssh agent, which adds the default key lifetime and
+// the confirm-before-use constraint on top of the agent.NewKeyring keyring.
+type tsshAgent struct {
+	keyring     agent.ExtendedAgent
+	mutex       sync.Mutex
+	lifetime    uint32
+	confirmKeys map[string]string
+	confirm     func(prompt string) bool
+}
+
+func newTsshAgent(lifetime uint32) *tsshAgent {
+	return &tsshAgent{
+		keyring:     agent.NewKeyring().(agent.ExtendedAgent),
+		lifetime:    lifetime,
+		confirmKeys: make(map[string]string),
+		confirm:     confirmWithAskpass,
+	}
+}
+
+func confirmWithAskpass(prompt string) bool {
+	askpass := os.Getenv("SSH_ASKPASS")
+	if askpass == "" {
+		warning("no SSH_ASKPASS to confirm: %s", prompt)
+		return false
+	}
+	_, err := runAskpass(askpass, prompt, true)
+	return err == nil
+}
+
+func (a *tsshAgent) List() ([]*agent.Key, error) {
+	return a.keyring.List()
+}
+
+func (a *tsshAgent) checkConfirm(key ssh.PublicKey) error {
+	a.mutex.Lock()
+	comment, ok := a.confirmKeys[string(key.Marshal())]
+	a.mutex.Unlock()
+	if !ok {
+		return nil
+	}
+	prompt := fmt.Sprintf("Allow use of key %s?\nKey fingerprint %s.", comment, ssh.FingerprintSHA256(key))
+	if !a.confirm(prompt) {
+		return errAgentConfirmDenied
+	}
+	return nil
+}
+
+func (a *tsshAgent) Sign(key ssh.PublicKey, data []byte) (*ssh.Signature, error) {
+	return a.SignWithFlags(key, data, 0)
+}
+
+func (a *tsshAgent) SignWithFlags(key ssh.PublicKey, data []byte, flags agent.SignatureFlags) (*ssh.Signature, error) {
+	if err := a.checkConfirm(key); err != nil {
+		return nil, err
+	}
+	return a.keyring.SignWithFlags(key, data, flags)
+}
+
+func (a *tsshAgent) Add(key agent.AddedKey) error {
+	confirm := key.ConfirmBeforeUse
+	key.ConfirmBeforeUse = false
+	if key.LifetimeSecs == 0 {
+		key.LifetimeSecs = a.lifetime
+	}
+	if err := a.keyring.Add(key); err != nil {
+		return err
+	}
+
+	signer, err := ssh.NewSignerFromKey(key.PrivateKey)
+	if err != nil {
+		return err
+	}
+	pubKey := signer.PublicKey()
+	if key.Certificate != nil {
+		pubKey = key.Certificate
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if confirm {
+		a.confirmKeys[string(pubKey.Marshal())] = key.Comment
+	} else {
+		delete(a.confirmKeys, string(pubKey.Marshal()))
+	}
+	debug("agent added key %s %s lifetime %ds confirm %v", key.Comment, ssh.FingerprintSHA256(pubKey), key.LifetimeSecs, confirm)
+	return nil
+}
+
+func (a *tsshAgent) Remove(key ssh.PublicKey) error {
+	if err := a.keyring.Remove(key); err != nil {
+		return err
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	delete(a.confirmKeys, string(key.Marshal()))
+	return nil
+}
+
+func (a *tsshAgent) RemoveAll() error {
+	if err := a.keyring.RemoveAll(); err != nil {
+		return err
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.confirmKeys = make(map[string]string)
+	return nil
+}
+
+func (a *tsshAgent) Lock(passphrase []byte) error {
+	return a.keyring.Lock(passphrase)
+}
+
+func (a *tsshAgent) Unlock(passphrase []byte) error {
+	return a.keyring.Unlock(passphrase)
+}
+
+func (a *tsshAgent) Signers() ([]ssh.Signer, error) {
+	return a.keyring.Signers()
+}
+
+func (a *tsshAgent) Extension(extensionType string, contents []byte) ([]byte, error) {
+	return a.keyring.Extension(extensionType, contents)
+}
+
+func loadAgentKey(a *tsshAgent, path string) error {
+	pemBytes, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	_, err = parsePrivateKey(pemBytes)
+	var passphrase []byte
+	if _, ok := err.(*ssh.PassphraseMissingError); ok {
+		for range 3 {
+			passphrase, err = readSecret(fmt.Sprintf("Enter passphrase for key '%s': ", path))
+			if err != nil {
+				return err
+			}
+			if _, err = parsePrivateKeyWithPassphrase(pemBytes, passphrase); err != x509.IncorrectPasswordError {
+				break
+			}
+		}
+	}
+	if _, ok := err.(*unsupportedSecurityKeyError); ok {
+		return fmt.Errorf("security keys are not supported by the built-in agent")
+	}
+	if err != nil {
+		return err
+	}
+
+	var priKey any
+	if passphrase != nil {
+		priKey, err = ssh.ParseRawPrivateKeyWithPassphrase(pemBytes, passphrase)
+	} else {
+		priKey, err = ssh.ParseRawPrivateKey(pemBytes)
+	}
+	if err != nil {
+		return err
+	}
+	return a.Add(agent.AddedKey{PrivateKey: priKey, Comment: path})
+}
+
+func execAgent(args *sshArgs) (int, bool) {
+	var lifetime uint32
+	if args.AgentLifetime != "" {
+		var err error
+		if lifetime, err = convertSshTime(args.AgentLifetime); err != nil {
+			toolsErrorExit("invalid agent lifetime [%s]: %v", args.AgentLifetime, err)
+		}
+	}
+	tsshAgent := newTsshAgent(lifetime)
+
+	for _, identity := range args.Identity.values {
+		path := resolveHomeDir(identity)
+		if err := loadAgentKey(tsshAgent, path); err != nil {
+			toolsErrorExit("load key [%s] failed: %v", path, err)
+		}
+	}
+
+	addr := args.AgentSocket
+	if addr == "" {
+		var err error
+		if addr, err = getUserSocketPath("tssh-agent"); err != nil {
+			toolsErrorExit("get default agent socket failed: %v", err)
+		}
+	}
+	addr = resolveHomeDir(addr)
+	listener, err := listenAgent(addr)
+	if err != nil {
+		toolsErrorExit("listen on [%s] failed: %v", addr, err)
+	}
+	addOnExitFunc(func() { _ = listener.Close() })
+
+	if runtime.GOOS == "windows" {
+		fmt.Printf("$env:SSH_AUTH_SOCK=\"%s\"\r\n", addr)
+	} else {
+		fmt.Printf("SSH_AUTH_SOCK=%s; export SSH_AUTH_SOCK;\n", addr)
+	}
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	go func() {
+		<-sigChan
+		_ = listener.Close()
+	}()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return 0, true
+			}
+			warning("agent accept failed: %v", err)
+			return kExitCodeToolsError, true
+		}
+		go func() {
+			defer func() { _ = conn.Close() }()
+			_ = agent.ServeAgent(tsshAgent, conn)
+		}()
+	}
+}
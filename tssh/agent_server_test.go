@@ -0,0 +1,209 @@
+/*
+MIT License
+
+Copyright (c) 2023-2026 The Trzsz SSH Authors.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package tssh
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/testdata"
+)
+
+func TestTsshAgent(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	tsshAgent := newTsshAgent(3600)
+	var prompts []string
+	allow := false
+	tsshAgent.confirm = func(prompt string) bool {
+		prompts = append(prompts, prompt)
+		return allow
+	}
+
+	client, server := net.Pipe()
+	defer func() { _ = client.Close() }()
+	go func() { _ = agent.ServeAgent(tsshAgent, server) }()
+	agentClient := agent.NewClient(client)
+
+	_, key1, err := ed25519.GenerateKey(rand.Reader)
+	require.Nil(err)
+	_, key2, err := ed25519.GenerateKey(rand.Reader)
+	require.Nil(err)
+	require.Nil(agentClient.Add(agent.AddedKey{PrivateKey: key1, Comment: "key1"}))
+	require.Nil(agentClient.Add(agent.AddedKey{PrivateKey: key2, Comment: "key2", ConfirmBeforeUse: true, LifetimeSecs: 60}))
+
+	keys, err := agentClient.List()
+	require.Nil(err)
+	require.Len(keys, 2)
+
+	data := []byte("session data")
+	signature, err := agentClient.Sign(keys[0], data)
+	require.Nil(err)
+	assert.Nil(keys[0].Verify(data, signature))
+	assert.Empty(prompts)
+
+	_, err = agentClient.Sign(keys[1], data)
+	assert.NotNil(err)
+	require.Len(prompts, 1)
+	assert.Contains(prompts[0], "key2")
+	allow = true
+	signature, err = agentClient.Sign(keys[1], data)
+	require.Nil(err)
+	assert.Nil(keys[1].Verify(data, signature))
+
+	require.Nil(agentClient.Lock([]byte("secret")))
+	_, err = agentClient.Sign(keys[0], data)
+	assert.NotNil(err)
+	assert.NotNil(agentClient.Unlock([]byte("wrong")))
+	require.Nil(agentClient.Unlock([]byte("secret")))
+
+	require.Nil(agentClient.Remove(keys[1]))
+	assert.Empty(tsshAgent.confirmKeys)
+	keys, err = agentClient.List()
+	require.Nil(err)
+	assert.Len(keys, 1)
+}
+
+func TestLoadAgentKey(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("askpass script is not supported on windows")
+	}
+	assert := assert.New(t)
+	require := require.New(t)
+
+	encryptedKey := testdata.PEMEncryptedKeys[2]
+	dir := t.TempDir()
+	keyPath := filepath.Join(dir, "id_ed25519")
+	require.Nil(os.WriteFile(keyPath, encryptedKey.PEMBytes, 0600))
+	askpass := filepath.Join(dir, "askpass")
+	require.Nil(os.WriteFile(askpass, []byte("#!/bin/sh\necho '"+encryptedKey.EncryptionKey+"'\n"), 0700))
+	t.Setenv("SSH_ASKPASS", askpass)
+	t.Setenv("SSH_ASKPASS_REQUIRE", "force")
+
+	tsshAgent := newTsshAgent(0)
+	require.Nil(loadAgentKey(tsshAgent, keyPath))
+	keys, err := tsshAgent.List()
+	require.Nil(err)
+	require.Len(keys, 1)
+	assert.Equal(keyPath, keys[0].Comment)
+	assert.Equal(ssh.KeyAlgoED25519, keys[0].Type())
+}
+
+func TestAddKeysToAgent(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("unix socket agent is not supported on windows")
+	}
+	assert := assert.New(t)
+	require := require.New(t)
+
+	oriUserConfig := userConfig
+	userConfig = &tsshConfig{}
+	defer func() { userConfig = oriUserConfig }()
+
+	tsshAgent := newTsshAgent(0)
+	addr := filepath.Join(t.TempDir(), "agent.sock")
+	listener, err := listenAgent(addr)
+	require.Nil(err)
+	defer func() { _ = listener.Close() }()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() { _ = agent.ServeAgent(tsshAgent, conn) }()
+		}
+	}()
+
+	newParam := func(addKeysToAgent string) *sshParam {
+		args := &sshArgs{}
+		require.Nil(args.Option.UnmarshalText([]byte("IdentityAgent=" + addr)))
+		if addKeysToAgent != "" {
+			require.Nil(args.Option.UnmarshalText([]byte("AddKeysToAgent=" + addKeysToAgent)))
+		}
+		return &sshParam{args: args}
+	}
+	assert.Nil(getAddKeysToAgent(newParam("")))
+	assert.Nil(getAddKeysToAgent(newParam("no")))
+	assert.Nil(getAddKeysToAgent(newParam("invalid")))
+
+	encryptedKey := testdata.PEMEncryptedKeys[2]
+	addToAgent := getAddKeysToAgent(newParam("confirm 1h"))
+	require.NotNil(addToAgent)
+	addToAgent("id_ed25519", encryptedKey.PEMBytes, []byte(encryptedKey.EncryptionKey))
+	cleanupAfterLogin()
+
+	keys, err := tsshAgent.List()
+	require.Nil(err)
+	require.Len(keys, 1)
+	assert.Equal("id_ed25519", keys[0].Comment)
+	assert.Len(tsshAgent.confirmKeys, 1)
+}
+
+func TestDefaultSignersAgent(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	oriUserConfig := userConfig
+	userConfig = &tsshConfig{}
+	defer func() { userConfig = oriUserConfig }()
+
+	oriUserHomeDir := userHomeDir
+	userHomeDir = t.TempDir()
+	defer func() { userHomeDir = oriUserHomeDir }()
+
+	require.Nil(os.MkdirAll(filepath.Join(userHomeDir, ".ssh"), 0700))
+	encryptedKey := testdata.PEMEncryptedKeys[2]
+	require.Nil(os.WriteFile(filepath.Join(userHomeDir, ".ssh", "id_ed25519"), encryptedKey.PEMBytes, 0600))
+
+	addToAgent := func(path string, priKey, passphrase []byte) {}
+	newOptions := func(agentAddr string) *signerOptions {
+		return &signerOptions{addKeysToAgent: "yes", agentAddr: agentAddr, addToAgent: addToAgent}
+	}
+	getOptions := func(signers []sshSigner) *signerOptions {
+		require.Len(signers, 1)
+		baseSigner, ok := signers[0].(*sshBaseSigner)
+		require.True(ok)
+		return baseSigner.options
+	}
+
+	agentA := filepath.Join(userHomeDir, "agent-a.sock")
+	agentB := filepath.Join(userHomeDir, "agent-b.sock")
+	optionsA := newOptions(agentA)
+	optionsB := newOptions(agentB)
+	assert.Same(optionsA, getOptions(getDefaultSigners(optionsA)))
+	assert.Same(optionsB, getOptions(getDefaultSigners(optionsB)))
+	assert.Same(optionsA, getOptions(getDefaultSigners(newOptions(agentA))))
+}
@@ -27,7 +27,12 @@ SOFTWARE.
 package tssh
 
 import (
+	"errors"
+	"fmt"
 	"net"
+	"os"
+	"path/filepath"
+	"syscall"
 	"time"
 )
 
@@ -38,3 +43,58 @@ func getDefaultAgentAddr() (string, error) {
 func dialAgent(addr string) (net.Conn, error) {
 	return net.DialTimeout("unix", addr, time.Second)
 }
+
+// getUserSocketPath returns a socket path which only the current user can access.
+func getUserSocketPath(name string) (string, error) {
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir == "" || !isDirExist(dir) {
+		dir = filepath.Join(os.TempDir(), fmt.Sprintf("tssh-%d", os.Getuid()))
+		if err := os.Mkdir(dir, 0700); err == nil {
+			_ = os.Chmod(dir, 0700) // in case of a strange umask
+		} else if !errors.Is(err, os.ErrExist) {
+			return "", err
+		}
+		if err := checkUserSocketDir(dir); err != nil {
+			return "", err
+		}
+	}
+	return filepath.Join(dir, name+".sock"), nil
+}
+
+// checkUserSocketDir makes sure the directory was not created by another user in advance.
+func checkUserSocketDir(dir string) error {
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("[%s] is not a directory", dir)
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); !ok || int(stat.Uid) != os.Getuid() {
+		return fmt.Errorf("[%s] is not owned by the current user", dir)
+	}
+	if info.Mode().Perm() != 0700 {
+		return fmt.Errorf("[%s] mode %#o is not 0700", dir, info.Mode().Perm())
+	}
+	return nil
+}
+
+func listenAgent(addr string) (net.Listener, error) {
+	if conn, err := dialAgent(addr); err == nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("another agent is listening on it")
+	}
+	_ = os.Remove(addr)
+	// create the socket with a restrictive umask, so that it's never accessible to other users
+	oldMask := syscall.Umask(0177)
+	listener, err := net.Listen("unix", addr)
+	syscall.Umask(oldMask)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(addr, 0600); err != nil {
+		_ = listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
@@ -0,0 +1,66 @@
+//go:build !windows
+
+/*
+MIT License
+
+Copyright (c) 2023-2026 The Trzsz SSH Authors.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package tssh
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckUserSocketDir(t *testing.T) {
+	assert := assert.New(t)
+	base := t.TempDir()
+
+	dir := filepath.Join(base, "dir")
+	require.NoError(t, os.Mkdir(dir, 0700))
+	assert.NoError(checkUserSocketDir(dir))
+
+	require.NoError(t, os.Chmod(dir, 0755))
+	assert.ErrorContains(checkUserSocketDir(dir), "is not 0700")
+
+	link := filepath.Join(base, "link")
+	require.NoError(t, os.Symlink(dir, link))
+	assert.ErrorContains(checkUserSocketDir(link), "is not a directory")
+
+	file := filepath.Join(base, "file")
+	require.NoError(t, os.WriteFile(file, nil, 0700))
+	assert.ErrorContains(checkUserSocketDir(file), "is not a directory")
+}
+
+func TestListenAgentMode(t *testing.T) {
+	addr := filepath.Join(t.TempDir(), "agent.sock")
+	listener, err := listenAgent(addr)
+	require.NoError(t, err)
+	defer func() { _ = listener.Close() }()
+	info, err := os.Stat(addr)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+}
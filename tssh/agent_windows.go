@@ -25,12 +25,14 @@ SOFTWARE.
 package tssh
 
 import (
+	"fmt"
 	"net"
 	"strings"
 	"time"
 
 	"github.com/Microsoft/go-winio"
 	"github.com/trzsz/pageant"
+	"golang.org/x/sys/windows"
 )
 
 const kSshAgentAddr = `\\.\pipe\openssh-ssh-agent`
@@ -57,3 +59,23 @@ func dialAgent(addr string) (net.Conn, error) {
 	}
 	return net.DialTimeout("unix", addr, time.Second)
 }
+
+// getUserSocketPath returns a named pipe path for the current user.
+func getUserSocketPath(name string) (string, error) {
+	tokenUser, err := windows.GetCurrentProcessToken().GetTokenUser()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf(`\\.\pipe\%s-%s`, name, tokenUser.User.Sid.String()), nil
+}
+
+func listenAgent(addr string) (net.Listener, error) {
+	tokenUser, err := windows.GetCurrentProcessToken().GetTokenUser()
+	if err != nil {
+		return nil, err
+	}
+	// only the current user can access the agent pipe
+	return winio.ListenPipe(addr, &winio.PipeConfig{
+		SecurityDescriptor: fmt.Sprintf("D:P(A;;GA;;;%s)", tokenUser.User.Sid.String()),
+	})
+}
@@ -88,7 +88,7 @@ type sshArgs struct {
 	Debug          bool        `arg:"--debug" help:"verbose mode for debugging, same as ssh's -vvv"`
 	Zmodem         bool        `arg:"--zmodem" help:"enable zmodem lrzsz ( rz / sz ) feature"`
 	RemoveHostKey  bool        `arg:"--remove-host-key" help:"remove the known_hosts entry before connecting"`
-	DNS            string      `arg:"--dns" placeholder:"[udp://|tcp://]host[:port]" help:"custom DNS server"`
+	DNS            string      `arg:"--dns" placeholder:"[udp://|tcp://|tls://]host[:port]|https://url" help:"custom DNS server"`
 	TCP            bool        `arg:"--tcp" help:"force standard TCP SSH (overrides UdpMode)"`
 	UDP            bool        `arg:"--udp" help:"ssh over UDP like mosh (default: QUIC)"`
 	KCP            bool        `arg:"--kcp" help:"[udp] use KCP protocol for ssh over UDP"`
@@ -98,7 +98,15 @@ type sshArgs struct {
 	TsshdPort      string      `arg:"--tsshd-port" placeholder:"low-high" help:"[udp] port range that tsshd listens on"`
 	NewHost        bool        `arg:"--new-host" help:"[tools] add new host to configuration"`
 	EncSecret      bool        `arg:"--enc-secret" help:"[tools] encode secret for configuration"`
+	MigrateSecrets bool        `arg:"--migrate-secrets" help:"[tools] re-encode secrets in configuration with the master key"`
+	MasterKeyCache bool        `arg:"--master-key-cache" help:"[tools] cache the master key for the session (internal use)"`
 	ListHosts      bool        `arg:"--list-hosts" help:"[tools] list all hosts in configuration"`
+	KnownHosts     bool        `arg:"--known-hosts" help:"[tools] manage known hosts, run without arguments for usage"`
+	SSHFP          bool        `arg:"--sshfp" help:"[tools] print SSHFP records of the host keys of the destination"`
+	SSHFPCheck     bool        `arg:"--sshfp-check" help:"[tools] compare the SSHFP records with the ones in DNS"`
+	Agent          bool        `arg:"--agent" help:"[tools] run the built-in ssh agent"`
+	AgentSocket    string      `arg:"--agent-socket" placeholder:"path" help:"[tools] the socket path of the built-in agent"`
+	AgentLifetime  string      `arg:"--agent-lifetime" placeholder:"time" help:"[tools] default lifetime of keys in the built-in agent"`
 	InstallTrzsz   bool        `arg:"--install-trzsz" help:"[tools] install trzsz to the remote server"`
 	InstallTsshd   bool        `arg:"--install-tsshd" help:"[tools] install tsshd to the remote server"`
 	InstallPath    string      `arg:"--install-path" placeholder:"path" help:"[tools] install path, default: '~/.local/bin/'"`
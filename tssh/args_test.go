@@ -109,6 +109,10 @@ func TestSshArgs(t *testing.T) {
 
 	assertArgsEqual("--new-host", sshArgs{NewHost: true})
 	assertArgsEqual("--enc-secret", sshArgs{EncSecret: true})
+	assertArgsEqual("--agent --agent-lifetime 1h -i id_rsa", sshArgs{Agent: true, AgentLifetime: "1h", Identity: multiStr{[]string{"id_rsa"}}})
+	assertArgsEqual("--agent --agent-socket /tmp/agent.sock", sshArgs{Agent: true, AgentSocket: "/tmp/agent.sock"})
+	assertArgsEqual("--migrate-secrets", sshArgs{MigrateSecrets: true})
+	assertArgsEqual("--master-key-cache", sshArgs{MasterKeyCache: true})
 	assertArgsEqual("--install-trzsz", sshArgs{InstallTrzsz: true})
 	assertArgsEqual("--install-tsshd", sshArgs{InstallTsshd: true})
 	assertArgsEqual("--install-trzsz --install-path /bin", sshArgs{InstallTrzsz: true, InstallPath: "/bin"})
@@ -0,0 +1,110 @@
+/*
+MIT License
+
+Copyright (c) 2023-2026 The Trzsz SSH Authors.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package tssh
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync/atomic"
+)
+
+var batchMode atomic.Bool
+
+// setupBatchMode enables or disables the interactive prompts according to the BatchMode of the connection,
+// and returns a function to restore the previous mode, so it does not leak to the other connections.
+func setupBatchMode(args *sshArgs) func() {
+	enabled := strings.EqualFold(getOptionConfig(args, "BatchMode"), "yes")
+	if enabled {
+		debug("batch mode enabled, interactive prompts are disabled")
+	}
+	previous := batchMode.Swap(enabled)
+	return func() { batchMode.Store(previous) }
+}
+
+type batchModeError struct {
+	prompt string
+}
+
+func (e *batchModeError) Error() string {
+	return fmt.Sprintf("cannot prompt for [%s] in batch mode", e.prompt)
+}
+
+func newBatchModeError(prompt string) error {
+	prompt = strings.TrimSpace(strings.ReplaceAll(prompt, "\r\n", " "))
+	return &batchModeError{strings.TrimSuffix(prompt, ":")}
+}
+
+// getAskpassProgram returns the SSH_ASKPASS program if it should be used,
+// according to SSH_ASKPASS_REQUIRE like OpenSSH:
+//
+//	never:  never use the askpass program.
+//	force:  always use the askpass program, even if there is a terminal or DISPLAY is not set.
+//	prefer: use the askpass program instead of the terminal, only if DISPLAY is set.
+//	unset:  use the askpass program only if there is no terminal and DISPLAY is set.
+func getAskpassProgram() string {
+	askpass := os.Getenv("SSH_ASKPASS")
+	if askpass == "" {
+		return ""
+	}
+	require := strings.ToLower(os.Getenv("SSH_ASKPASS_REQUIRE"))
+	switch require {
+	case "never":
+		return ""
+	case "force":
+		return askpass
+	}
+	if runtime.GOOS != "windows" && os.Getenv("DISPLAY") == "" && os.Getenv("WAYLAND_DISPLAY") == "" {
+		return ""
+	}
+	if require == "prefer" {
+		return askpass
+	}
+	if isTerminal {
+		return ""
+	}
+	if _, closer, err := getKeyboardInput(); err == nil {
+		closer()
+		return ""
+	}
+	return askpass
+}
+
+func runAskpass(askpass, prompt string, confirm bool) ([]byte, error) {
+	prompt = strings.TrimSpace(strings.ReplaceAll(prompt, "\r\n", "\n"))
+	cmd := exec.Command(askpass, prompt)
+	cmd.Stderr = os.Stderr
+	if confirm {
+		cmd.Env = append(os.Environ(), "SSH_ASKPASS_PROMPT=confirm")
+	}
+	debug("run askpass [%s] for [%s]", askpass, prompt)
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("askpass [%s] canceled or failed: %v", askpass, err)
+	}
+	return []byte(strings.TrimRight(string(output), "\r\n")), nil
+}
@@ -0,0 +1,106 @@
+/*
+MIT License
+
+Copyright (c) 2023-2026 The Trzsz SSH Authors.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package tssh
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAskpass(t *testing.T) {
+	assert := assert.New(t)
+
+	t.Setenv("SSH_ASKPASS", "")
+	t.Setenv("SSH_ASKPASS_REQUIRE", "force")
+	assert.Equal("", getAskpassProgram())
+
+	t.Setenv("SSH_ASKPASS", "/usr/bin/ssh-askpass")
+	t.Setenv("DISPLAY", "")
+	t.Setenv("WAYLAND_DISPLAY", "")
+	assert.Equal("/usr/bin/ssh-askpass", getAskpassProgram())
+	t.Setenv("SSH_ASKPASS_REQUIRE", "prefer")
+	if runtime.GOOS != "windows" {
+		assert.Equal("", getAskpassProgram())
+	}
+	t.Setenv("DISPLAY", ":0")
+	assert.Equal("/usr/bin/ssh-askpass", getAskpassProgram())
+	t.Setenv("SSH_ASKPASS_REQUIRE", "never")
+	assert.Equal("", getAskpassProgram())
+
+	if runtime.GOOS != "windows" {
+		secret, err := runAskpass("echo", "user@host's password: ", false)
+		assert.Nil(err)
+		assert.Equal("user@host's password:", string(secret))
+		_, err = runAskpass("false", "password: ", true)
+		assert.NotNil(err)
+	}
+
+	defer batchMode.Store(batchMode.Load())
+	batchMode.Store(true)
+	_, err := readSecret("(user@host) Verification code:\r\n")
+	assert.EqualError(err, "cannot prompt for [(user@host) Verification code] in batch mode")
+}
+
+func TestBatchModeConfig(t *testing.T) {
+	assert := assert.New(t)
+	configPath := filepath.Join(t.TempDir(), "config")
+	require.NoError(t, os.WriteFile(configPath, []byte("Host batch\n    BatchMode yes\n"), 0600))
+	oriUserConfig := userConfig
+	userConfig = &tsshConfig{configPath: configPath}
+	defer func() { userConfig = oriUserConfig }()
+
+	// BatchMode in ssh_config skips the interactive prediction
+	dest, quit, err := chooseOrPredictDest(&sshArgs{Destination: "batch"})
+	assert.NoError(err)
+	assert.False(quit)
+	assert.Equal("batch", dest)
+}
+
+func TestSetupBatchMode(t *testing.T) {
+	assert := assert.New(t)
+	oriUserConfig := userConfig
+	userConfig = &tsshConfig{}
+	defer func() { userConfig = oriUserConfig }()
+	defer batchMode.Store(batchMode.Load())
+	batchMode.Store(false)
+
+	args := &sshArgs{}
+	require.NoError(t, args.Option.UnmarshalText([]byte("BatchMode=yes")))
+	reset := setupBatchMode(args)
+	assert.True(batchMode.Load())
+
+	// a connection without BatchMode is not affected by the previous one
+	resetHop := setupBatchMode(&sshArgs{})
+	assert.False(batchMode.Load())
+	resetHop()
+	assert.True(batchMode.Load())
+	reset()
+	assert.False(batchMode.Load())
+}
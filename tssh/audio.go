@@ -0,0 +1,122 @@
+/*
+MIT License
+
+Copyright (c) 2023-2026 The Trzsz SSH Authors.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package tssh
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// enableAudioForward forwards the local PulseAudio ( or PipeWire-pulse ) native socket
+// to a per-session socket on the remote host, copies the local pulse cookie if any,
+// and returns the PULSE_SERVER and PULSE_COOKIE envs for them.
+func enableAudioForward(sshConn *sshConnection) ([]*sshEnv, error) {
+	args := sshConn.param.args
+	if !strings.EqualFold(getExOptionConfig(args, "EnableAudioForward"), "yes") {
+		return nil, nil
+	}
+
+	clientSocket := getLocalPulseSocket(args)
+	if clientSocket == "" {
+		return nil, fmt.Errorf("local pulse server socket not found, you can specify it through AudioSocketPath")
+	}
+	if _, err := os.Stat(clientSocket); err != nil {
+		return nil, fmt.Errorf("local pulse server socket [%s] is not available: %v", clientSocket, err)
+	}
+
+	token, err := generateRandomToken(8)
+	if err != nil {
+		return nil, fmt.Errorf("generate random token for audio forwarding failed: %v", err)
+	}
+	serverSocket := fmt.Sprintf("/tmp/tssh-pulse-%s.sock", token)
+
+	if err := remoteForwardSocket(sshConn.client, clientSocket, serverSocket); err != nil {
+		return nil, fmt.Errorf("remote forward socket for audio failed: %v", err)
+	}
+	removeRemoteFilesOnClose(sshConn.client, serverSocket)
+	debug("audio forwarding from [%s] to remote [%s]", clientSocket, serverSocket)
+
+	var serverCookie string
+	if clientCookie := getLocalPulseCookie(); clientCookie != "" {
+		if cookie, err := os.ReadFile(clientCookie); err != nil {
+			warning("read local pulse cookie [%s] failed: %v", clientCookie, err)
+		} else {
+			serverCookie = fmt.Sprintf("/tmp/tssh-pulse-%s.cookie", token)
+			removeRemoteFilesOnClose(sshConn.client, serverCookie)
+			if err := writeRemoteFile(sshConn.client, serverCookie, cookie, 0600); err != nil {
+				warning("copy local pulse cookie [%s] failed: %v", clientCookie, err)
+				serverCookie = ""
+			}
+		}
+	}
+
+	return getAudioEnvs(serverSocket, serverCookie), nil
+}
+
+func getAudioEnvs(serverSocket, serverCookie string) []*sshEnv {
+	envs := []*sshEnv{{"PULSE_SERVER", "unix:" + serverSocket}}
+	if serverCookie != "" {
+		envs = append(envs, &sshEnv{"PULSE_COOKIE", serverCookie})
+	}
+	return envs
+}
+
+// getLocalPulseCookie returns the cookie file used by the local pulse clients, or empty if there is none.
+func getLocalPulseCookie() string {
+	if cookie := os.Getenv("PULSE_COOKIE"); cookie != "" {
+		return resolveHomeDir(cookie)
+	}
+	configHome := os.Getenv("XDG_CONFIG_HOME")
+	if configHome == "" {
+		configHome = filepath.Join(userHomeDir, ".config")
+	}
+	for _, path := range []string{filepath.Join(configHome, "pulse", "cookie"), filepath.Join(userHomeDir, ".pulse-cookie")} {
+		if isFileExist(path) {
+			return path
+		}
+	}
+	return ""
+}
+
+func getLocalPulseSocket(args *sshArgs) string {
+	if path := getExOptionConfig(args, "AudioSocketPath"); path != "" {
+		return resolveHomeDir(strings.TrimPrefix(path, "unix:"))
+	}
+	if server := os.Getenv("PULSE_SERVER"); strings.HasPrefix(server, "unix:") {
+		return strings.TrimPrefix(server, "unix:")
+	}
+	if runtimeDir := os.Getenv("PULSE_RUNTIME_PATH"); runtimeDir != "" {
+		return filepath.Join(runtimeDir, "native")
+	}
+	if runtimeDir := os.Getenv("XDG_RUNTIME_DIR"); runtimeDir != "" {
+		return filepath.Join(runtimeDir, "pulse", "native")
+	}
+	if uid := os.Getuid(); uid >= 0 {
+		return fmt.Sprintf("/run/user/%d/pulse/native", uid)
+	}
+	return ""
+}
@@ -0,0 +1,113 @@
+/*
+MIT License
+
+Copyright (c) 2023-2026 The Trzsz SSH Authors.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package tssh
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalPulseSocket(t *testing.T) {
+	oriUserConfig := userConfig
+	userConfig = &tsshConfig{}
+	defer func() { userConfig = oriUserConfig }()
+
+	type testCase struct {
+		name    string
+		option  string
+		server  string
+		runtime string
+		xdg     string
+		socket  string
+	}
+	tests := []testCase{
+		{"AudioSocketPath", "/tmp/pulse.sock", "unix:/tmp/server.sock", "/tmp/runtime", "/tmp/xdg", "/tmp/pulse.sock"},
+		{"AudioSocketPath with unix prefix", "unix:/tmp/pulse.sock", "", "", "", "/tmp/pulse.sock"},
+		{"PULSE_SERVER", "", "unix:/tmp/server.sock", "/tmp/runtime", "/tmp/xdg", "/tmp/server.sock"},
+		{"PULSE_SERVER over tcp", "", "tcp:localhost:4713", "/tmp/runtime", "/tmp/xdg", filepath.Join("/tmp/runtime", "native")},
+		{"PULSE_RUNTIME_PATH", "", "", "/tmp/runtime", "/tmp/xdg", filepath.Join("/tmp/runtime", "native")},
+		{"XDG_RUNTIME_DIR", "", "", "", "/tmp/xdg", filepath.Join("/tmp/xdg", "pulse", "native")},
+	}
+	if runtime.GOOS != "windows" {
+		tests = append(tests, testCase{"default", "", "", "", "", fmt.Sprintf("/run/user/%d/pulse/native", os.Getuid())})
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("PULSE_SERVER", tt.server)
+			t.Setenv("PULSE_RUNTIME_PATH", tt.runtime)
+			t.Setenv("XDG_RUNTIME_DIR", tt.xdg)
+			args := &sshArgs{}
+			if tt.option != "" {
+				require.NoError(t, args.Option.UnmarshalText([]byte("AudioSocketPath="+tt.option)))
+			}
+			assert.Equal(t, tt.socket, getLocalPulseSocket(args))
+		})
+	}
+}
+
+func TestLocalPulseCookie(t *testing.T) {
+	assert := assert.New(t)
+	oriUserHomeDir := userHomeDir
+	userHomeDir = t.TempDir()
+	defer func() { userHomeDir = oriUserHomeDir }()
+	t.Setenv("PULSE_COOKIE", "")
+	t.Setenv("XDG_CONFIG_HOME", "")
+
+	assert.Equal("", getLocalPulseCookie())
+
+	legacy := filepath.Join(userHomeDir, ".pulse-cookie")
+	require.NoError(t, os.WriteFile(legacy, []byte("cookie"), 0600))
+	assert.Equal(legacy, getLocalPulseCookie())
+
+	cookie := filepath.Join(userHomeDir, ".config", "pulse", "cookie")
+	require.NoError(t, os.MkdirAll(filepath.Dir(cookie), 0700))
+	require.NoError(t, os.WriteFile(cookie, []byte("cookie"), 0600))
+	assert.Equal(cookie, getLocalPulseCookie())
+
+	t.Setenv("PULSE_COOKIE", "/tmp/my-cookie")
+	assert.Equal("/tmp/my-cookie", getLocalPulseCookie())
+}
+
+func TestAudioEnvCommand(t *testing.T) {
+	assert := assert.New(t)
+
+	envs := getAudioEnvs("/tmp/tssh-pulse-abc.sock", "")
+	assert.Equal([]*sshEnv{{"PULSE_SERVER", "unix:/tmp/tssh-pulse-abc.sock"}}, envs)
+	assert.Equal(`exec env PULSE_SERVER=unix:/tmp/tssh-pulse-abc.sock "$SHELL" -l`, getRemoteEnvCommand("", envs))
+
+	envs = getAudioEnvs("/tmp/tssh-pulse-abc.sock", "/tmp/tssh-pulse-abc.cookie")
+	assert.Equal([]*sshEnv{{"PULSE_SERVER", "unix:/tmp/tssh-pulse-abc.sock"},
+		{"PULSE_COOKIE", "/tmp/tssh-pulse-abc.cookie"}}, envs)
+	assert.Equal(`exec env PULSE_SERVER=unix:/tmp/tssh-pulse-abc.sock PULSE_COOKIE=/tmp/tssh-pulse-abc.cookie "$SHELL" -c 'paplay '"'"'a b.wav'"'"''`,
+		getRemoteEnvCommand("paplay 'a b.wav'", envs))
+
+	assert.Equal("ls -l", getRemoteEnvCommand("ls -l", nil))
+}
@@ -32,7 +32,9 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"regexp"
 	"slices"
+	"strconv"
 	"strings"
 	"sync"
 
@@ -46,10 +48,11 @@ type sshSigner interface {
 }
 
 type sshBaseSigner struct {
-	path   string
-	priKey []byte
-	pubKey ssh.PublicKey
-	signer ssh.Signer
+	path    string
+	priKey  []byte
+	pubKey  ssh.PublicKey
+	signer  ssh.Signer
+	options *signerOptions
 }
 
 type sshAlogSigner struct {
@@ -62,7 +65,7 @@ func (s *sshAlogSigner) Algorithms() []string {
 }
 
 func newSshSigner(path string, priKey []byte, pubKey ssh.PublicKey, signer ssh.Signer) sshSigner {
-	baseSigner := &sshBaseSigner{path, priKey, pubKey, signer}
+	baseSigner := &sshBaseSigner{path: path, priKey: priKey, pubKey: pubKey, signer: signer}
 
 	if pubKey != nil {
 		keyFormat := pubKey.Type()
@@ -101,7 +104,14 @@ func (s *sshBaseSigner) initSigner() error {
 			continue
 		}
 		if skErr, ok := err.(*unsupportedSecurityKeyError); ok {
-			s.signer, err = parseSecurityKey(s.path, skErr)
+			var skSigner *skSigner
+			skSigner, err = parseSecurityKey(s.path, skErr)
+			if err == nil {
+				skSigner.provider = s.options.getSkProvider()
+				s.signer = skSigner
+			}
+		} else if err == nil {
+			s.options.addKeyToAgent(s.path, s.priKey, secret)
 		}
 		if err != nil {
 			return err
@@ -159,7 +169,7 @@ func parsePublicKey(path string) ssh.PublicKey {
 	return key
 }
 
-func newPassphraseSigner(path string, priKey []byte, err *ssh.PassphraseMissingError) sshSigner {
+func newPassphraseSigner(path string, priKey []byte, err *ssh.PassphraseMissingError, options *signerOptions) sshSigner {
 	pubKey := err.PublicKey
 	if pubKey == nil {
 		pubKey = parsePublicKey(path + ".pub")
@@ -167,10 +177,47 @@ func newPassphraseSigner(path string, priKey []byte, err *ssh.PassphraseMissingE
 			return nil
 		}
 	}
-	return newSshSigner(path, priKey, pubKey, nil)
+	signer := newSshSigner(path, priKey, pubKey, nil)
+	if baseSigner, ok := signer.(*sshBaseSigner); ok {
+		baseSigner.options = options
+	}
+	return signer
+}
+
+// signerOptions are the options of the host for the signers loaded from key files.
+type signerOptions struct {
+	skProvider     string
+	addKeysToAgent string
+	agentAddr      string
+	addToAgent     func(path string, priKey, passphrase []byte)
+}
+
+func getSignerOptions(param *sshParam) *signerOptions {
+	options := &signerOptions{
+		skProvider:     getSecurityKeyProvider(param.args),
+		addKeysToAgent: getOptionConfig(param.args, "AddKeysToAgent"),
+		addToAgent:     getAddKeysToAgent(param),
+	}
+	if options.addToAgent != nil {
+		options.agentAddr, _ = getAgentAddr(param)
+	}
+	return options
+}
+
+func (o *signerOptions) getSkProvider() string {
+	if o == nil {
+		return ""
+	}
+	return o.skProvider
 }
 
-func getSigner(param *sshParam, path string) sshSigner {
+func (o *signerOptions) addKeyToAgent(path string, priKey, passphrase []byte) {
+	if o != nil && o.addToAgent != nil {
+		o.addToAgent(path, priKey, passphrase)
+	}
+}
+
+func getSigner(param *sshParam, path string, options *signerOptions) sshSigner {
 	privateKey, err := os.ReadFile(path)
 	if err != nil {
 		warning("read private key [%s] failed: %v", path, err)
@@ -181,12 +228,20 @@ func getSigner(param *sshParam, path string) sshSigner {
 		if e, ok := err.(*ssh.PassphraseMissingError); ok {
 			if passphrase := getSecretConfig(param, "Passphrase"); passphrase != "" {
 				signer, err = parsePrivateKeyWithPassphrase(privateKey, []byte(passphrase))
+				if err == nil {
+					options.addKeyToAgent(path, privateKey, []byte(passphrase))
+				}
 			} else {
-				return newPassphraseSigner(path, privateKey, e)
+				return newPassphraseSigner(path, privateKey, e, options)
 			}
 		}
 		if skErr, ok := err.(*unsupportedSecurityKeyError); ok {
-			signer, err = parseSecurityKey(path, skErr)
+			var skSigner *skSigner
+			skSigner, err = parseSecurityKey(path, skErr)
+			if err == nil {
+				skSigner.provider = options.getSkProvider()
+				signer = skSigner
+			}
 		}
 		if err != nil {
 			warning("parse private key [%s] failed: %v", path, err)
@@ -196,9 +251,20 @@ func getSigner(param *sshParam, path string) sshSigner {
 	return newSshSigner(path, nil, signer.PublicKey(), signer)
 }
 
-func appendSignerCerts(path string, signer sshSigner, certFiles []string) []sshSigner {
+func appendSignerCerts(path string, signer sshSigner, certFiles []string, certCommand *certificateCommand) []sshSigner {
 	signers := []sshSigner{signer}
 
+	addCert := func(cert *ssh.Certificate, certPath string) {
+		certSigner, err := ssh.NewCertSigner(cert, signer)
+		if err != nil {
+			// Most commonly: cert doesn't match the private key. That's not fatal if
+			// multiple CertificateFile entries are configured.
+			debug("new cert signer [%s] failed: %v", certPath, err)
+			return
+		}
+		signers = append(signers, newSshSigner(path, nil, certSigner.PublicKey(), certSigner))
+	}
+
 	tryAddCert := func(certPath string) {
 		if certPath == "" {
 			return
@@ -222,14 +288,15 @@ func appendSignerCerts(path string, signer sshSigner, certFiles []string) []sshS
 			warning("public cert [%s] can't be converted to ssh.Certificate", certPath)
 			return
 		}
-		certSigner, err := ssh.NewCertSigner(cert, signer)
-		if err != nil {
-			// Most commonly: cert doesn't match the private key. That's not fatal if
-			// multiple CertificateFile entries are configured.
-			debug("new cert signer [%s] failed: %v", certPath, err)
-			return
+		addCert(cert, certPath)
+	}
+
+	if certCommand != nil {
+		if _, ok := signer.PublicKey().(*ssh.Certificate); !ok {
+			if cert := certCommand.issue(signer.PublicKey()); cert != nil {
+				addCert(cert, "CertificateCommand")
+			}
 		}
-		signers = append(signers, newSshSigner(path, nil, certSigner.PublicKey(), certSigner))
 	}
 
 	for _, certFile := range certFiles {
@@ -245,6 +312,13 @@ func appendSignerCerts(path string, signer sshSigner, certFiles []string) []sshS
 }
 
 func readSecret(prompt string) ([]byte, error) {
+	if batchMode.Load() {
+		return nil, newBatchModeError(prompt)
+	}
+	if askpass := getAskpassProgram(); askpass != "" {
+		return runAskpass(askpass, prompt, false)
+	}
+
 	_, _ = os.Stderr.WriteString(prompt)
 	defer func() { _, _ = os.Stderr.WriteString("\r\n") }()
 
@@ -265,6 +339,7 @@ func getPasswordAuthMethod(param *sshParam) ssh.AuthMethod {
 
 	idx := 0
 	rememberPassword := false
+	prompts := getNumberOfPasswordPrompts(param.args)
 	return ssh.RetryableAuthMethod(ssh.PasswordCallback(func() (string, error) {
 		idx++
 		if idx == 1 {
@@ -280,15 +355,60 @@ func getPasswordAuthMethod(param *sshParam) ssh.AuthMethod {
 		} else if idx == 2 && rememberPassword {
 			warning("the password configuration for '%s' is incorrect", param.args.Destination)
 		}
+		if prompts == 0 {
+			return "", fmt.Errorf("password prompt is disabled by NumberOfPasswordPrompts")
+		}
 		secret, err := readSecret(fmt.Sprintf("%s@%s's password: ", param.user, param.host))
 		if err != nil {
 			return "", err
 		}
 		return string(secret), nil
-	}), 3)
+	}), max(prompts, 1))
+}
+
+// readQuestionRuleAnswer answers the question by the first matching QuestionPattern rule, which is
+// useful when the question contains a changing challenge, a timestamp or the username.
+func readQuestionRuleAnswer(param *sshParam, question string) string {
+	for _, idx := range getExOptionIndexes(param.args, "QuestionPattern") {
+		key := fmt.Sprintf("QuestionPattern%d", idx)
+		pattern := getExOptionConfig(param.args, key)
+		if pattern == "" {
+			continue
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			warning("compile %s [%s] failed: %v", key, pattern, err)
+			continue
+		}
+		if !re.MatchString(question) {
+			continue
+		}
+		if answer := getSecretConfig(param, fmt.Sprintf("QuestionPatternAnswer%d", idx)); answer != "" {
+			debug("question '%s' is answered by %s [%s] with the static answer", question, key, pattern)
+			return answer
+		}
+		if secret := getSecretConfig(param, fmt.Sprintf("QuestionPatternTotp%d", idx)); secret != "" {
+			if answer := getOtpCode(secret); answer != "" {
+				debug("question '%s' is answered by %s [%s] with the totp secret", question, key, pattern)
+				return answer
+			}
+		}
+		if command := getExOptionConfig(param.args, fmt.Sprintf("QuestionPatternOtp%d", idx)); command != "" {
+			if answer := getOtpCommandOutput(command, question); answer != "" {
+				debug("question '%s' is answered by %s [%s] with the otp command", question, key, pattern)
+				return answer
+			}
+		}
+		debug("question '%s' matches %s [%s] but there is no answer", question, key, pattern)
+	}
+	return ""
 }
 
 func readQuestionAnswerConfig(param *sshParam, idx int, question string) string {
+	if answer := readQuestionRuleAnswer(param, question); answer != "" {
+		return answer
+	}
+
 	qhex := hex.EncodeToString([]byte(question))
 	debug("the hex code for question '%s' is %s", question, qhex)
 	if answer := getSecretConfig(param, qhex); answer != "" {
@@ -296,7 +416,7 @@ func readQuestionAnswerConfig(param *sshParam, idx int, question string) string
 	}
 
 	if secret := getSecretConfig(param, "totp"+qhex); secret != "" {
-		if answer := getTotpCode(secret); answer != "" {
+		if answer := getOtpCode(secret); answer != "" {
 			return answer
 		}
 	}
@@ -316,7 +436,7 @@ func readQuestionAnswerConfig(param *sshParam, idx int, question string) string
 	qsecret := fmt.Sprintf("TotpSecret%d", idx)
 	debug("the totp secret key for question '%s' is %s", question, qsecret)
 	if secret := getSecretConfig(param, qsecret); secret != "" {
-		if answer := getTotpCode(secret); answer != "" {
+		if answer := getOtpCode(secret); answer != "" {
 			return answer
 		}
 	}
@@ -339,6 +459,7 @@ func getKeyboardInteractiveAuthMethod(param *sshParam) ssh.AuthMethod {
 	}
 
 	idx := 0
+	prompts := getNumberOfPasswordPrompts(param.args)
 	questionSeen := make(map[string]struct{})
 	questionTried := make(map[string]struct{})
 	questionWarned := make(map[string]struct{})
@@ -361,6 +482,9 @@ func getKeyboardInteractiveAuthMethod(param *sshParam) ssh.AuthMethod {
 						warning("the question answer configuration of '%s' for '%s' is incorrect", question, param.args.Destination)
 					}
 				}
+				if prompts == 0 {
+					return nil, fmt.Errorf("keyboard interactive prompt is disabled by NumberOfPasswordPrompts")
+				}
 				secret, err := readSecret(fmt.Sprintf("(%s@%s) %s", param.user, param.host, strings.ReplaceAll(question, "\n", "\r\n")))
 				if err != nil {
 					return nil, err
@@ -368,24 +492,31 @@ func getKeyboardInteractiveAuthMethod(param *sshParam) ssh.AuthMethod {
 				answers = append(answers, string(secret))
 			}
 			return answers, nil
-		}), 3)
+		}), max(prompts, 1))
 }
 
-var getDefaultSigners = func() func() []sshSigner {
-	var once sync.Once
-	var signers []sshSigner
-	return func() []sshSigner {
-		once.Do(func() {
-			for _, name := range []string{"id_rsa", "id_ecdsa", "id_ecdsa_sk", "id_ed25519", "id_ed25519_sk", "identity"} {
-				path := filepath.Join(userHomeDir, ".ssh", name)
-				if !isFileExist(path) {
-					continue
-				}
-				if signer := getSigner(&sshParam{args: &sshArgs{Destination: name}}, path); signer != nil {
-					signers = append(signers, signer)
-				}
+var getDefaultSigners = func() func(options *signerOptions) []sshSigner {
+	var mutex sync.Mutex
+	cache := make(map[string][]sshSigner)
+	return func(options *signerOptions) []sshSigner {
+		mutex.Lock()
+		defer mutex.Unlock()
+		// the signers add the decrypted keys to the agent of the options, so the agent is a part of the key
+		cacheKey := options.skProvider + "\n" + options.addKeysToAgent + "\n" + options.agentAddr
+		if signers, ok := cache[cacheKey]; ok {
+			return signers
+		}
+		var signers []sshSigner
+		for _, name := range []string{"id_rsa", "id_ecdsa", "id_ecdsa_sk", "id_ed25519", "id_ed25519_sk", "identity"} {
+			path := filepath.Join(userHomeDir, ".ssh", name)
+			if !isFileExist(path) {
+				continue
 			}
-		})
+			if signer := getSigner(&sshParam{args: &sshArgs{Destination: name}}, path, options); signer != nil {
+				signers = append(signers, signer)
+			}
+		}
+		cache[cacheKey] = signers
 		return signers
 	}
 }()
@@ -422,8 +553,14 @@ func getPublicKeysAuthMethod(param *sshParam) ssh.AuthMethod {
 			}
 		}
 	}
+	certCommand := getCertificateCommand(param)
+	signerOptions := getSignerOptions(param)
 	addSignerWithCerts := func(path string, signer sshSigner) {
-		addPubKeySigners(appendSignerCerts(path, signer, certFiles))
+		addPubKeySigners(appendSignerCerts(path, signer, certFiles, nil))
+	}
+	// CertificateCommand only issues certificates for the identity keys, not for the agent or PKCS#11 keys
+	addIdentityWithCerts := func(path string, signer sshSigner) {
+		addPubKeySigners(appendSignerCerts(path, signer, certFiles, certCommand))
 	}
 
 	var identities []string
@@ -456,6 +593,10 @@ func getPublicKeysAuthMethod(param *sshParam) ssh.AuthMethod {
 		}
 	}
 
+	for _, signer := range getPkcs11Signers(param) {
+		addSignerWithCerts("", signer)
+	}
+
 	if len(identities) > 0 {
 	out:
 		for _, path := range identities {
@@ -467,23 +608,23 @@ func getPublicKeysAuthMethod(param *sshParam) ssh.AuthMethod {
 				if pubKey := parsePublicKey(pubPath); pubKey != nil {
 					for _, agentSigner := range agentSigners {
 						if bytes.Equal(pubKey.Marshal(), agentSigner.PublicKey().Marshal()) {
-							addSignerWithCerts("", newSshSigner(path+" (agent)", nil, pubKey, agentSigner))
+							addIdentityWithCerts("", newSshSigner(path+" (agent)", nil, pubKey, agentSigner))
 							continue out
 						}
 					}
 				}
 			}
-			signer := getSigner(param, path)
+			signer := getSigner(param, path, signerOptions)
 			if signer == nil {
 				continue
 			}
 			for _, agentSigner := range agentSigners {
 				if bytes.Equal(signer.PublicKey().Marshal(), agentSigner.PublicKey().Marshal()) {
-					addSignerWithCerts(signer.getPath(), newSshSigner(signer.getPath()+" (agent)", nil, signer.PublicKey(), agentSigner))
+					addIdentityWithCerts(signer.getPath(), newSshSigner(signer.getPath()+" (agent)", nil, signer.PublicKey(), agentSigner))
 					continue out
 				}
 			}
-			addSignerWithCerts(signer.getPath(), signer)
+			addIdentityWithCerts(signer.getPath(), signer)
 		}
 	}
 
@@ -494,8 +635,8 @@ func getPublicKeysAuthMethod(param *sshParam) ssh.AuthMethod {
 	}
 
 	if len(identities) == 0 {
-		for _, signer := range getDefaultSigners() {
-			addSignerWithCerts(signer.getPath(), signer)
+		for _, signer := range getDefaultSigners(signerOptions) {
+			addIdentityWithCerts(signer.getPath(), signer)
 		}
 	}
 
@@ -505,23 +646,89 @@ func getPublicKeysAuthMethod(param *sshParam) ssh.AuthMethod {
 	return ssh.PublicKeys(pubKeySigners...)
 }
 
-func getAuthMethods(param *sshParam) []ssh.AuthMethod {
-	var authMethods []ssh.AuthMethod
-	if authMethod := getPublicKeysAuthMethod(param); authMethod != nil {
-		debug("add auth method: public key authentication")
-		authMethods = append(authMethods, authMethod)
+var kDefaultPreferredAuthentications = []string{"publickey", "gssapi-with-mic", "keyboard-interactive", "password"}
+
+type sshAuthMethod struct {
+	name   string
+	method ssh.AuthMethod
+}
+
+func getPreferredAuthentications(args *sshArgs) []string {
+	preferred := getOptionConfig(args, "PreferredAuthentications")
+	if preferred == "" {
+		return kDefaultPreferredAuthentications
 	}
-	if authMethod := getGSSAPIWithMICAuthMethod(param); authMethod != nil {
-		debug("add auth method: gssapi-with-mic authentication")
-		authMethods = append(authMethods, authMethod)
+	var names []string
+	for name := range strings.SplitSeq(preferred, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" || slices.Contains(names, name) {
+			continue
+		}
+		if !slices.Contains(kDefaultPreferredAuthentications, name) {
+			debug("unsupported preferred authentication: %s", name)
+			continue
+		}
+		names = append(names, name)
 	}
-	if authMethod := getKeyboardInteractiveAuthMethod(param); authMethod != nil {
-		debug("add auth method: keyboard interactive authentication")
-		authMethods = append(authMethods, authMethod)
+	return names
+}
+
+func getNumberOfPasswordPrompts(args *sshArgs) int {
+	if value := getOptionConfig(args, "NumberOfPasswordPrompts"); value != "" {
+		prompts, err := strconv.Atoi(value)
+		if err != nil || prompts < 0 {
+			warning("NumberOfPasswordPrompts [%s] invalid", value)
+		} else {
+			return prompts
+		}
 	}
-	if authMethod := getPasswordAuthMethod(param); authMethod != nil {
-		debug("add auth method: password authentication")
-		authMethods = append(authMethods, authMethod)
+	return 3
+}
+
+func getAuthMethods(param *sshParam) []*sshAuthMethod {
+	var authMethods []*sshAuthMethod
+	for _, name := range getPreferredAuthentications(param.args) {
+		var authMethod ssh.AuthMethod
+		switch name {
+		case "publickey":
+			authMethod = getPublicKeysAuthMethod(param)
+		case "gssapi-with-mic":
+			authMethod = getGSSAPIWithMICAuthMethod(param)
+		case "keyboard-interactive":
+			authMethod = getKeyboardInteractiveAuthMethod(param)
+		case "password":
+			authMethod = getPasswordAuthMethod(param)
+		}
+		if authMethod != nil {
+			debug("add auth method: %s authentication", name)
+			authMethods = append(authMethods, &sshAuthMethod{name, authMethod})
+		}
 	}
 	return authMethods
 }
+
+// getAuthCallback chooses the next auth method in the preferred order from the methods
+// the server allows, which supports the servers requiring multiple methods in sequence.
+func getAuthCallback(param *sshParam, authMethods []*sshAuthMethod) func(*ssh.ClientAuthContext) (ssh.AuthMethod, error) {
+	var lastMethod string
+	var partialCount int
+	return func(ctx *ssh.ClientAuthContext) (ssh.AuthMethod, error) {
+		if len(ctx.PartialSuccessMethods) > partialCount {
+			partialCount = len(ctx.PartialSuccessMethods)
+			debug("auth method [%s] partially succeeded for [%s], the server requires more: %s",
+				lastMethod, param.args.Destination, strings.Join(ctx.AllowedMethods, ","))
+		} else if lastMethod != "" {
+			debug("auth method [%s] failed for [%s], the server allows: %s",
+				lastMethod, param.args.Destination, strings.Join(ctx.AllowedMethods, ","))
+		}
+		for _, authMethod := range authMethods {
+			if slices.Contains(ctx.TriedMethods, authMethod.name) || !slices.Contains(ctx.AllowedMethods, authMethod.name) {
+				continue
+			}
+			debug("trying auth method [%s] for [%s]", authMethod.name, param.args.Destination)
+			lastMethod = authMethod.name
+			return authMethod.method, nil
+		}
+		return nil, nil
+	}
+}
@@ -0,0 +1,209 @@
+/*
+MIT License
+
+Copyright (c) 2023-2026 The Trzsz SSH Authors.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package tssh
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+const kRemoteBrowserHelper = `#!/bin/sh
+sock='%s'
+url="$1"
+if [ -z "$url" ]; then
+	echo "usage: $0 URL" >&2
+	exit 1
+fi
+if command -v python3 >/dev/null 2>&1; then
+	exec python3 -c 'import socket,sys;s=socket.socket(socket.AF_UNIX);s.connect(sys.argv[1]);s.sendall(sys.argv[2].encode()+b"\n");s.close()' "$sock" "$url"
+elif command -v socat >/dev/null 2>&1; then
+	printf '%%s\n' "$url" | socat - "UNIX-CONNECT:$sock"
+else
+	printf '%%s\n' "$url" | nc -U "$sock"
+fi
+`
+
+type remoteBrowser struct {
+	command    string
+	allowList  []*regexp.Regexp
+	mutex      sync.Mutex
+	pendingURL string
+}
+
+// enableRemoteBrowser listens on a unix socket on the remote host, and writes a helper
+// script which sends the URL back through the socket. The returned envs set BROWSER to
+// the helper, so that the URLs opened by xdg-open or $BROWSER open in the local browser.
+func enableRemoteBrowser(sshConn *sshConnection) ([]*sshEnv, error) {
+	args := sshConn.param.args
+	if !strings.EqualFold(getExOptionConfig(args, "EnableRemoteBrowser"), "yes") {
+		return nil, nil
+	}
+
+	browser := &remoteBrowser{command: getExOptionConfig(args, "RemoteBrowserCommand")}
+	for _, pattern := range strings.Fields(getExOptionConfig(args, "RemoteBrowserAllowList")) {
+		expr := "^" + wildcardToRegexp(strings.ToLower(pattern)) + "$"
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			warning("compile RemoteBrowserAllowList [%s] regexp [%s] failed: %v", pattern, expr, err)
+			continue
+		}
+		browser.allowList = append(browser.allowList, re)
+	}
+
+	token, err := generateRandomToken(8)
+	if err != nil {
+		return nil, fmt.Errorf("generate random token for remote browser failed: %v", err)
+	}
+	serverSocket := fmt.Sprintf("/tmp/tssh-browser-%s.sock", token)
+	helperPath := fmt.Sprintf("/tmp/tssh-browser-%s", token)
+
+	listener, err := sshConn.client.Listen("unix", serverSocket)
+	if err != nil {
+		return nil, fmt.Errorf("remote listen on [%s] failed: %v", serverSocket, err)
+	}
+	removeRemoteFilesOnClose(sshConn.client, helperPath, serverSocket)
+
+	if err := writeRemoteBrowserHelper(sshConn.client, helperPath, serverSocket); err != nil {
+		_ = listener.Close()
+		return nil, err
+	}
+
+	sshConn.remoteBrowser = browser
+	go browser.serve(listener)
+
+	return []*sshEnv{{"BROWSER", helperPath}}, nil
+}
+
+func writeRemoteBrowserHelper(client SshClient, helperPath, serverSocket string) error {
+	return writeRemoteFile(client, helperPath, fmt.Appendf(nil, kRemoteBrowserHelper, serverSocket), 0700)
+}
+
+func (b *remoteBrowser) serve(listener net.Listener) {
+	defer func() { _ = listener.Close() }()
+	for {
+		conn, err := listener.Accept()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			debug("remote browser accept failed: %v", err)
+			break
+		}
+		go func() {
+			defer func() { _ = conn.Close() }()
+			_ = conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+			line, err := bufio.NewReader(io.LimitReader(conn, 8192)).ReadString('\n')
+			if err != nil && line == "" {
+				debug("remote browser read url failed: %v", err)
+				return
+			}
+			b.handleURL(strings.TrimSpace(line))
+		}()
+	}
+}
+
+func (b *remoteBrowser) handleURL(rawURL string) {
+	debug("remote browser request: %s", rawURL)
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		warning("The remote requests to open an unsupported URL: %s", rawURL)
+		return
+	}
+
+	if b.isAllowed(u) {
+		b.open(rawURL)
+		return
+	}
+
+	b.mutex.Lock()
+	b.pendingURL = rawURL
+	b.mutex.Unlock()
+	notice("The remote requests to open %s, press o in the tssh console to open it", rawURL)
+}
+
+func (b *remoteBrowser) isAllowed(u *url.URL) bool {
+	host := strings.ToLower(u.Hostname())
+	for _, re := range b.allowList {
+		if re.MatchString(host) {
+			return true
+		}
+	}
+	return false
+}
+
+// takePendingURL returns the URL waiting for the user's confirmation and clears it.
+func (b *remoteBrowser) takePendingURL() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	rawURL := b.pendingURL
+	b.pendingURL = ""
+	return rawURL
+}
+
+func (b *remoteBrowser) peekPendingURL() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.pendingURL
+}
+
+func (b *remoteBrowser) open(rawURL string) {
+	var argv []string
+	if b.command != "" {
+		var err error
+		argv, err = splitCommandLine(b.command)
+		if err != nil || len(argv) == 0 {
+			warning("split RemoteBrowserCommand [%s] failed: %v", b.command, err)
+			return
+		}
+		argv = append(argv, rawURL)
+	} else {
+		switch runtime.GOOS {
+		case "windows":
+			argv = []string{"rundll32", "url.dll,FileProtocolHandler", rawURL}
+		case "darwin":
+			argv = []string{"open", rawURL}
+		default:
+			argv = []string{"xdg-open", rawURL}
+		}
+	}
+
+	debug("open url in the local browser: %v", argv)
+	cmd := exec.Command(argv[0], argv[1:]...)
+	if err := cmd.Start(); err != nil {
+		warning("open [%s] in the local browser failed: %v", rawURL, err)
+		return
+	}
+	go func() { _ = cmd.Wait() }()
+	notice("Opened %s in the local browser", rawURL)
+}
@@ -0,0 +1,107 @@
+/*
+MIT License
+
+Copyright (c) 2023-2026 The Trzsz SSH Authors.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package tssh
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRemoteBrowser(t *testing.T) {
+	enableWarning := enableWarningLogging
+	enableWarningLogging = false
+	defer func() { enableWarningLogging = enableWarning }()
+
+	assert := assert.New(t)
+	browser := &remoteBrowser{}
+	for _, pattern := range []string{"localhost", "*.example.com"} {
+		browser.allowList = append(browser.allowList, regexp.MustCompile("^"+wildcardToRegexp(pattern)+"$"))
+	}
+
+	assertAllowed := func(rawURL string, allowed bool) {
+		t.Helper()
+		u, err := url.Parse(rawURL)
+		assert.Nil(err)
+		assert.Equal(allowed, browser.isAllowed(u))
+	}
+	assertAllowed("http://localhost:8080/callback?code=123", true)
+	assertAllowed("https://login.example.com/oauth", true)
+	assertAllowed("https://LOGIN.EXAMPLE.COM/oauth", true)
+	assertAllowed("https://example.com/", false)
+	assertAllowed("https://example.com.evil.org/", false)
+	assertAllowed("https://evil.org/?localhost", false)
+
+	for _, rawURL := range []string{"file:///etc/passwd", "javascript:alert(1)", "https://", "not a url"} {
+		browser.handleURL(rawURL)
+		assert.Equal("", browser.peekPendingURL())
+	}
+
+	browser.handleURL("https://dashboard.internal/")
+	assert.Equal("https://dashboard.internal/", browser.peekPendingURL())
+	assert.Equal("https://dashboard.internal/", browser.takePendingURL())
+	assert.Equal("", browser.takePendingURL())
+}
+
+func TestRemoteBrowserEnvCommand(t *testing.T) {
+	assert := assert.New(t)
+	envs := []*sshEnv{{"BROWSER", "/tmp/tssh-browser-abc"}}
+	assert.Equal(`exec env BROWSER=/tmp/tssh-browser-abc "$SHELL" -l`, getRemoteEnvCommand("", envs))
+	assert.Equal(`exec env BROWSER=/tmp/tssh-browser-abc "$SHELL" -c 'gh auth login'`, getRemoteEnvCommand("gh auth login", envs))
+}
+
+type fakeEnvSession struct {
+	SshSession
+	accepted map[string]bool
+	envs     map[string]string
+}
+
+func (s *fakeEnvSession) Setenv(name, value string) error {
+	if !s.accepted[name] {
+		return fmt.Errorf("env request %s rejected", name)
+	}
+	s.envs[name] = value
+	return nil
+}
+
+func TestSetRemoteEnvs(t *testing.T) {
+	assert := assert.New(t)
+	envs := []*sshEnv{{"BROWSER", "/tmp/tssh-browser-abc"}, {"PULSE_SERVER", "unix:/tmp/tssh-pulse-abc.sock"}}
+
+	// the env requests are the main path, nothing is left for the remote command
+	session := &fakeEnvSession{accepted: map[string]bool{"BROWSER": true, "PULSE_SERVER": true}, envs: map[string]string{}}
+	assert.Empty(setRemoteEnvs(session, envs))
+	assert.Equal(map[string]string{"BROWSER": "/tmp/tssh-browser-abc", "PULSE_SERVER": "unix:/tmp/tssh-pulse-abc.sock"}, session.envs)
+	assert.Equal("", getRemoteEnvCommand("", setRemoteEnvs(session, envs)))
+
+	// only the rejected envs are set through the remote command
+	session = &fakeEnvSession{accepted: map[string]bool{"PULSE_SERVER": true}, envs: map[string]string{}}
+	rejected := setRemoteEnvs(session, envs)
+	assert.Equal([]*sshEnv{envs[0]}, rejected)
+	assert.Equal(`exec env BROWSER=/tmp/tssh-browser-abc "$SHELL" -l`, getRemoteEnvCommand("", rejected))
+}
@@ -0,0 +1,167 @@
+/*
+MIT License
+
+Copyright (c) 2023-2026 The Trzsz SSH Authors.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package tssh
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// kCertificateRenewBefore renews the cached certificate a little before it expires.
+const kCertificateRenewBefore = time.Minute
+
+type certificateCommand struct {
+	command  string
+	cacheDir string
+}
+
+func getCertificateCommand(param *sshParam) *certificateCommand {
+	command := getExOptionConfig(param.args, "CertificateCommand")
+	if command == "" {
+		return nil
+	}
+	expanded, err := expandTokens(command, param, "%hnpru")
+	if err != nil {
+		warning("expand CertificateCommand [%s] failed: %v", command, err)
+		return nil
+	}
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		cacheDir = filepath.Join(userHomeDir, ".cache")
+	}
+	return &certificateCommand{command: expanded, cacheDir: filepath.Join(cacheDir, "tssh", "certs")}
+}
+
+func (c *certificateCommand) cachePath(pubKey ssh.PublicKey) string {
+	hash := sha256.Sum256(append([]byte(c.command+"\n"), pubKey.Marshal()...))
+	return filepath.Join(c.cacheDir, fmt.Sprintf("%x-cert.pub", hash))
+}
+
+func isCertificateValid(cert *ssh.Certificate, pubKey ssh.PublicKey) bool {
+	if !bytes.Equal(cert.Key.Marshal(), pubKey.Marshal()) {
+		return false
+	}
+	now := time.Now()
+	if cert.ValidAfter != 0 && now.Before(time.Unix(int64(cert.ValidAfter), 0)) {
+		return false
+	}
+	if cert.ValidBefore != ssh.CertTimeInfinity && now.Add(kCertificateRenewBefore).After(time.Unix(int64(cert.ValidBefore), 0)) {
+		return false
+	}
+	return true
+}
+
+func parseCertificate(data []byte) (*ssh.Certificate, error) {
+	certKey, _, _, _, err := ssh.ParseAuthorizedKey(data)
+	if err != nil {
+		return nil, err
+	}
+	cert, ok := certKey.(*ssh.Certificate)
+	if !ok {
+		return nil, fmt.Errorf("not a certificate but %s", certKey.Type())
+	}
+	return cert, nil
+}
+
+func (c *certificateCommand) loadCache(pubKey ssh.PublicKey) *ssh.Certificate {
+	path := c.cachePath(pubKey)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	cert, err := parseCertificate(data)
+	if err != nil {
+		debug("parse cached certificate [%s] failed: %v", path, err)
+		return nil
+	}
+	if !isCertificateValid(cert, pubKey) {
+		debug("cached certificate [%s] expired", path)
+		return nil
+	}
+	debug("use cached certificate [%s] valid before %s", path, time.Unix(int64(cert.ValidBefore), 0).Format(time.DateTime))
+	return cert
+}
+
+func (c *certificateCommand) saveCache(pubKey ssh.PublicKey, cert *ssh.Certificate) {
+	if err := os.MkdirAll(c.cacheDir, 0700); err != nil {
+		debug("mkdir certificate cache dir [%s] failed: %v", c.cacheDir, err)
+		return
+	}
+	path := c.cachePath(pubKey)
+	if err := os.WriteFile(path, ssh.MarshalAuthorizedKey(cert), 0600); err != nil {
+		debug("write certificate cache [%s] failed: %v", path, err)
+	}
+}
+
+func (c *certificateCommand) issue(pubKey ssh.PublicKey) *ssh.Certificate {
+	if cert := c.loadCache(pubKey); cert != nil {
+		return cert
+	}
+
+	argv, err := splitCommandLine(c.command)
+	if err != nil || len(argv) == 0 {
+		warning("split CertificateCommand [%s] failed: %v", c.command, err)
+		return nil
+	}
+	debug("run CertificateCommand [%s] for key %s", c.command, ssh.FingerprintSHA256(pubKey))
+
+	cmd := exec.Command(argv[0], argv[1:]...)
+	var outBuf, errBuf bytes.Buffer
+	cmd.Stdin = bytes.NewReader(ssh.MarshalAuthorizedKey(pubKey))
+	cmd.Stdout = &outBuf
+	cmd.Stderr = &errBuf
+	if err := cmd.Run(); err != nil {
+		if errBuf.Len() > 0 {
+			warning("exec CertificateCommand [%s] failed: %v, %s", c.command, err, strings.TrimSpace(errBuf.String()))
+		} else {
+			warning("exec CertificateCommand [%s] failed: %v", c.command, err)
+		}
+		return nil
+	}
+	if enableDebugLogging && errBuf.Len() > 0 {
+		debug("CertificateCommand stderr output: %s", errBuf.String())
+	}
+
+	cert, err := parseCertificate(outBuf.Bytes())
+	if err != nil {
+		warning("parse certificate from CertificateCommand [%s] failed: %v", c.command, err)
+		return nil
+	}
+	if !isCertificateValid(cert, pubKey) {
+		warning("certificate from CertificateCommand [%s] is expired or does not match the key %s",
+			c.command, ssh.FingerprintSHA256(pubKey))
+		return nil
+	}
+	c.saveCache(pubKey, cert)
+	return cert
+}
@@ -0,0 +1,169 @@
+/*
+MIT License
+
+Copyright (c) 2023-2026 The Trzsz SSH Authors.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package tssh
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/trzsz/ssh_config"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestCertificateCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("shell script is not supported on windows")
+	}
+	assert := assert.New(t)
+	require := require.New(t)
+
+	_, caKey, err := ed25519.GenerateKey(rand.Reader)
+	require.Nil(err)
+	caSigner, err := ssh.NewSignerFromKey(caKey)
+	require.Nil(err)
+	_, userKey, err := ed25519.GenerateKey(rand.Reader)
+	require.Nil(err)
+	userSigner, err := ssh.NewSignerFromKey(userKey)
+	require.Nil(err)
+
+	newCert := func(validBefore time.Time) *ssh.Certificate {
+		cert := &ssh.Certificate{
+			Key:             userSigner.PublicKey(),
+			CertType:        ssh.UserCert,
+			ValidPrincipals: []string{"alice"},
+			ValidAfter:      uint64(time.Now().Add(-time.Minute).Unix()),
+			ValidBefore:     uint64(validBefore.Unix()),
+		}
+		require.Nil(cert.SignCert(rand.Reader, caSigner))
+		return cert
+	}
+
+	dir := t.TempDir()
+	certPath := filepath.Join(dir, "id-cert.pub")
+	countPath := filepath.Join(dir, "count")
+	scriptPath := filepath.Join(dir, "issue.sh")
+	require.Nil(os.WriteFile(scriptPath, []byte("cat > /dev/null\necho x >> "+countPath+"\ncat "+certPath+"\n"), 0700))
+	issueCount := func() int {
+		count, _ := os.ReadFile(countPath)
+		return strings.Count(string(count), "x")
+	}
+
+	certCommand := &certificateCommand{command: "sh " + scriptPath, cacheDir: filepath.Join(dir, "cache")}
+
+	// expired certificates are rejected
+	require.Nil(os.WriteFile(certPath, ssh.MarshalAuthorizedKey(newCert(time.Now().Add(-time.Hour))), 0600))
+	assert.Nil(certCommand.issue(userSigner.PublicKey()))
+	assert.Equal(1, issueCount())
+
+	// valid certificates are cached until expiry
+	require.Nil(os.WriteFile(certPath, ssh.MarshalAuthorizedKey(newCert(time.Now().Add(8*time.Hour))), 0600))
+	for range 2 {
+		cert := certCommand.issue(userSigner.PublicKey())
+		require.NotNil(cert)
+		assert.Equal([]string{"alice"}, cert.ValidPrincipals)
+	}
+	assert.Equal(2, issueCount())
+
+	signers := appendSignerCerts("", newSshSigner("test", nil, userSigner.PublicKey(), userSigner), nil, certCommand)
+	require.Len(signers, 2)
+	certKey, ok := signers[1].PublicKey().(*ssh.Certificate)
+	require.True(ok)
+	data := []byte("session data")
+	signature, err := signers[1].Sign(rand.Reader, data)
+	require.Nil(err)
+	assert.Nil(certKey.Verify(data, signature))
+	assert.Equal(2, issueCount())
+}
+
+func TestCertificateCommandKeys(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("shell script is not supported on windows")
+	}
+	assert := assert.New(t)
+	oriUserConfig, oriEnableWarning, oriGetDefaultSigners := userConfig, enableWarningLogging, getDefaultSigners
+	userConfig, enableWarningLogging = &tsshConfig{}, false
+	defer func() {
+		userConfig, enableWarningLogging, getDefaultSigners = oriUserConfig, oriEnableWarning, oriGetDefaultSigners
+	}()
+	t.Setenv("SSH_AUTH_SOCK", "")
+
+	dir := t.TempDir()
+	newKeyFile := func(name string) (string, sshSigner) {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		require.NoError(t, err)
+		block, err := ssh.MarshalPrivateKey(key, "")
+		require.NoError(t, err)
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(block), 0600))
+		signer, err := ssh.NewSignerFromKey(key)
+		require.NoError(t, err)
+		return path, newSshSigner(path, nil, signer.PublicKey(), signer)
+	}
+	_, default1 := newKeyFile("id_ed25519")
+	_, default2 := newKeyFile("id_ecdsa")
+	identity, _ := newKeyFile("identity")
+	getDefaultSigners = func(*signerOptions) []sshSigner { return []sshSigner{default1, default2} }
+
+	countPath := filepath.Join(dir, "count")
+	scriptPath := filepath.Join(dir, "issue.sh")
+	require.NoError(t, os.WriteFile(scriptPath, []byte("cat > /dev/null\necho x >> "+countPath+"\n"), 0700))
+	issueCount := func() int {
+		count, _ := os.ReadFile(countPath)
+		return strings.Count(string(count), "x")
+	}
+	config, err := ssh_config.DecodeBytes([]byte("Host *\n    CertificateCommand sh " + scriptPath + "\n"))
+	require.NoError(t, err)
+	userConfig.exConfig = &sshConfig{"", config}
+
+	newParam := func(options ...string) *sshParam {
+		args := &sshArgs{Destination: "test"}
+		for _, option := range options {
+			require.NoError(t, args.Option.UnmarshalText([]byte(option)))
+		}
+		return &sshParam{args: args, host: "test", port: "22", user: "alice"}
+	}
+
+	// all the default keys are signed
+	assert.NotNil(getPublicKeysAuthMethod(newParam()))
+	assert.Equal(2, issueCount())
+
+	// only the IdentityFile key is signed
+	assert.NotNil(getPublicKeysAuthMethod(newParam("IdentityFile=" + identity)))
+	assert.Equal(3, issueCount())
+
+	// the command can be set by -o too
+	userConfig.exConfig = nil
+	assert.NotNil(getPublicKeysAuthMethod(newParam("IdentityFile="+identity, "CertificateCommand=sh "+scriptPath)))
+	assert.Equal(4, issueCount())
+}
@@ -0,0 +1,177 @@
+/*
+MIT License
+
+Copyright (c) 2023-2026 The Trzsz SSH Authors.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package tssh
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/skeema/knownhosts"
+	"golang.org/x/crypto/ssh"
+	xkh "golang.org/x/crypto/ssh/knownhosts"
+)
+
+type hostIPStatus int
+
+const (
+	hostIPUnknown hostIPStatus = iota
+	hostIPMatched
+	hostIPChanged
+)
+
+// hostIPCheck is the result of checking the server IP against known_hosts when CheckHostIP is enabled.
+type hostIPCheck struct {
+	addr   string
+	status hostIPStatus
+	known  *xkh.KnownKey
+}
+
+// getCheckHostIPAddr returns the server IP address to be checked, or an empty string
+// if CheckHostIP is disabled, the IP is not known, or the host is the IP itself.
+func getCheckHostIPAddr(param *sshParam, host string, remote net.Addr) string {
+	if checkHostIP := getOptionConfig(param.args, "CheckHostIP"); !strings.EqualFold(checkHostIP, "yes") &&
+		!strings.EqualFold(checkHostIP, "true") {
+		return ""
+	}
+	// the remote address of ProxyCommand is not the server address, and it's unspecified for ProxyJump
+	if param.command != "" {
+		return ""
+	}
+	tcpAddr, ok := remote.(*net.TCPAddr)
+	if !ok || tcpAddr.IP == nil || tcpAddr.IP.IsUnspecified() {
+		return ""
+	}
+	// the host may be HostKeyAlias, and the port may be of HostNameAlternatives
+	port := strconv.Itoa(tcpAddr.Port)
+	if tcpAddr.Port == 0 {
+		port = param.port
+	}
+	addr := net.JoinHostPort(tcpAddr.IP.String(), port)
+	if knownhosts.Normalize(addr) == knownhosts.Normalize(host) {
+		return ""
+	}
+	return addr
+}
+
+func newHostIPCheck(param *sshParam, db *knownhosts.HostKeyDB, host string, remote net.Addr, key ssh.PublicKey) *hostIPCheck {
+	addr := getCheckHostIPAddr(param, host, remote)
+	if addr == "" {
+		return nil
+	}
+	check := &hostIPCheck{addr: addr}
+	err := db.HostKeyCallback()(addr, remote, key)
+	var keyErr *xkh.KeyError
+	switch {
+	case err == nil:
+		check.status = hostIPMatched
+	case errors.As(err, &keyErr) && len(keyErr.Want) > 0:
+		check.status = hostIPChanged
+		check.known = &keyErr.Want[0]
+	}
+	if enableDebugLogging {
+		debug("CheckHostIP %s status: %d", knownhosts.Normalize(addr), check.status)
+	}
+	return check
+}
+
+// newAddr returns the IP address to be recorded along with the host, if it's not in known_hosts yet.
+func (c *hostIPCheck) newAddr() string {
+	if c == nil || c.status != hostIPUnknown {
+		return ""
+	}
+	return c.addr
+}
+
+// verify checks the IP after the host key has been trusted:
+// records the unknown IP, or warns that the IP has a different key.
+func (c *hostIPCheck) verify(args *sshArgs, host, path string, key ssh.PublicKey) error {
+	if c == nil {
+		return nil
+	}
+	switch c.status {
+	case hostIPUnknown:
+		if path == "" {
+			debug("%s host key for IP address '%s' not in list of known hosts", shortKeyType(key.Type()), c.addr)
+			return nil
+		}
+		addHostKeyMutex.Lock()
+		defer addHostKeyMutex.Unlock()
+		if err := writeKnownHost(args, path, c.addr, "", key); err != nil {
+			warning("Failed to add the %s host key for IP address '%s' to the list of known hosts (%s): %v",
+				shortKeyType(key.Type()), knownhosts.Normalize(c.addr), path, err)
+			return nil
+		}
+		warning("Permanently added the %s host key for IP address '%s' to the list of known hosts.",
+			shortKeyType(key.Type()), knownhosts.Normalize(c.addr))
+	case hostIPChanged:
+		message := fmt.Sprintf("Warning: the %s host key for '%s' differs from the key for the IP address '%s'\r\n"+
+			"Offending key for IP in %s:%d", shortKeyType(key.Type()), knownhosts.Normalize(host),
+			knownhosts.Normalize(c.addr), c.known.Filename, c.known.Line)
+		switch strings.ToLower(getOptionConfig(args, "StrictHostKeyChecking")) {
+		case "yes", "true":
+			fmt.Fprintf(os.Stderr, "%s\r\n", message)
+			return fmt.Errorf("host key for IP address '%s' changed", c.addr)
+		case "accept-new", "no", "off", "false":
+			fmt.Fprintf(os.Stderr, "%s\r\n", message)
+		default:
+			if !confirmYesNo(message + "\r\nAre you sure you want to continue connecting?") {
+				return fmt.Errorf("host key for IP address '%s' not trusted", c.addr)
+			}
+		}
+	}
+	return nil
+}
+
+// warnDnsSpoofing warns that the host key has changed while the IP key is unknown, unchanged or different.
+func (c *hostIPCheck) warnDnsSpoofing(host string, key ssh.PublicKey) {
+	if c == nil {
+		return
+	}
+	var status string
+	switch c.status {
+	case hostIPUnknown:
+		status = "is unknown"
+	case hostIPMatched:
+		status = "is unchanged"
+	default:
+		status = "has a different value"
+	}
+	fmt.Fprintf(os.Stderr, "\033[0;31m@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@\r\n"+
+		"@       WARNING: POSSIBLE DNS SPOOFING DETECTED!          @\r\n"+
+		"@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@\033[0m\r\n"+
+		"The %s host key for %s has changed,\r\n"+
+		"and the key for the corresponding IP address %s\r\n"+
+		"%s. This could either mean that\r\n"+
+		"DNS SPOOFING is happening or the IP address for the host\r\n"+
+		"and its host key have changed at the same time.\r\n",
+		shortKeyType(key.Type()), knownhosts.Normalize(host), knownhosts.Normalize(c.addr), status)
+	if c.known != nil {
+		fmt.Fprintf(os.Stderr, "Offending key for IP in %s:%d\r\n", c.known.Filename, c.known.Line)
+	}
+}
@@ -28,6 +28,7 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"image/color"
 	"io"
 	"math"
 	"net"
@@ -367,14 +368,34 @@ func warning(format string, a ...any) {
 
 	msg := "Warning: " + fmt.Sprintf(format, a...)
 
-	terminalWidth := int(currentTerminalWidth.Load())
-	if terminalWidth <= 0 {
-		fmt.Fprintf(os.Stderr, "\r\033[0;33m%s\033[0m\033[K\r\n", msg)
+	if enableDebugLogging && currentTerminalWidth.Load() > 0 {
+		debug("warning: "+format, a...)
+	}
+
+	showTopBarMessage(msg, "33", yellowColor)
+}
+
+// notice shows a message at the top of the terminal like warning,
+// but for something the user should know rather than a problem.
+func notice(format string, a ...any) {
+	if !enableWarningLogging {
 		return
 	}
 
-	if enableDebugLogging {
-		debug("warning: "+format, a...)
+	msg := fmt.Sprintf(format, a...)
+
+	if enableDebugLogging && currentTerminalWidth.Load() > 0 {
+		debug("notice: "+format, a...)
+	}
+
+	showTopBarMessage(msg, "32", greenColor)
+}
+
+func showTopBarMessage(msg, ansiColor string, bgColor color.Color) {
+	terminalWidth := int(currentTerminalWidth.Load())
+	if terminalWidth <= 0 {
+		fmt.Fprintf(os.Stderr, "\r\033[0;%sm%s\033[0m\033[K\r\n", ansiColor, msg)
+		return
 	}
 
 	var paneId string
@@ -388,9 +409,9 @@ func warning(format string, a ...any) {
 
 	msgWidth := ansi.StringWidth(msg)
 	if msgWidth > terminalWidth {
-		msg = lipgloss.NewStyle().Foreground(blackColor).Background(yellowColor).Render(ansi.Truncate(msg, terminalWidth, ""))
+		msg = lipgloss.NewStyle().Foreground(blackColor).Background(bgColor).Render(ansi.Truncate(msg, terminalWidth, ""))
 	} else {
-		msg = lipgloss.NewStyle().Foreground(blackColor).Width(terminalWidth).Background(yellowColor).Render(msg)
+		msg = lipgloss.NewStyle().Foreground(blackColor).Width(terminalWidth).Background(bgColor).Render(msg)
 	}
 	var buf bytes.Buffer
 	buf.WriteString(ansi.SaveCurrentCursorPosition)
@@ -98,6 +98,8 @@ type tsshConfig struct {
 	promptSearchPointer   string
 	setTerminalTitle      string
 	customDnsServer       string
+	secretMasterKey       string
+	masterKeyCacheTimeout string
 	loadConfig            sync.Once
 	loadExConfig          sync.Once
 	loadHosts             sync.Once
@@ -249,6 +251,10 @@ func loadTsshConfig(path string) {
 			userConfig.setTerminalTitle = value
 		case name == "customdnsserver" && userConfig.customDnsServer == "":
 			userConfig.customDnsServer = value
+		case name == "secretmasterkey" && userConfig.secretMasterKey == "":
+			userConfig.secretMasterKey = value
+		case name == "masterkeycachetimeout" && userConfig.masterKeyCacheTimeout == "":
+			userConfig.masterKeyCacheTimeout = value
 		}
 	}
 	if err := scanner.Err(); err != nil {
@@ -334,6 +340,12 @@ func showTsshConfig() {
 	if userConfig.customDnsServer != "" {
 		debug("CustomDnsServer = %s", userConfig.customDnsServer)
 	}
+	if userConfig.secretMasterKey != "" {
+		debug("SecretMasterKey = %s", userConfig.secretMasterKey)
+	}
+	if userConfig.masterKeyCacheTimeout != "" {
+		debug("MasterKeyCacheTimeout = %s", userConfig.masterKeyCacheTimeout)
+	}
 }
 
 func initUserConfig(configFile string) (err error) {
@@ -834,10 +846,67 @@ func getAllExOptionConfig(args *sshArgs, option string, extend bool) []string {
 	return append(args.Option.getAll(option), getAllExConfig(args, option, extend)...)
 }
 
+// getExOptionIndexes returns the sorted indexes of the numbered option, such as the N of QuestionPattern<N>,
+// configured by -o or in the configs of the destination, so there could be gaps in the indexes.
+func getExOptionIndexes(args *sshArgs, prefix string) []int {
+	var indexes []int
+	addIndex := func(key string) {
+		if len(key) <= len(prefix) || !strings.EqualFold(key[:len(prefix)], prefix) {
+			return
+		}
+		suffix := key[len(prefix):]
+		if strings.Trim(suffix, "0123456789") != "" {
+			return
+		}
+		if idx, err := strconv.Atoi(suffix); err == nil && !slices.Contains(indexes, idx) {
+			indexes = append(indexes, idx)
+		}
+	}
+
+	for key := range args.Option.options {
+		addIndex(key)
+	}
+
+	userConfig.doLoadExConfig()
+	var addHosts func(alias string, hosts []*ssh_config.Host)
+	addHosts = func(alias string, hosts []*ssh_config.Host) {
+		for _, host := range hosts {
+			if !host.Matches(alias) {
+				continue
+			}
+			for _, node := range host.Nodes {
+				switch node := node.(type) {
+				case *ssh_config.KV:
+					addIndex(node.Key)
+				case *ssh_config.Include:
+					for _, config := range node.GetFiles() {
+						if config != nil {
+							addHosts(alias, config.Hosts)
+						}
+					}
+				}
+			}
+		}
+	}
+	for _, alias := range []string{args.Destination, args.canonicalDest} {
+		if alias == "" {
+			continue
+		}
+		for _, cfg := range []*sshConfig{userConfig.exConfig, userConfig.config, userConfig.sysConfig} {
+			if cfg != nil && cfg.config != nil {
+				addHosts(alias, cfg.config.Hosts)
+			}
+		}
+	}
+
+	slices.Sort(indexes)
+	return indexes
+}
+
 var secretEncodeKey = []byte("THE_UNSAFE_KEY_FOR_ENCODING_ONLY")
 
-func encodeSecret(secret []byte) (string, error) {
-	aesCipher, err := aes.NewCipher(secretEncodeKey)
+func sealSecret(key, secret []byte) (string, error) {
+	aesCipher, err := aes.NewCipher(key)
 	if err != nil {
 		return "", err
 	}
@@ -852,12 +921,12 @@ func encodeSecret(secret []byte) (string, error) {
 	return fmt.Sprintf("%x", aesGCM.Seal(nonce, nonce, secret, nil)), nil
 }
 
-func decodeSecret(secret string) (string, error) {
+func openSecret(key []byte, secret string) (string, error) {
 	cipherSecret, err := hex.DecodeString(secret)
 	if err != nil {
 		return "", err
 	}
-	aesCipher, err := aes.NewCipher(secretEncodeKey)
+	aesCipher, err := aes.NewCipher(key)
 	if err != nil {
 		return "", err
 	}
@@ -876,6 +945,34 @@ func decodeSecret(secret string) (string, error) {
 	return string(plainSecret), nil
 }
 
+// encodeSecret encodes the secret with the master key if SecretMasterKey is configured,
+// otherwise with the built-in key, which is only an obfuscation.
+func encodeSecret(secret []byte) (string, error) {
+	if getSecretMasterKeyMode() == "" {
+		return sealSecret(secretEncodeKey, secret)
+	}
+	key, err := getMasterKey(true)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := sealSecret(key, secret)
+	if err != nil {
+		return "", err
+	}
+	return kSecretMasterKeyPrefix + sealed, nil
+}
+
+func decodeSecret(secret string) (string, error) {
+	if sealed, ok := strings.CutPrefix(secret, kSecretMasterKeyPrefix); ok {
+		key, err := getMasterKey(false)
+		if err != nil {
+			return "", err
+		}
+		return openSecret(key, sealed)
+	}
+	return openSecret(secretEncodeKey, secret)
+}
+
 func execSecretCommand(param *sshParam, command string) string {
 	expanded, err := expandTokens(command, param, "%hnpr")
 	if err != nil {
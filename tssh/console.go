@@ -229,6 +229,34 @@ func runConsole(escapeChar byte, writer io.WriteCloser, sshConn *sshConnection)
 		}})
 	}
 
+	if forwarder := sshConn.autoForwarder.Load(); forwarder != nil {
+		getPortsLabel := func() string {
+			ports := strings.Join(forwarder.listForwardedPorts(), ", ")
+			if ports == "" {
+				ports = getText("console/no_ports")
+			}
+			return strings.ReplaceAll(getText("console/ports"), "{0}", ports)
+		}
+		item := &menuItem{key: "p", label: getPortsLabel()}
+		item.action = func() (tea.Model, tea.Cmd) { // refresh the ports which may change while the console is open
+			item.label = getPortsLabel()
+			return model, nil
+		}
+		model.items = append(model.items, item)
+	}
+
+	if browser := sshConn.remoteBrowser; browser != nil {
+		if rawURL := browser.peekPendingURL(); rawURL != "" {
+			model.items = append(model.items, &menuItem{"o", strings.ReplaceAll(getText("console/open_url"), "{0}", rawURL), func() (tea.Model, tea.Cmd) {
+				if rawURL := browser.takePendingURL(); rawURL != "" {
+					go browser.open(rawURL)
+				}
+				model.quitting = true
+				return model, tea.Quit
+			}})
+		}
+	}
+
 	teaOpts, cancelReader := newTeaOptions(func(buf []byte) {
 		if enableDebugLogging {
 			if ch := stdinInputChan.Load(); ch != nil {
@@ -0,0 +1,198 @@
+/*
+MIT License
+
+Copyright (c) 2023-2026 The Trzsz SSH Authors.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package tssh
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+)
+
+// kConnectionAttemptDelay is the delay before racing the next address, as recommended by RFC 8305.
+const kConnectionAttemptDelay = 250 * time.Millisecond
+
+var connectionRetryDelay = time.Second
+
+func getConnectionAttempts(args *sshArgs) int {
+	connectionAttempts := getOptionConfig(args, "ConnectionAttempts")
+	if connectionAttempts == "" {
+		return 1
+	}
+	value, err := strconv.Atoi(connectionAttempts)
+	if err != nil || value < 1 {
+		warning("ConnectionAttempts [%s] invalid", connectionAttempts)
+		return 1
+	}
+	return value
+}
+
+// dialWithAttempts dials the server up to ConnectionAttempts times, waiting a second between attempts.
+func dialWithAttempts(param *sshParam, timeout time.Duration) (net.Conn, error) {
+	attempts := getConnectionAttempts(param.args)
+	network, _, _ := getNetworkAddressFamily(param.args)
+	resolver := getHostDnsResolver(param.args)
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		return dialHappyEyeballs(ctx, resolver, network, addr)
+	}
+	var err error
+	for i := 1; i <= attempts; i++ {
+		if i > 1 {
+			time.Sleep(connectionRetryDelay)
+		}
+		debug("connection attempt %d/%d to [%s]", i, attempts, param.addr)
+		var conn net.Conn
+		conn, err = dialHostNameAlternatives(param, network, dial)
+		if err == nil {
+			return conn, nil
+		}
+		debug("connection attempt %d/%d to [%s] failed: %v", i, attempts, param.addr, err)
+	}
+	return nil, err
+}
+
+// dialHappyEyeballs resolves the host, and races the connections to its addresses like RFC 8305.
+func dialHappyEyeballs(ctx context.Context, resolver *net.Resolver, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	ipAddrs, err := resolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	targets := make([]string, 0, len(ipAddrs))
+	for _, ipAddr := range sortHappyEyeballsAddrs(ipAddrs, network) {
+		targets = append(targets, net.JoinHostPort(ipAddr.String(), port))
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("no suitable address found for %s", host)
+	}
+	if enableDebugLogging && len(targets) > 1 {
+		debug("%s resolves to %v", host, targets)
+	}
+
+	var dialer net.Dialer
+	conn, _, err := raceDial(ctx, network, targets, dialer.DialContext)
+	return conn, err
+}
+
+// sortHappyEyeballsAddrs filters the addresses by the network, and interleaves IPv6 and IPv4 addresses,
+// starting with IPv6, so that a broken address family doesn't delay the connection for long.
+func sortHappyEyeballsAddrs(ipAddrs []net.IPAddr, network string) []net.IPAddr {
+	var ipv6Addrs, ipv4Addrs []net.IPAddr
+	for _, ipAddr := range ipAddrs {
+		if ipAddr.IP.To4() != nil {
+			if network != "tcp6" {
+				ipv4Addrs = append(ipv4Addrs, ipAddr)
+			}
+		} else if network != "tcp4" {
+			ipv6Addrs = append(ipv6Addrs, ipAddr)
+		}
+	}
+	sorted := make([]net.IPAddr, 0, len(ipv6Addrs)+len(ipv4Addrs))
+	for i := 0; i < len(ipv6Addrs) || i < len(ipv4Addrs); i++ {
+		if i < len(ipv6Addrs) {
+			sorted = append(sorted, ipv6Addrs[i])
+		}
+		if i < len(ipv4Addrs) {
+			sorted = append(sorted, ipv4Addrs[i])
+		}
+	}
+	return sorted
+}
+
+// raceDial starts a connection attempt to the next target every kConnectionAttemptDelay,
+// or as soon as the previous attempt fails, and returns the first established connection and its target.
+func raceDial(ctx context.Context, network string, targets []string,
+	dial func(ctx context.Context, network, addr string) (net.Conn, error)) (net.Conn, string, error) {
+	type dialResult struct {
+		conn   net.Conn
+		err    error
+		target string
+	}
+	raceCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make(chan dialResult, len(targets))
+
+	next, pending := 0, 0
+	startNext := func() {
+		target := targets[next]
+		next++
+		pending++
+		debug("connecting to [%s] (%d/%d)", target, next, len(targets))
+		go func() {
+			conn, err := dial(raceCtx, network, target)
+			results <- dialResult{conn, err, target}
+		}()
+	}
+
+	startNext()
+	timer := time.NewTimer(kConnectionAttemptDelay)
+	defer timer.Stop()
+
+	var firstErr error
+	for pending > 0 {
+		select {
+		case result := <-results:
+			pending--
+			if result.err == nil {
+				debug("connected to [%s]", result.target)
+				go func(pending int) { // close the connections established later
+					for range pending {
+						if result := <-results; result.conn != nil {
+							_ = result.conn.Close()
+						}
+					}
+				}(pending)
+				return result.conn, result.target, nil
+			}
+			debug("connect to [%s] failed: %v", result.target, result.err)
+			if firstErr == nil {
+				firstErr = result.err
+			}
+			if next < len(targets) {
+				startNext()
+				timer.Reset(kConnectionAttemptDelay)
+			}
+		case <-timer.C:
+			if next < len(targets) {
+				startNext()
+				timer.Reset(kConnectionAttemptDelay)
+			}
+		}
+	}
+	return nil, "", firstErr
+}
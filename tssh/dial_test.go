@@ -0,0 +1,129 @@
+/*
+MIT License
+
+Copyright (c) 2023-2026 The Trzsz SSH Authors.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package tssh
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSortHappyEyeballsAddrs(t *testing.T) {
+	assert := assert.New(t)
+	var ipAddrs []net.IPAddr
+	for _, ip := range []string{"192.0.2.1", "192.0.2.2", "2001:db8::1", "192.0.2.3", "2001:db8::2"} {
+		ipAddrs = append(ipAddrs, net.IPAddr{IP: net.ParseIP(ip)})
+	}
+	toStrings := func(ipAddrs []net.IPAddr) []string {
+		var result []string
+		for _, ipAddr := range ipAddrs {
+			result = append(result, ipAddr.String())
+		}
+		return result
+	}
+	assert.Equal([]string{"2001:db8::1", "192.0.2.1", "2001:db8::2", "192.0.2.2", "192.0.2.3"},
+		toStrings(sortHappyEyeballsAddrs(ipAddrs, "tcp")))
+	assert.Equal([]string{"192.0.2.1", "192.0.2.2", "192.0.2.3"}, toStrings(sortHappyEyeballsAddrs(ipAddrs, "tcp4")))
+	assert.Equal([]string{"2001:db8::1", "2001:db8::2"}, toStrings(sortHappyEyeballsAddrs(ipAddrs, "tcp6")))
+}
+
+func TestRaceDial(t *testing.T) {
+	assert := assert.New(t)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer func() { _ = listener.Close() }()
+
+	// the first address is a black hole, like a broken IPv6 route
+	var canceled atomic.Bool
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if addr == "[2001:db8::1]:22" {
+			<-ctx.Done()
+			canceled.Store(true)
+			return nil, ctx.Err()
+		}
+		if addr == "192.0.2.1:22" {
+			return nil, fmt.Errorf("connection refused")
+		}
+		var dialer net.Dialer
+		return dialer.DialContext(ctx, network, addr)
+	}
+
+	beginTime := time.Now()
+	conn, target, err := raceDial(context.Background(), "tcp", []string{"[2001:db8::1]:22", "192.0.2.1:22", listener.Addr().String()}, dial)
+	require.NoError(t, err)
+	assert.Equal(listener.Addr().String(), target)
+	_ = conn.Close()
+	elapsed := time.Since(beginTime)
+	assert.GreaterOrEqual(elapsed, kConnectionAttemptDelay)
+	assert.Less(elapsed, 2*kConnectionAttemptDelay)
+	assert.Eventually(canceled.Load, time.Second, 10*time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 400*time.Millisecond)
+	defer cancel()
+	_, _, err = raceDial(ctx, "tcp", []string{"[2001:db8::1]:22", "192.0.2.1:22"}, dial)
+	assert.ErrorContains(err, "connection refused")
+}
+
+func TestDialWithAttempts(t *testing.T) {
+	assert := assert.New(t)
+	oriUserConfig, oriEnableWarning, oriRetryDelay := userConfig, enableWarningLogging, connectionRetryDelay
+	userConfig, enableWarningLogging, connectionRetryDelay = &tsshConfig{}, false, 10*time.Millisecond
+	defer func() {
+		userConfig, enableWarningLogging, connectionRetryDelay = oriUserConfig, oriEnableWarning, oriRetryDelay
+	}()
+
+	newParam := func(addr string, options ...string) *sshParam {
+		args := &sshArgs{}
+		for _, option := range options {
+			require.NoError(t, args.Option.UnmarshalText([]byte(option)))
+		}
+		return &sshParam{args: args, addr: addr}
+	}
+	assert.Equal(1, getConnectionAttempts(newParam("").args))
+	assert.Equal(3, getConnectionAttempts(newParam("", "ConnectionAttempts=3").args))
+	assert.Equal(1, getConnectionAttempts(newParam("", "ConnectionAttempts=0").args))
+	assert.Equal(1, getConnectionAttempts(newParam("", "ConnectionAttempts=x").args))
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := listener.Addr().String()
+	conn, err := dialWithAttempts(newParam(addr, "ConnectionAttempts=2"), time.Second)
+	require.NoError(t, err)
+	_ = conn.Close()
+	_ = listener.Close()
+
+	beginTime := time.Now()
+	_, err = dialWithAttempts(newParam(addr, "ConnectionAttempts=3"), time.Second)
+	assert.Error(err)
+	assert.GreaterOrEqual(time.Since(beginTime), 2*connectionRetryDelay)
+	_, err = dialWithAttempts(newParam(addr, "AddressFamily=inet6"), time.Second)
+	assert.ErrorContains(err, "no suitable address")
+}
@@ -57,38 +57,92 @@ const (
 // lookups (which the stdlib resolver can't perform) can reuse the same server.
 var customDnsServer dnsServer
 
-var dialDNS = net.DialTimeout
+var dialDNS = dialDnsServer
 
 type dnsServer struct {
 	network string
 	addr    string
 }
 
-// setDNS sets the net.DefaultResolver to use the given DNS server.
-func setDNS(dns string) {
-
-	network, dns, err := resolveDnsAddress(dns)
-	if err != nil {
-		return
+// setupCustomDNS sets the DNS server of --dns, or the CustomDnsServer in ~/.tssh.conf, in order of priority.
+// The DnsServer of a host is not set globally, it's only used for that host via getHostDnsResolver.
+func setupCustomDNS(args *sshArgs) {
+	if args.DNS != "" {
+		setDNS(args.DNS)
+	} else if userConfig.customDnsServer != "" {
+		setDNS(userConfig.customDnsServer)
+	}
+}
 
+// getHostDnsServer returns the DnsServer extended option of the destination.
+func getHostDnsServer(args *sshArgs) string {
+	if args.Destination == "" {
+		return ""
 	}
+	_, host, _ := parseDestination(args.Destination)
+	hostArgs := &sshArgs{Option: args.Option, Destination: host, canonicalDest: args.canonicalDest}
+	return getExOptionConfig(hostArgs, "DnsServer")
+}
 
-	customDnsServer = newDnsServer(network, dns)
+// getHostDnsResolver returns the resolver of the DnsServer extended option of the host,
+// or nil if it's not configured or the DNS server is specified by --dns.
+func getHostDnsResolver(args *sshArgs) *net.Resolver {
+	if args.DNS != "" {
+		return nil
+	}
+	dns := getHostDnsServer(args)
+	if dns == "" {
+		return nil
+	}
+	network, addr, err := resolveDnsAddress(dns)
+	if err != nil {
+		warning("DnsServer [%s] invalid, the default resolver is used: %v", dns, err)
+		return nil
+	}
+	return newDnsResolver(network, addr)
+}
 
+// newDnsResolver returns a resolver which uses the given DNS server.
+func newDnsResolver(network, addr string) *net.Resolver {
 	var once sync.Once
-
-	net.DefaultResolver = &net.Resolver{
+	return &net.Resolver{
 		PreferGo: true,
-		Dial: func(ctx context.Context, _, addr string) (net.Conn, error) {
+		Dial: func(ctx context.Context, _, _ string) (net.Conn, error) {
 			if enableDebugLogging {
 				once.Do(func() {
-					debug("using custom DNS: %s://%s", network, dns)
+					if network == "https" {
+						debug("using custom DNS: %s", addr)
+					} else {
+						debug("using custom DNS: %s://%s", network, addr)
+					}
 				})
 			}
-			var d net.Dialer
-			return d.DialContext(ctx, network, dns)
+			if network == "udp" || network == "tcp" {
+				var d net.Dialer
+				return d.DialContext(ctx, network, addr)
+			}
+			timeout := dnsQueryTimeout
+			if deadline, ok := ctx.Deadline(); ok {
+				timeout = time.Until(deadline)
+			}
+			return dialDnsServer(network, addr, timeout)
 		},
 	}
+}
+
+// setDNS sets the net.DefaultResolver to use the given DNS server.
+func setDNS(dns string) {
+
+	network, addr, err := resolveDnsAddress(dns)
+	if err != nil {
+		warning("parse dns [%s] failed: %v", dns, err)
+		return
+
+	}
+
+	customDnsServer = newDnsServer(network, addr)
+
+	net.DefaultResolver = newDnsResolver(network, addr)
 
 }
 
@@ -103,21 +157,35 @@ func resolveDnsAddress(dns string) (string, string, error) {
 
 	svrParse, err := url.Parse(preParseDns)
 	if err != nil {
-		warning("parse dns [%s] failed: %v", dns, err)
 		return "", "", err
 
 	}
 
-	network := "udp"
-	if strings.EqualFold(svrParse.Scheme, "tcp") {
+	network, defaultPort := "udp", "53"
+	switch strings.ToLower(svrParse.Scheme) {
+	case "udp":
+	case "tcp":
 		network = "tcp"
+	case "tls":
+		network, defaultPort = "tls", "853"
+	case "https":
+		// DNS over HTTPS uses the URL, and the default path is /dns-query
+		if svrParse.Path == "" {
+			svrParse.Path = "/dns-query"
+		}
+		return "https", svrParse.String(), nil
+	default:
+		return "", "", fmt.Errorf("unsupported scheme: %s", svrParse.Scheme)
+	}
+	if svrParse.Hostname() == "" {
+		return "", "", fmt.Errorf("missing host")
 	}
 
 	host, port, err := net.SplitHostPort(svrParse.Host)
 	if err != nil {
-		// If no port is specified, use default port 53
+		// If no port is specified, use the default port
 		host = svrParse.Host
-		port = "53"
+		port = defaultPort
 	}
 
 	dns = net.JoinHostPort(host, port)
@@ -125,8 +193,12 @@ func resolveDnsAddress(dns string) (string, string, error) {
 
 }
 
-func lookupDnsSrv(name string) (string, string, error) {
-	_, addrs, err := net.LookupSRV("ssh", "tcp", name)
+func lookupDnsSrv(args *sshArgs, name string) (string, string, error) {
+	resolver := net.DefaultResolver
+	if hostResolver := getHostDnsResolver(args); hostResolver != nil {
+		resolver = hostResolver
+	}
+	_, addrs, err := resolver.LookupSRV(context.Background(), "ssh", "tcp", name)
 	if err != nil {
 		return "", "", err
 	}
@@ -164,6 +236,7 @@ type sshfpRecord struct {
 }
 
 type dnssecValidator struct {
+	servers     []dnsServer
 	dnskeyCache map[string][]*dns.DNSKEY
 }
 
@@ -235,9 +308,19 @@ func parseSSHFP(answers []dns.RR, expected string) []sshfpRecord {
 	return records
 }
 
-// dnsServers returns the DNS servers to use for SSHFP lookups. It prefers a
-// server configured via setDNS and otherwise falls back to system nameservers.
-func dnsServers() []dnsServer {
+// dnsServers returns the DNS servers to use for SSHFP lookups of the host. It prefers
+// the DnsServer of the host, then a server configured via setDNS, and otherwise
+// falls back to system nameservers.
+func dnsServers(args *sshArgs) []dnsServer {
+	if args != nil && args.DNS == "" {
+		if dns := getHostDnsServer(args); dns != "" {
+			network, addr, err := resolveDnsAddress(dns)
+			if err == nil {
+				return []dnsServer{newDnsServer(network, addr)}
+			}
+			warning("DnsServer [%s] invalid, the default DNS servers are used: %v", dns, err)
+		}
+	}
 	if customDnsServer.addr != "" {
 		return []dnsServer{customDnsServer}
 	}
@@ -250,7 +333,7 @@ func dnsServers() []dnsServer {
 // include a port, which is stripped before the lookup. Any lookup failure is
 // treated as no match. Callers must only auto-trust a match when authenticate
 // returns true; an unauthenticated match merely informs the user.
-func verifyHostKeyDNS(host string, key ssh.PublicKey) (found bool, matched bool, authenticate func() bool, err error) {
+func verifyHostKeyDNS(args *sshArgs, host string, key ssh.PublicKey) (found bool, matched bool, authenticate func() bool, err error) {
 	name := host
 	if h, _, err := net.SplitHostPort(host); err == nil {
 		name = h
@@ -264,7 +347,7 @@ func verifyHostKeyDNS(host string, key ssh.PublicKey) (found bool, matched bool,
 		return false, false, nil, nil
 	}
 
-	records, authenticate, err := lookupSSHFP(name)
+	records, authenticate, err := lookupSSHFP(args, name)
 	if err != nil {
 		return false, false, nil, fmt.Errorf("SSHFP lookup for '%s' failed: %v", name, err)
 	}
@@ -279,19 +362,19 @@ func verifyHostKeyDNS(host string, key ssh.PublicKey) (found bool, matched bool,
 // returns an authentication function that reports success only when the SSHFP
 // RRset validates through DNSSEC to the pinned root trust anchor; the response
 // AD bit is never trusted.
-func lookupSSHFP(host string) ([]sshfpRecord, func() bool, error) {
+func lookupSSHFP(args *sshArgs, host string) ([]sshfpRecord, func() bool, error) {
+	servers := dnsServers(args)
 	name := dns.Fqdn(host)
-	response, err := lookupDNSSEC(name, dns.TypeSSHFP)
+	response, err := lookupDNSSEC(servers, name, dns.TypeSSHFP)
 	if err != nil {
 		return nil, nil, err
 	}
 	records := parseSSHFP(response.Answer, name)
-	authenticate := func() bool { return validateSSHFPDNSSEC(response, name) }
+	authenticate := func() bool { return validateSSHFPDNSSEC(servers, response, name) }
 	return records, authenticate, nil
 }
 
-func queryDNSSEC(host string, rrType uint16) (*dns.Msg, error) {
-	servers := dnsServers()
+func queryDNSSEC(servers []dnsServer, host string, rrType uint16) (*dns.Msg, error) {
 	if len(servers) == 0 {
 		return nil, fmt.Errorf("no dns server available for DNSSEC lookup")
 	}
@@ -375,7 +458,7 @@ func queryDNSSECServer(server dnsServer, request []byte, id uint16, name string,
 	}
 }
 
-func validateSSHFPDNSSEC(response *dns.Msg, owner string) bool {
+func validateSSHFPDNSSEC(servers []dnsServer, response *dns.Msg, owner string) bool {
 	rrset := dnssecRecords(response.Answer, owner, dns.TypeSSHFP)
 	if len(rrset) == 0 {
 		return false
@@ -384,7 +467,7 @@ func validateSSHFPDNSSEC(response *dns.Msg, owner string) bool {
 	if len(sigs) == 0 {
 		return false
 	}
-	validator := &dnssecValidator{dnskeyCache: make(map[string][]*dns.DNSKEY)}
+	validator := &dnssecValidator{servers: servers, dnskeyCache: make(map[string][]*dns.DNSKEY)}
 	for _, sig := range sigs {
 		// Per RFC 4035 section 5.3.1, the signer name must identify
 		// the zone that contains the signed RRset.
@@ -412,7 +495,7 @@ func (v *dnssecValidator) validateDNSKEY(zone string) ([]*dns.DNSKEY, error) {
 		return keys, nil
 	}
 
-	response, err := lookupDNSSEC(zone, dns.TypeDNSKEY)
+	response, err := lookupDNSSEC(v.servers, zone, dns.TypeDNSKEY)
 	if err != nil {
 		return nil, err
 	}
@@ -435,7 +518,7 @@ func (v *dnssecValidator) validateDNSKEY(zone string) ([]*dns.DNSKEY, error) {
 		if err != nil {
 			return nil, err
 		}
-		dsResponse, err := lookupDNSSEC(zone, dns.TypeDS)
+		dsResponse, err := lookupDNSSEC(v.servers, zone, dns.TypeDS)
 		if err != nil {
 			return nil, err
 		}
@@ -559,8 +642,9 @@ func parentDNSName(name string) string {
 	return strings.Join(parts[1:], ".") + "."
 }
 
+// writeDNSMessage writes the DNS message, with the TCP framing unless the network is udp.
 func writeDNSMessage(conn net.Conn, network string, request []byte) error {
-	if network != "tcp" {
+	if network == "udp" {
 		n, err := conn.Write(request)
 		if err == nil && n != len(request) {
 			err = io.ErrShortWrite
@@ -581,7 +665,7 @@ func writeDNSMessage(conn net.Conn, network string, request []byte) error {
 }
 
 func readDNSMessage(conn net.Conn, network string) ([]byte, error) {
-	if network != "tcp" {
+	if network == "udp" {
 		buf := make([]byte, 4096)
 		n, err := conn.Read(buf)
 		if err != nil {
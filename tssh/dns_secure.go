@@ -0,0 +1,187 @@
+/*
+MIT License
+
+Copyright (c) 2023-2026 The Trzsz SSH Authors.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package tssh
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const kDnsMessageMimeType = "application/dns-message"
+
+// dnsTLSRootCAs is the root CAs to verify the DNS-over-TLS and DNS-over-HTTPS servers, nil for the system ones.
+var dnsTLSRootCAs *x509.CertPool
+
+// dnsBootstrapResolver resolves the host names of the DNS-over-TLS and DNS-over-HTTPS servers.
+// It doesn't use the custom DNS server to avoid resolving them through themselves.
+var dnsBootstrapResolver = &net.Resolver{}
+
+// dialDnsServer dials the DNS server. A DNS-over-TLS connection and a DNS-over-HTTPS connection
+// both read and write DNS messages with the TCP framing, so they can be used as a TCP connection.
+func dialDnsServer(network, addr string, timeout time.Duration) (net.Conn, error) {
+	switch network {
+	case "tls":
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		dialer := &tls.Dialer{
+			NetDialer: &net.Dialer{Timeout: timeout, Resolver: dnsBootstrapResolver},
+			Config:    &tls.Config{ServerName: host, RootCAs: dnsTLSRootCAs, MinVersion: tls.VersionTLS12},
+		}
+		return dialer.Dial("tcp", addr)
+	case "https":
+		return newDohConn(addr, timeout), nil
+	default:
+		return net.DialTimeout(network, addr, timeout)
+	}
+}
+
+// dohConn sends each DNS message written with the TCP framing as a DNS-over-HTTPS (RFC 8484) request,
+// and returns the responses with the TCP framing.
+type dohConn struct {
+	url      string
+	client   *http.Client
+	mutex    sync.Mutex
+	deadline time.Time
+	request  bytes.Buffer
+	response bytes.Buffer
+}
+
+func newDohConn(url string, timeout time.Duration) *dohConn {
+	dialer := &net.Dialer{Timeout: timeout, Resolver: dnsBootstrapResolver}
+	transport := &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		DialContext:         dialer.DialContext,
+		TLSClientConfig:     &tls.Config{RootCAs: dnsTLSRootCAs, MinVersion: tls.VersionTLS12},
+		TLSHandshakeTimeout: timeout,
+		ForceAttemptHTTP2:   true,
+	}
+	return &dohConn{url: url, client: &http.Client{Transport: transport, Timeout: timeout}}
+}
+
+func (c *dohConn) Write(b []byte) (int, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.request.Write(b)
+	for c.request.Len() >= 2 {
+		size := int(binary.BigEndian.Uint16(c.request.Bytes()[:2]))
+		if c.request.Len() < size+2 {
+			break
+		}
+		message := make([]byte, size)
+		copy(message, c.request.Bytes()[2:size+2])
+		c.request.Next(size + 2)
+		response, err := c.exchange(message)
+		if err != nil {
+			return 0, err
+		}
+		if len(response) > 65535 {
+			return 0, fmt.Errorf("dns response too large")
+		}
+		var length [2]byte
+		binary.BigEndian.PutUint16(length[:], uint16(len(response)))
+		c.response.Write(length[:])
+		c.response.Write(response)
+	}
+	return len(b), nil
+}
+
+func (c *dohConn) exchange(message []byte) ([]byte, error) {
+	ctx := context.Background()
+	if !c.deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, c.deadline)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(message))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", kDnsMessageMimeType)
+	req.Header.Set("Accept", kDnsMessageMimeType)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("dns over https [%s] status: %s", c.url, resp.Status)
+	}
+	if contentType := resp.Header.Get("Content-Type"); contentType != kDnsMessageMimeType {
+		return nil, fmt.Errorf("dns over https [%s] content type: %s", c.url, contentType)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 65536))
+}
+
+func (c *dohConn) Read(b []byte) (int, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.response.Len() == 0 {
+		if !c.deadline.IsZero() && time.Now().After(c.deadline) {
+			return 0, os.ErrDeadlineExceeded
+		}
+		return 0, io.EOF
+	}
+	return c.response.Read(b)
+}
+
+func (c *dohConn) Close() error {
+	c.client.CloseIdleConnections()
+	return nil
+}
+
+func (c *dohConn) LocalAddr() net.Addr {
+	return &net.TCPAddr{}
+}
+
+func (c *dohConn) RemoteAddr() net.Addr {
+	return &net.TCPAddr{}
+}
+
+func (c *dohConn) SetDeadline(t time.Time) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.deadline = t
+	return nil
+}
+
+func (c *dohConn) SetReadDeadline(t time.Time) error {
+	return c.SetDeadline(t)
+}
+
+func (c *dohConn) SetWriteDeadline(t time.Time) error {
+	return c.SetDeadline(t)
+}
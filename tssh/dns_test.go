@@ -25,12 +25,17 @@ SOFTWARE.
 package tssh
 
 import (
+	"context"
 	"crypto/ed25519"
 	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/base64"
 	"fmt"
 	"io"
 	"net"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 
@@ -42,11 +47,19 @@ import (
 func TestDNS(t *testing.T) {
 	for input, want := range map[string]string{
 		"8.8.8.8": "udp://8.8.8.8:53", "tcp://8.8.8.8": "tcp://8.8.8.8:53", "udp://[2001:4860:4860::8888]:5300": "udp://[2001:4860:4860::8888]:5300",
+		"tls://1.1.1.1": "tls://1.1.1.1:853", "tls://dns.google:8853": "tls://dns.google:8853",
+		"https://1.1.1.1": "https://https://1.1.1.1/dns-query", "https://dns.google/resolve": "https://https://dns.google/resolve",
 	} {
 		network, address, err := resolveDnsAddress(input)
 		assert.NoError(t, err)
 		assert.Equal(t, want, fmt.Sprintf("%s://%s", network, address))
 	}
+	_, _, err := resolveDnsAddress("quic://1.1.1.1")
+	assert.Error(t, err)
+	_, _, err = resolveDnsAddress("tcp://:53")
+	assert.EqualError(t, err, "missing host")
+	_, _, err = resolveDnsAddress("udp://[::1")
+	assert.Error(t, err)
 }
 
 func TestSSHFPAlgorithm(t *testing.T) {
@@ -80,7 +93,7 @@ func querySSHFP(server dnsServer, request []byte, id uint16, name string) ([]ssh
 	if err != nil {
 		return nil, false, err
 	}
-	return parseSSHFP(response.Answer, name), validateSSHFPDNSSEC(response, name), nil
+	return parseSSHFP(response.Answer, name), validateSSHFPDNSSEC([]dnsServer{server}, response, name), nil
 }
 
 func TestQuerySSHFPRejectsNonQueryOpcode(t *testing.T) {
@@ -106,7 +119,7 @@ func TestVerifyHostKeyDNSValidatedChainAccepts(t *testing.T) {
 	key := testSSHKey(t)
 	responses, anchors := testDNSSECChain(t, "host.example.test.", key, false)
 	withDNSSECTestLookup(t, responses, anchors)
-	found, matched, authenticate, err := verifyHostKeyDNS("host.example.test:22", key)
+	found, matched, authenticate, err := verifyHostKeyDNS(nil, "host.example.test:22", key)
 	assert.NoError(t, err)
 	assert.True(t, found)
 	assert.True(t, matched)
@@ -117,7 +130,7 @@ func TestLookupSSHFPADBitOnlyDoesNotAuthenticate(t *testing.T) {
 	key := testSSHKey(t)
 	msg := testSSHFPOnlyResponse("host.example.test.", key, true)
 	withDNSSECTestLookup(t, map[string]*dns.Msg{testLookupKey("host.example.test.", dns.TypeSSHFP): msg}, nil)
-	_, matched, authenticate, err := verifyHostKeyDNS("host.example.test", key)
+	_, matched, authenticate, err := verifyHostKeyDNS(nil, "host.example.test", key)
 	assert.NoError(t, err)
 	assert.True(t, matched)
 	assert.False(t, authenticate())
@@ -127,7 +140,7 @@ func TestLookupSSHFPRejectsTamperedRRSIG(t *testing.T) {
 	key := testSSHKey(t)
 	responses, anchors := testDNSSECChain(t, "host.example.test.", key, true)
 	withDNSSECTestLookup(t, responses, anchors)
-	_, matched, authenticate, err := verifyHostKeyDNS("host.example.test", key)
+	_, matched, authenticate, err := verifyHostKeyDNS(nil, "host.example.test", key)
 	assert.NoError(t, err)
 	assert.True(t, matched)
 	assert.False(t, authenticate())
@@ -143,7 +156,7 @@ func TestCompressedRRSIGSignerNameValidates(t *testing.T) {
 	assert.NoError(t, unpacked.Unpack(packed))
 	responses[testLookupKey("host.example.test.", dns.TypeSSHFP)] = &unpacked
 	withDNSSECTestLookup(t, responses, anchors)
-	_, _, authenticate, err := verifyHostKeyDNS("host.example.test", key)
+	_, _, authenticate, err := verifyHostKeyDNS(nil, "host.example.test", key)
 	assert.NoError(t, err)
 	assert.True(t, authenticate())
 }
@@ -238,7 +251,7 @@ func testSignedResponse(t *testing.T, owner string, rrtype uint16, records []dns
 func withDNSSECTestLookup(t *testing.T, responses map[string]*dns.Msg, anchors []*dns.DS) {
 	t.Helper()
 	oldLookup, oldAnchors, oldNow := lookupDNSSEC, dnssecRootTrustAnchors, dnssecNow
-	lookupDNSSEC = func(name string, rrtype uint16) (*dns.Msg, error) {
+	lookupDNSSEC = func(_ []dnsServer, name string, rrtype uint16) (*dns.Msg, error) {
 		if response := responses[testLookupKey(name, rrtype)]; response != nil {
 			return response, nil
 		}
@@ -308,3 +321,124 @@ func waitDNSServer(t *testing.T, done <-chan error) error {
 		return nil
 	}
 }
+
+func testStubDNSHandler(key ssh.PublicKey) dns.HandlerFunc {
+	return func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		for _, q := range r.Question {
+			switch q.Qtype {
+			case dns.TypeA:
+				m.Answer = append(m.Answer, &dns.A{Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}, A: net.ParseIP("192.0.2.10")})
+			case dns.TypeSRV:
+				m.Answer = append(m.Answer, &dns.SRV{Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeSRV, Class: dns.ClassINET, Ttl: 60}, Port: 2222, Target: "ssh.example.test."})
+			case dns.TypeSSHFP:
+				m.Answer = append(m.Answer, testSSHFP(q.Name, key))
+			}
+		}
+		_ = w.WriteMsg(m)
+	}
+}
+
+func startStubDoHServer(t *testing.T, handler dns.HandlerFunc) (*httptest.Server, *x509.CertPool) {
+	t.Helper()
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		var request dns.Msg
+		if err != nil || r.Method != http.MethodPost || request.Unpack(body) != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		recorder := &testDNSResponseWriter{}
+		handler(recorder, &request)
+		response, _ := recorder.msg.Pack()
+		w.Header().Set("Content-Type", "application/dns-message")
+		_, _ = w.Write(response)
+	}))
+	t.Cleanup(server.Close)
+	pool := x509.NewCertPool()
+	pool.AddCert(server.Certificate())
+	return server, pool
+}
+
+type testDNSResponseWriter struct {
+	dns.ResponseWriter
+	msg *dns.Msg
+}
+
+func (w *testDNSResponseWriter) WriteMsg(m *dns.Msg) error {
+	w.msg = m
+	return nil
+}
+
+func TestDNSOverTLSAndHTTPS(t *testing.T) {
+	key := testSSHKey(t)
+	handler := testStubDNSHandler(key)
+	dohServer, pool := startStubDoHServer(t, handler)
+	oldRootCAs := dnsTLSRootCAs
+	dnsTLSRootCAs = pool
+	t.Cleanup(func() { dnsTLSRootCAs = oldRootCAs })
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: dohServer.TLS.Certificates})
+	assert.NoError(t, err)
+	dotServer := &dns.Server{Listener: listener, Net: "tcp-tls", Handler: handler}
+	go func() { _ = dotServer.ActivateAndServe() }()
+	t.Cleanup(func() { _ = dotServer.Shutdown() })
+
+	name, request := testSSHFPQuery(t)
+	id := uint16(request[0])<<8 | uint16(request[1])
+	for _, server := range []dnsServer{{network: "https", addr: dohServer.URL + "/dns-query"}, {network: "tls", addr: listener.Addr().String()}} {
+		records, _, err := querySSHFP(server, request, id, name)
+		assert.NoError(t, err, server.network)
+		assert.True(t, matchSSHFP(records, key), server.network)
+
+		resolver := newDnsResolver(server.network, server.addr)
+		addrs, err := resolver.LookupHost(context.Background(), "host.example.test")
+		assert.NoError(t, err, server.network)
+		assert.Equal(t, []string{"192.0.2.10"}, addrs, server.network)
+		_, srvs, err := resolver.LookupSRV(context.Background(), "ssh", "tcp", "example.test")
+		assert.NoError(t, err, server.network)
+		if assert.Len(t, srvs, 1, server.network) {
+			assert.Equal(t, uint16(2222), srvs[0].Port)
+		}
+	}
+
+	_, err = dialDnsServer("https", dohServer.URL+"/dns-query", time.Second)
+	assert.NoError(t, err)
+	dnsTLSRootCAs = nil
+	_, _, err = querySSHFP(dnsServer{network: "tls", addr: listener.Addr().String()}, request, id, name)
+	assert.Error(t, err)
+}
+
+func TestHostDnsServer(t *testing.T) {
+	oriUserConfig := userConfig
+	userConfig = &tsshConfig{}
+	t.Cleanup(func() { userConfig = oriUserConfig })
+
+	args := &sshArgs{Destination: "user@example.test:2222"}
+	assert.NoError(t, args.Option.UnmarshalText([]byte("DnsServer=tls://1.1.1.1")))
+	assert.Equal(t, "tls://1.1.1.1", getHostDnsServer(args))
+	assert.NotNil(t, getHostDnsResolver(args))
+	args.DNS = "8.8.8.8"
+	assert.Nil(t, getHostDnsResolver(args))
+	oriEnableWarning := enableWarningLogging
+	enableWarningLogging = false
+	t.Cleanup(func() { enableWarningLogging = oriEnableWarning })
+	invalidArgs := &sshArgs{Destination: "example.test"}
+	assert.NoError(t, invalidArgs.Option.UnmarshalText([]byte("DnsServer=quic://1.1.1.1")))
+	assert.Nil(t, getHostDnsResolver(invalidArgs))
+	assert.Equal(t, "", getHostDnsServer(&sshArgs{}))
+
+	oriResolver, oriCustomDnsServer := net.DefaultResolver, customDnsServer
+	t.Cleanup(func() { net.DefaultResolver, customDnsServer = oriResolver, oriCustomDnsServer })
+	customDnsServer = dnsServer{}
+	args.DNS = ""
+	setupCustomDNS(args)
+	assert.Same(t, oriResolver, net.DefaultResolver)
+	assert.Equal(t, dnsServer{}, customDnsServer)
+	assert.Equal(t, []dnsServer{{network: "tls", addr: "1.1.1.1:853"}}, dnsServers(args))
+	customDnsServer = dnsServer{network: "udp", addr: "8.8.8.8:53"}
+	assert.Equal(t, []dnsServer{customDnsServer}, dnsServers(&sshArgs{Destination: "other.test"}))
+	args.DNS = "8.8.8.8"
+	assert.Equal(t, []dnsServer{customDnsServer}, dnsServers(args))
+}
@@ -29,6 +29,8 @@ import (
 	"os"
 	"regexp"
 	"strings"
+
+	"github.com/trzsz/shellescape"
 )
 
 type sshEnv struct {
@@ -129,6 +131,41 @@ func getSetEnvs(args *sshArgs) ([]*sshEnv, error) {
 	return envs, nil
 }
 
+// setRemoteEnvs sets the envs by env requests, and returns the envs rejected by the server,
+// e.g., not accepted by AcceptEnv, which have to be set through the remote command.
+func setRemoteEnvs(session SshSession, envs []*sshEnv) []*sshEnv {
+	var rejected []*sshEnv
+	for _, env := range envs {
+		if err := session.Setenv(env.name, env.value); err != nil {
+			debug("set env rejected: %s = \"%s\"", env.name, env.value)
+			rejected = append(rejected, env)
+		} else {
+			debug("set env success: %s = \"%s\"", env.name, env.value)
+		}
+	}
+	return rejected
+}
+
+// getRemoteEnvCommand sets the envs rejected by the server through the remote command,
+// which requires a POSIX shell on the server. An empty command runs the login shell.
+func getRemoteEnvCommand(cmd string, envs []*sshEnv) string {
+	if len(envs) == 0 {
+		return cmd
+	}
+	debug("set %d rejected envs through the remote command", len(envs))
+	var buf strings.Builder
+	buf.WriteString("exec env")
+	for _, env := range envs {
+		fmt.Fprintf(&buf, " %s=%s", env.name, shellescape.Quote(env.value))
+	}
+	if cmd == "" {
+		buf.WriteString(` "$SHELL" -l`)
+	} else {
+		fmt.Fprintf(&buf, ` "$SHELL" -c %s`, shellescape.Quote(cmd))
+	}
+	return buf.String()
+}
+
 func sendAndSetEnv(args *sshArgs, session SshSession) (string, error) {
 	sendEnvs, err := getSendEnvs(args)
 	if err != nil {
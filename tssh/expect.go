@@ -424,7 +424,7 @@ func (e *sshExpect) getExpectSender(idx int, question string) *expectSender {
 			warning("decode %sExpectSendEncTotp%d [%s] failed: %v", e.pre, idx, encTotp, err)
 			return nil
 		}
-		return newPassSender(e, getTotpCode(secret))
+		return newPassSender(e, getOtpCode(secret))
 	}
 
 	if encOtp := getExOptionConfig(e.param.args, fmt.Sprintf("%sExpectSendEncOtp%d", e.pre, idx)); encOtp != "" {
@@ -437,7 +437,7 @@ func (e *sshExpect) getExpectSender(idx int, question string) *expectSender {
 	}
 
 	if secret := getExOptionConfig(e.param.args, fmt.Sprintf("%sExpectSendTotp%d", e.pre, idx)); secret != "" {
-		return newPassSender(e, getTotpCode(secret))
+		return newPassSender(e, getOtpCode(secret))
 	}
 
 	if command := getExOptionConfig(e.param.args, fmt.Sprintf("%sExpectSendOtp%d", e.pre, idx)); command != "" {
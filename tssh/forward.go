@@ -414,6 +414,9 @@ func sshPortForward(sshConn *sshConnection) {
 		}
 		remoteForward(sshConn, f, gateway, timeout)
 	}
+
+	// auto forward the remote listening ports
+	autoForwardPorts(sshConn, timeout)
 }
 
 func forwardChannel(channel ssh.Channel, conn net.Conn) {
@@ -0,0 +1,341 @@
+/*
+MIT License
+
+Copyright (c) 2023-2026 The Trzsz SSH Authors.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package tssh
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/trzsz/tsshd/tsshd"
+)
+
+const kDefaultAutoForwardInterval = 3 * time.Second
+
+const kListeningPortsCommand = "cat /proc/net/tcp /proc/net/tcp6 2>/dev/null || ss -Hltn 2>/dev/null"
+
+const kListeningPortsEndMark = "--tssh-listening-ports-end--"
+
+type autoForwardedPort struct {
+	remotePort uint16
+	remoteAddr string
+	localAddr  string
+	listener   net.Listener
+}
+
+type autoPortForwarder struct {
+	sshConn  *sshConnection
+	timeout  time.Duration
+	excludes map[uint16]bool
+	mutex    sync.Mutex
+	ports    map[uint16]*autoForwardedPort
+}
+
+func autoForwardPorts(sshConn *sshConnection, timeout time.Duration) {
+	args := sshConn.param.args
+	if !strings.EqualFold(getExOptionConfig(args, "AutoForwardPorts"), "yes") {
+		return
+	}
+	if _, ok := sshConn.client.(*lazySshClient); ok {
+		warning("AutoForwardPorts does not work with LazyForward")
+		return
+	}
+
+	interval := kDefaultAutoForwardInterval
+	if value := getExOptionConfig(args, "AutoForwardPortsInterval"); value != "" {
+		seconds, err := convertSshTime(value)
+		if err != nil {
+			warning("AutoForwardPortsInterval [%s] invalid: %v", value, err)
+		} else if seconds > 0 {
+			interval = time.Duration(seconds) * time.Second
+		}
+	}
+
+	// don't forward the remote forwarding ports back
+	excludes := make(map[uint16]bool)
+	for _, f := range args.RemoteForward.cfgs {
+		excludes[uint16(f.bindPort)] = true
+	}
+	for _, s := range getAllExOptionConfig(args, "RemoteForward", false) {
+		if f, err := parseForwardCfg(sshConn.param, false, s); err == nil {
+			excludes[uint16(f.bindPort)] = true
+		}
+	}
+
+	forwarder := &autoPortForwarder{
+		sshConn:  sshConn,
+		timeout:  timeout,
+		excludes: excludes,
+		ports:    make(map[uint16]*autoForwardedPort),
+	}
+	sshConn.autoForwarder.Store(forwarder)
+	go forwarder.run(interval)
+}
+
+func (f *autoPortForwarder) run(interval time.Duration) {
+	defer f.closeAll()
+	succeeded := false
+	for !f.sshConn.closed.Load() {
+		err := f.pollListeningPorts(interval, func(ports map[uint16]string) {
+			succeeded = true
+			f.update(ports)
+		})
+		if f.sshConn.closed.Load() {
+			return
+		}
+		if !succeeded {
+			warning("AutoForwardPorts stopped since query listening ports failed: %v", err)
+			return
+		}
+		debug("auto forwarding poll listening ports failed: %v", err)
+		time.Sleep(interval)
+	}
+}
+
+// getPollListeningPortsCommand returns a long-running command which outputs the
+// listening ports every interval, so that only one session channel is opened.
+func getPollListeningPortsCommand(interval time.Duration) string {
+	return fmt.Sprintf("while :; do %s; echo %s; sleep %d || break; done",
+		kListeningPortsCommand, kListeningPortsEndMark, max(int(interval/time.Second), 1))
+}
+
+func (f *autoPortForwarder) pollListeningPorts(interval time.Duration, onPorts func(map[uint16]string)) error {
+	session, err := f.sshConn.client.NewSession()
+	if err != nil {
+		return fmt.Errorf("new session failed: %v", err)
+	}
+	defer func() { _ = session.Close() }()
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("stdout pipe failed: %v", err)
+	}
+	command := getPollListeningPortsCommand(interval)
+	if err := session.Start(command); err != nil {
+		return fmt.Errorf("start [%s] failed: %v", command, err)
+	}
+	return readListeningPorts(stdout, onPorts)
+}
+
+// readListeningPorts reads the output of the polling command until it exits,
+// and calls onPorts with the listening ports of each round.
+func readListeningPorts(reader io.Reader, onPorts func(map[uint16]string)) error {
+	var output bytes.Buffer
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if string(line) != kListeningPortsEndMark {
+			output.Write(line)
+			output.WriteByte('\n')
+			continue
+		}
+		if output.Len() == 0 {
+			return fmt.Errorf("run [%s] output nothing", kListeningPortsCommand)
+		}
+		onPorts(parseListeningPorts(output.Bytes()))
+		output.Reset()
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("read listening ports failed: %v", err)
+	}
+	return fmt.Errorf("the polling command exited")
+}
+
+func (f *autoPortForwarder) update(ports map[uint16]string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	for port, p := range f.ports {
+		if _, ok := ports[port]; !ok {
+			_ = p.listener.Close()
+			delete(f.ports, port)
+			notice("Remote port %d is closed, the forwarding from %s is removed", port, p.localAddr)
+		}
+	}
+
+	// the local listeners are listening on the remote too if the remote is the local machine,
+	// don't forward them back, or a new local listener will be opened for each of them again.
+	listening := make(map[uint16]bool, len(f.ports))
+	for _, p := range f.ports {
+		if addr, ok := p.listener.Addr().(*net.TCPAddr); ok {
+			listening[uint16(addr.Port)] = true
+		}
+	}
+
+	for port, host := range ports {
+		if f.excludes[port] || f.ports[port] != nil || listening[port] {
+			continue
+		}
+		if port < 1024 {
+			debug("auto forwarding skips the privileged remote port [%d]", port)
+			f.excludes[port] = true
+			continue
+		}
+		p := &autoForwardedPort{remotePort: port, remoteAddr: joinHostPort(host, strconv.Itoa(int(port)))}
+		listener, err := net.Listen("tcp", joinHostPort("127.0.0.1", strconv.Itoa(int(port))))
+		if err != nil {
+			debug("auto forwarding listen on local port [%d] failed: %v", port, err)
+			listener, err = net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				warning("auto forwarding remote port [%d] failed: %v", port, err)
+				f.excludes[port] = true
+				continue
+			}
+		}
+		p.listener = listener
+		p.localAddr = listener.Addr().String()
+		if addr, ok := listener.Addr().(*net.TCPAddr); ok {
+			listening[uint16(addr.Port)] = true
+		}
+		f.ports[port] = p
+		go f.serve(p)
+		notice("Remote port %d is listening, forwarded to %s", port, p.localAddr)
+	}
+}
+
+func (f *autoPortForwarder) serve(p *autoForwardedPort) {
+	defer func() { _ = p.listener.Close() }()
+	for {
+		local, err := p.listener.Accept()
+		if err != nil {
+			if !tsshd.IsClosedError(err) {
+				warning("auto forwarding [%s] accept failed: %v", p.remoteAddr, err)
+			}
+			debug("auto forwarding [%s] closed: %v", p.remoteAddr, err)
+			return
+		}
+		remote, err := f.sshConn.client.DialTimeout("tcp", p.remoteAddr, f.timeout)
+		if err != nil {
+			warning("auto forwarding dial [%s] failed: %v", p.remoteAddr, err)
+			_ = local.Close()
+			continue
+		}
+		go tcpForward(f.sshConn.client, local, remote)
+	}
+}
+
+func (f *autoPortForwarder) closeAll() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for port, p := range f.ports {
+		_ = p.listener.Close()
+		delete(f.ports, port)
+	}
+}
+
+// listForwardedPorts returns the auto forwarded ports like "3000->127.0.0.1:3000".
+func (f *autoPortForwarder) listForwardedPorts() []string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	ports := make([]uint16, 0, len(f.ports))
+	for port := range f.ports {
+		ports = append(ports, port)
+	}
+	slices.Sort(ports)
+	list := make([]string, 0, len(ports))
+	for _, port := range ports {
+		list = append(list, fmt.Sprintf("%d->%s", port, f.ports[port].localAddr))
+	}
+	return list
+}
+
+// parseListeningPorts parses the output of /proc/net/tcp or `ss -Hltn`, and returns
+// the listening ports which are reachable from the remote loopback address, mapped
+// to the host to dial.
+func parseListeningPorts(output []byte) map[uint16]string {
+	ports := make(map[uint16]string)
+	addPort := func(ip net.IP, port uint64) {
+		if ip == nil || port == 0 || port > 65535 || !(ip.IsLoopback() || ip.IsUnspecified()) {
+			return
+		}
+		host := "127.0.0.1"
+		if ip.To4() == nil && ip.IsLoopback() {
+			host = "::1"
+		}
+		if _, ok := ports[uint16(port)]; !ok || host == "127.0.0.1" {
+			ports[uint16(port)] = host
+		}
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 4 && strings.HasSuffix(fields[0], ":") {
+			// /proc/net/tcp: sl local_address rem_address st ...
+			if fields[3] != "0A" { // TCP_LISTEN
+				continue
+			}
+			addr, port, ok := strings.Cut(fields[1], ":")
+			if !ok {
+				continue
+			}
+			p, err := strconv.ParseUint(port, 16, 16)
+			if err != nil {
+				continue
+			}
+			addPort(parseProcNetAddr(addr), p)
+		} else if len(fields) >= 4 && fields[0] == "LISTEN" {
+			// ss -Hltn: State Recv-Q Send-Q Local-Address:Port Peer-Address:Port
+			idx := strings.LastIndexByte(fields[3], ':')
+			if idx < 0 {
+				continue
+			}
+			p, err := strconv.ParseUint(fields[3][idx+1:], 10, 16)
+			if err != nil {
+				continue
+			}
+			host := strings.Trim(fields[3][:idx], "[]")
+			if i := strings.IndexByte(host, '%'); i >= 0 {
+				host = host[:i]
+			}
+			if host == "*" {
+				host = "0.0.0.0"
+			}
+			addPort(net.ParseIP(host), p)
+		}
+	}
+	return ports
+}
+
+// parseProcNetAddr parses the hex address in /proc/net/tcp{,6}, which is
+// stored as 32-bit words in host byte order, assuming a little endian host.
+func parseProcNetAddr(addr string) net.IP {
+	buf, err := hex.DecodeString(addr)
+	if err != nil || (len(buf) != net.IPv4len && len(buf) != net.IPv6len) {
+		return nil
+	}
+	ip := make(net.IP, len(buf))
+	for i := 0; i < len(buf); i += 4 {
+		ip[i], ip[i+1], ip[i+2], ip[i+3] = buf[i+3], buf[i+2], buf[i+1], buf[i]
+	}
+	return ip
+}
@@ -0,0 +1,326 @@
+/*
+MIT License
+
+Copyright (c) 2023-2026 The Trzsz SSH Authors.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package tssh
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const kDefaultLazyForwardIdleTimeout = 5 * time.Minute
+
+// lazySshClient is a SshClient for local and dynamic forwarding only.
+// It logs in on the first forwarded connection, and disconnects after
+// there has been no active forwarded connection for idleTimeout.
+type lazySshClient struct {
+	mutex        sync.Mutex
+	login        func() (SshClient, func(), error)
+	idleTimeout  time.Duration
+	client       SshClient
+	cleanup      func()
+	loginAttempt *lazyLoginAttempt
+	active       int
+	idleTimer    *time.Timer
+	idleSeq      uint64
+	closed       bool
+	closeOnce    sync.Once
+	done         chan struct{}
+}
+
+// lazyLoginAttempt is shared by the forwarded connections waiting for the same login.
+type lazyLoginAttempt struct {
+	done chan struct{}
+	err  error
+}
+
+func newLazySshClient(param *sshParam) *lazySshClient {
+	c := &lazySshClient{
+		login:       func() (SshClient, func(), error) { return lazyForwardLogin(param) },
+		idleTimeout: getLazyForwardIdleTimeout(param.args),
+		done:        make(chan struct{}),
+	}
+	addOnExitFunc(func() { _ = c.Close() })
+	return c
+}
+
+// lazyForwardLogin returns the client and a func to cleanup the resources of this login,
+// such as the proxy jump clients, which would otherwise be kept until exit.
+func lazyForwardLogin(param *sshParam) (SshClient, func(), error) {
+	debug("lazy forwarding login to [%s]", param.args.Destination)
+	p := *param
+	p.proxies = append([]string(nil), param.proxies...)
+
+	onExitCount := countOnExitFuncs()
+	sshLoginSuccess.Store(false)
+	client, err := sshLogin(&p, nil, kUdpModeNo)
+	if err == nil {
+		sshLoginSuccess.Store(true)
+	}
+	cleanupAfterLogin()
+	cleanup := takeOnExitFuncs(onExitCount)
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+
+	// keep alive until the lazy connection is closed, and drop it instead of exiting on timeout
+	if !param.control {
+		done := make(chan struct{})
+		go func() {
+			_ = client.Wait()
+			close(done)
+		}()
+		keepAlive(param, client, done, func(cause string) {
+			warning("lazy forwarding disconnect: %s", cause)
+			_ = client.Close()
+		})
+	}
+
+	// the LocalCommand is executed after each lazy connection is established
+	execLocalCommand(param)
+
+	return client, cleanup, nil
+}
+
+func getLazyForwardIdleTimeout(args *sshArgs) time.Duration {
+	if idleTimeout := getExOptionConfig(args, "LazyForwardIdleTimeout"); idleTimeout != "" {
+		seconds, err := convertSshTime(idleTimeout)
+		if err != nil {
+			warning("LazyForwardIdleTimeout [%s] invalid: %v", idleTimeout, err)
+		} else if seconds > 0 {
+			return time.Duration(seconds) * time.Second
+		}
+	}
+	return kDefaultLazyForwardIdleTimeout
+}
+
+func isLazyForward(param *sshParam) bool {
+	args := param.args
+	if !strings.EqualFold(getExOptionConfig(args, "LazyForward"), "yes") {
+		return false
+	}
+	if !args.NoCommand || args.StdioForward != "" || args.Subsystem {
+		debug("LazyForward is ignored since it only works with -N")
+		return false
+	}
+	if param.udpMode != kUdpModeNo {
+		warning("LazyForward does not work in UDP mode")
+		return false
+	}
+	if len(args.RemoteForward.cfgs) > 0 || len(getAllExOptionConfig(args, "RemoteForward", false)) > 0 {
+		warning("LazyForward does not work with remote forwarding")
+		return false
+	}
+	return true
+}
+
+func (c *lazySshClient) acquire() (SshClient, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for {
+		if c.closed {
+			return nil, fmt.Errorf("lazy forwarding client closed")
+		}
+		if c.idleTimer != nil {
+			c.idleTimer.Stop()
+			c.idleTimer = nil
+		}
+		if c.client != nil {
+			c.active++
+			return c.client, nil
+		}
+
+		// wait for the login of another forwarded connection
+		if attempt := c.loginAttempt; attempt != nil {
+			c.mutex.Unlock()
+			<-attempt.done
+			c.mutex.Lock()
+			if attempt.err != nil {
+				return nil, attempt.err
+			}
+			continue
+		}
+
+		// login without holding the mutex, which may take a long time or wait for user input
+		attempt := &lazyLoginAttempt{done: make(chan struct{})}
+		c.loginAttempt = attempt
+		c.mutex.Unlock()
+		client, cleanup, err := c.login()
+		c.mutex.Lock()
+		c.loginAttempt = nil
+		if err == nil && c.closed {
+			c.mutex.Unlock()
+			_ = client.Close()
+			cleanup()
+			c.mutex.Lock()
+			err = fmt.Errorf("lazy forwarding client closed")
+		}
+		attempt.err = err
+		close(attempt.done)
+		if err != nil {
+			return nil, err
+		}
+		c.client, c.cleanup = client, cleanup
+		go c.waitClient(client)
+	}
+}
+
+func (c *lazySshClient) release() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.active--
+	if c.active > 0 || c.client == nil || c.closed {
+		return
+	}
+	c.idleSeq++
+	seq := c.idleSeq
+	c.idleTimer = time.AfterFunc(c.idleTimeout, func() { c.disconnectIfIdle(seq) })
+}
+
+func (c *lazySshClient) disconnectIfIdle(seq uint64) {
+	c.mutex.Lock()
+	if c.idleSeq != seq || c.idleTimer == nil || c.active > 0 || c.client == nil {
+		c.mutex.Unlock()
+		return
+	}
+	client, cleanup := c.client, c.cleanup
+	c.client, c.cleanup = nil, nil
+	c.idleTimer = nil
+	c.mutex.Unlock()
+
+	debug("lazy forwarding disconnect after idle for %v", c.idleTimeout)
+	_ = client.Close()
+	cleanup()
+}
+
+func (c *lazySshClient) waitClient(client SshClient) {
+	_ = client.Wait()
+	c.mutex.Lock()
+	if c.client != client {
+		c.mutex.Unlock()
+		return
+	}
+	cleanup := c.cleanup
+	c.client, c.cleanup = nil, nil
+	c.mutex.Unlock()
+
+	debug("lazy forwarding connection closed")
+	cleanup()
+}
+
+func (c *lazySshClient) Wait() error {
+	<-c.done
+	return nil
+}
+
+func (c *lazySshClient) Close() error {
+	c.closeOnce.Do(func() {
+		c.mutex.Lock()
+		c.closed = true
+		if c.idleTimer != nil {
+			c.idleTimer.Stop()
+			c.idleTimer = nil
+		}
+		client, cleanup := c.client, c.cleanup
+		c.client, c.cleanup = nil, nil
+		c.mutex.Unlock()
+
+		if client != nil {
+			_ = client.Close()
+			cleanup()
+		}
+		close(c.done)
+	})
+	return nil
+}
+
+func (c *lazySshClient) NewSession() (SshSession, error) {
+	return nil, fmt.Errorf("new session is not supported in LazyForward mode")
+}
+
+func (c *lazySshClient) DialTimeout(network, addr string, timeout time.Duration) (net.Conn, error) {
+	client, err := c.acquire()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := client.DialTimeout(network, addr, timeout)
+	if err != nil {
+		c.release()
+		return nil, err
+	}
+	return &lazyForwardConn{Conn: conn, client: c}, nil
+}
+
+func (c *lazySshClient) Listen(network, addr string) (net.Listener, error) {
+	return nil, fmt.Errorf("remote listening is not supported in LazyForward mode")
+}
+
+func (c *lazySshClient) ListenUDP(network, addr string) (PacketListener, error) {
+	return nil, fmt.Errorf("remote listening is not supported in LazyForward mode")
+}
+
+func (c *lazySshClient) HandleChannelOpen(channelType string) <-chan ssh.NewChannel {
+	return nil
+}
+
+func (c *lazySshClient) SendRequest(name string, wantReply bool, payload []byte) (bool, []byte, error) {
+	return false, nil, fmt.Errorf("global request is not supported in LazyForward mode")
+}
+
+func (c *lazySshClient) DialUDP(network, addr string, timeout time.Duration) (PacketConn, error) {
+	return nil, fmt.Errorf("udp dialing is not supported in LazyForward mode")
+}
+
+// lazyForwardConn releases the lazy client once when it is closed.
+type lazyForwardConn struct {
+	net.Conn
+	client    *lazySshClient
+	closeOnce sync.Once
+}
+
+func (c *lazyForwardConn) Close() error {
+	err := c.Conn.Close()
+	c.closeOnce.Do(c.client.release)
+	return err
+}
+
+func (c *lazyForwardConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return nil
+}
+
+func (c *lazyForwardConn) CloseRead() error {
+	if cr, ok := c.Conn.(interface{ CloseRead() error }); ok {
+		return cr.CloseRead()
+	}
+	return nil
+}
@@ -0,0 +1,252 @@
+/*
+MIT License
+
+Copyright (c) 2023-2026 The Trzsz SSH Authors.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package tssh
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeLazyClient struct {
+	SshClient
+	closed chan struct{}
+}
+
+func (c *fakeLazyClient) Wait() error {
+	<-c.closed
+	return nil
+}
+
+func (c *fakeLazyClient) Close() error {
+	close(c.closed)
+	return nil
+}
+
+func (c *fakeLazyClient) DialTimeout(network, addr string, timeout time.Duration) (net.Conn, error) {
+	local, remote := net.Pipe()
+	_ = remote.Close()
+	return local, nil
+}
+
+func TestLazyForwardClient(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	var logins, cleanups atomic.Int32
+	var lastClient atomic.Pointer[fakeLazyClient]
+	lazy := &lazySshClient{
+		login: func() (SshClient, func(), error) {
+			logins.Add(1)
+			client := &fakeLazyClient{closed: make(chan struct{})}
+			lastClient.Store(client)
+			return client, func() { cleanups.Add(1) }, nil
+		},
+		idleTimeout: 100 * time.Millisecond,
+		done:        make(chan struct{}),
+	}
+	assert.Equal(int32(0), logins.Load())
+
+	conn1, err := lazy.DialTimeout("tcp", "127.0.0.1:80", time.Second)
+	require.Nil(err)
+	conn2, err := lazy.DialTimeout("tcp", "127.0.0.1:80", time.Second)
+	require.Nil(err)
+	assert.Equal(int32(1), logins.Load())
+
+	_ = conn1.Close()
+	_ = conn1.Close()
+	time.Sleep(300 * time.Millisecond)
+	assert.NotNil(lastClient.Load())
+	select {
+	case <-lastClient.Load().closed:
+		assert.Fail("disconnected while a forwarded connection is active")
+	default:
+	}
+
+	assert.Equal(int32(0), cleanups.Load())
+
+	_ = conn2.Close()
+	select {
+	case <-lastClient.Load().closed:
+	case <-time.After(time.Second):
+		assert.Fail("not disconnected after idle timeout")
+	}
+	assert.Eventually(func() bool { return cleanups.Load() == 1 }, time.Second, 10*time.Millisecond)
+
+	conn3, err := lazy.DialTimeout("tcp", "127.0.0.1:80", time.Second)
+	require.Nil(err)
+	assert.Equal(int32(2), logins.Load())
+	_ = conn3.Close()
+
+	_ = lazy.Close()
+	_ = lazy.Wait()
+	_, err = lazy.DialTimeout("tcp", "127.0.0.1:80", time.Second)
+	assert.NotNil(err)
+	assert.Equal(int32(2), logins.Load())
+	assert.Equal(int32(2), cleanups.Load())
+}
+
+func TestLazyForwardConcurrentLogin(t *testing.T) {
+	assert := assert.New(t)
+
+	var logins atomic.Int32
+	loginStarted := make(chan struct{})
+	loginContinue := make(chan struct{})
+	lazy := &lazySshClient{
+		login: func() (SshClient, func(), error) {
+			if logins.Add(1) == 1 {
+				close(loginStarted)
+				<-loginContinue
+				return nil, nil, fmt.Errorf("login failed")
+			}
+			return &fakeLazyClient{closed: make(chan struct{})}, func() {}, nil
+		},
+		idleTimeout: time.Minute,
+		done:        make(chan struct{}),
+	}
+
+	// the forwarded connections waiting for the same login share its result
+	var wg sync.WaitGroup
+	errs := make(chan error, 3)
+	for range 3 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := lazy.DialTimeout("tcp", "127.0.0.1:80", time.Second)
+			errs <- err
+		}()
+	}
+	<-loginStarted
+	time.Sleep(100 * time.Millisecond)
+
+	// the mutex is not held during the login
+	released := make(chan struct{})
+	go func() {
+		lazy.mutex.Lock()
+		defer lazy.mutex.Unlock()
+		close(released)
+	}()
+	select {
+	case <-released:
+	case <-time.After(time.Second):
+		assert.Fail("the mutex is held during the login")
+	}
+
+	close(loginContinue)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		assert.EqualError(err, "login failed")
+	}
+	assert.Equal(int32(1), logins.Load())
+
+	// login again after the failure
+	conn, err := lazy.DialTimeout("tcp", "127.0.0.1:80", time.Second)
+	assert.Nil(err)
+	assert.Equal(int32(2), logins.Load())
+	_ = conn.Close()
+	_ = lazy.Close()
+}
+
+type fakeKeepAliveClient struct {
+	SshClient
+	requests atomic.Int32
+}
+
+func (c *fakeKeepAliveClient) SendRequest(name string, wantReply bool, payload []byte) (bool, []byte, error) {
+	c.requests.Add(1)
+	return true, nil, nil
+}
+
+func TestLazyForwardKeepAlive(t *testing.T) {
+	assert := assert.New(t)
+	oriUserConfig := userConfig
+	userConfig = &tsshConfig{}
+	defer func() { userConfig = oriUserConfig }()
+
+	newParam := func(options ...string) *sshParam {
+		args := &sshArgs{Destination: "lazyhost"}
+		for _, option := range options {
+			require.NoError(t, args.Option.UnmarshalText([]byte(option)))
+		}
+		return &sshParam{args: args}
+	}
+
+	// stop sending keep alive after the lazy connection is closed
+	client := &fakeKeepAliveClient{}
+	done := make(chan struct{})
+	keepAlive(newParam("ServerAliveInterval=1", "ServerAliveCountMax=0"), client, done, func(cause string) {
+		assert.Fail("unexpected keep alive timeout", cause)
+	})
+	assert.Eventually(func() bool { return client.requests.Load() > 0 }, 3*time.Second, 10*time.Millisecond)
+	close(done)
+	requests := client.requests.Load()
+	time.Sleep(1500 * time.Millisecond)
+	assert.Equal(requests, client.requests.Load())
+
+	// report the timeout instead of exiting
+	var mutex sync.Mutex
+	var timeoutCause string
+	keepAlive(newParam("ServerAliveInterval=1", "ServerAliveCountMax=1"), &fakeKeepAliveClient{}, nil, func(cause string) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		timeoutCause = cause
+	})
+	assert.Eventually(func() bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return timeoutCause != ""
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Contains(timeoutCause, "keep-alive timeout")
+}
+
+func TestTakeOnExitFuncs(t *testing.T) {
+	assert := assert.New(t)
+	oriOnExitFuncs := onExitFuncs
+	onExitFuncs = nil
+	defer func() { onExitFuncs = oriOnExitFuncs }()
+
+	var order []int
+	addOnExitFunc(func() { order = append(order, 1) })
+	count := countOnExitFuncs()
+	addOnExitFunc(func() { order = append(order, 2) })
+	addOnExitFunc(func() { order = append(order, 3) })
+
+	cleanup := takeOnExitFuncs(count)
+	assert.Equal(1, countOnExitFuncs())
+	cleanup()
+	assert.Equal([]int{3, 2}, order)
+
+	takeOnExitFuncs(count)()
+	assert.Equal([]int{3, 2}, order)
+	cleanupOnExit()
+	assert.Equal([]int{3, 2, 1}, order)
+}
@@ -0,0 +1,302 @@
+/*
+MIT License
+
+Copyright (c) 2023-2026 The Trzsz SSH Authors.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package tssh
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// forwardPolicy restricts who can connect to a local forwarding listener,
+// which is important when the listener is exposed by -g or GatewayPorts.
+type forwardPolicy struct {
+	name     string
+	allow    []*net.IPNet
+	deny     []*net.IPNet
+	maxConns int32
+	active   atomic.Int32
+	logPath  string
+	logMutex sync.Mutex
+}
+
+// getForwardPolicy returns the policy for the local forwarding bound on the given port
+// ( or unix socket path ), or nil if there is no restriction or logging configured.
+//
+//	ForwardAllowFrom <port|*> <cidr> [cidr ...]
+//	ForwardDenyFrom <port|*> <cidr> [cidr ...]
+//	ForwardMaxConnections <port|*> <count>
+//	ForwardConnectionLog /path/to/log
+func getForwardPolicy(args *sshArgs, bindPort, name string) *forwardPolicy {
+	policy := &forwardPolicy{name: name}
+
+	matchPort := func(option, value string) []string {
+		fields := strings.Fields(value)
+		if len(fields) < 2 {
+			warning("%s [%s] invalid: the port ( or '*' ) and the values are required", option, value)
+			return nil
+		}
+		if fields[0] != "*" && fields[0] != bindPort {
+			return nil
+		}
+		return fields[1:]
+	}
+
+	for _, option := range []string{"ForwardAllowFrom", "ForwardDenyFrom"} {
+		for _, value := range getAllExOptionConfig(args, option, false) {
+			for _, cidr := range matchPort(option, value) {
+				ipNet, err := parseForwardCIDR(cidr)
+				if err != nil {
+					warning("%s [%s] invalid: %v", option, value, err)
+					continue
+				}
+				if option == "ForwardAllowFrom" {
+					policy.allow = append(policy.allow, ipNet)
+				} else {
+					policy.deny = append(policy.deny, ipNet)
+				}
+			}
+		}
+	}
+
+	// the count for the bind port takes precedence over the count for '*', regardless of the order
+	var portConns, anyConns int32
+	for _, value := range getAllExOptionConfig(args, "ForwardMaxConnections", false) {
+		fields := matchPort("ForwardMaxConnections", value)
+		if len(fields) == 0 {
+			continue
+		}
+		count, err := strconv.ParseInt(fields[0], 10, 32)
+		if err != nil || count <= 0 || len(fields) > 1 {
+			warning("ForwardMaxConnections [%s] invalid: the count should be a positive number", value)
+			continue
+		}
+		if strings.HasPrefix(value, "*") {
+			if anyConns == 0 {
+				anyConns = int32(count)
+			}
+		} else if portConns == 0 {
+			portConns = int32(count)
+		}
+	}
+	policy.maxConns = portConns
+	if policy.maxConns == 0 {
+		policy.maxConns = anyConns
+	}
+
+	if path := getExOptionConfig(args, "ForwardConnectionLog"); path != "" {
+		policy.logPath = resolveHomeDir(path)
+	}
+
+	if len(policy.allow) == 0 && len(policy.deny) == 0 && policy.maxConns == 0 && policy.logPath == "" {
+		return nil
+	}
+	debug("%s allow %v deny %v max connections %d", name, policy.allow, policy.deny, policy.maxConns)
+	return policy
+}
+
+func forwardBindKey(addr *string, port int) string {
+	if port == -1 && addr != nil {
+		return *addr
+	}
+	return strconv.Itoa(port)
+}
+
+func parseForwardCIDR(cidr string) (*net.IPNet, error) {
+	if !strings.Contains(cidr, "/") {
+		ip := net.ParseIP(cidr)
+		if ip == nil {
+			return nil, fmt.Errorf("[%s] is not an IP address or CIDR", cidr)
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+	}
+	_, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, err
+	}
+	return ipNet, nil
+}
+
+// checkSource checks the source address against the deny list first, then the allow list.
+// The unix socket connections are not restricted by the CIDR lists.
+func (p *forwardPolicy) checkSource(addr net.Addr) error {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return nil
+	}
+	ip := tcpAddr.IP
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	for _, ipNet := range p.deny {
+		if ipNet.Contains(ip) {
+			return fmt.Errorf("denied by %v", ipNet)
+		}
+	}
+	if len(p.allow) == 0 {
+		return nil
+	}
+	for _, ipNet := range p.allow {
+		if ipNet.Contains(ip) {
+			return nil
+		}
+	}
+	return fmt.Errorf("not in the allow list")
+}
+
+func (p *forwardPolicy) acquire() bool {
+	for {
+		active := p.active.Load()
+		if p.maxConns > 0 && active >= p.maxConns {
+			return false
+		}
+		if p.active.CompareAndSwap(active, active+1) {
+			return true
+		}
+	}
+}
+
+func (p *forwardPolicy) release() {
+	p.active.Add(-1)
+}
+
+func (p *forwardPolicy) log(format string, a ...any) {
+	if p.logPath == "" {
+		return
+	}
+	p.logMutex.Lock()
+	defer p.logMutex.Unlock()
+	file, err := os.OpenFile(p.logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		debug("open forward connection log [%s] failed: %v", p.logPath, err)
+		return
+	}
+	defer func() { _ = file.Close() }()
+	line := fmt.Sprintf("%s %s %s\n", time.Now().Format(time.RFC3339), p.name, fmt.Sprintf(format, a...))
+	_ = writeAll(file, []byte(line))
+}
+
+func (p *forwardPolicy) wrapListeners(listeners []net.Listener) []net.Listener {
+	for i, listener := range listeners {
+		listeners[i] = &policyListener{listener, p}
+	}
+	return listeners
+}
+
+type policyListener struct {
+	net.Listener
+	policy *forwardPolicy
+}
+
+func (l *policyListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		source := conn.RemoteAddr().String()
+		if err := l.policy.checkSource(conn.RemoteAddr()); err != nil {
+			debug("%s rejected connection from [%s]: %v", l.policy.name, source, err)
+			l.policy.log("rejected %s: %v", source, err)
+			_ = conn.Close()
+			continue
+		}
+		if !l.policy.acquire() {
+			debug("%s rejected connection from [%s]: too many connections", l.policy.name, source)
+			l.policy.log("rejected %s: too many connections", source)
+			_ = conn.Close()
+			continue
+		}
+		l.policy.log("accepted %s", source)
+		return &policyConn{Conn: conn, policy: l.policy, source: source, start: time.Now()}, nil
+	}
+}
+
+// setForwardDest records the destination of the forwarded connection in the connection log.
+func setForwardDest(conn net.Conn, dest string) {
+	if c, ok := conn.(*policyConn); ok {
+		c.dest.Store(&dest)
+		c.policy.log("connect %s to %s", c.source, dest)
+	}
+}
+
+type policyConn struct {
+	net.Conn
+	policy    *forwardPolicy
+	source    string
+	dest      atomic.Pointer[string]
+	start     time.Time
+	received  atomic.Int64
+	sent      atomic.Int64
+	closeOnce sync.Once
+}
+
+func (c *policyConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.received.Add(int64(n))
+	return n, err
+}
+
+func (c *policyConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.sent.Add(int64(n))
+	return n, err
+}
+
+func (c *policyConn) Close() error {
+	err := c.Conn.Close()
+	c.closeOnce.Do(func() {
+		c.policy.release()
+		source := c.source
+		if dest := c.dest.Load(); dest != nil {
+			source += " to " + *dest
+		}
+		c.policy.log("closed %s duration %v received %d sent %d", source,
+			time.Since(c.start).Round(time.Millisecond), c.received.Load(), c.sent.Load())
+	})
+	return err
+}
+
+func (c *policyConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return nil
+}
+
+func (c *policyConn) CloseRead() error {
+	if cr, ok := c.Conn.(interface{ CloseRead() error }); ok {
+		return cr.CloseRead()
+	}
+	return nil
+}
@@ -190,29 +190,37 @@ func (d sshResolver) Resolve(ctx context.Context, name string) (context.Context,
 
 func dynamicForward(sshConn *sshConnection, b *bindCfg, gateway bool, timeout time.Duration, unlinkUnix bool, bindMask int) {
 	var dialError = errors.New("DIAL_ERROR_" + uuid.NewString())
-	server, err := socks5.New(&socks5.Config{
-		Resolver: &sshResolver{},
-		Dial: func(ctx context.Context, network, addr string) (net.Conn, error) {
-			conn, err := sshConn.client.DialTimeout(network, addr, timeout)
-			if err != nil {
-				if reason := forwardDeniedReason(err, network); reason != "" {
-					warning("The dynamic forwarding [%v] was denied. %s", b, reason)
-				} else {
-					warning("dynamic forwarding [%v] dial [%s] [%s] failed: %v", b, network, addr, err)
+	// each connection has its own server, so that the destination can be recorded in the connection log
+	newServer := func(local net.Conn) (*socks5.Server, error) {
+		return socks5.New(&socks5.Config{
+			Resolver: &sshResolver{},
+			Dial: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				setForwardDest(local, addr)
+				conn, err := sshConn.client.DialTimeout(network, addr, timeout)
+				if err != nil {
+					if reason := forwardDeniedReason(err, network); reason != "" {
+						warning("The dynamic forwarding [%v] was denied. %s", b, reason)
+					} else {
+						warning("dynamic forwarding [%v] dial [%s] [%s] failed: %v", b, network, addr, err)
+					}
+					err = dialError
 				}
-				err = dialError
-			}
-			return conn, err
-		},
-		Logger: log.New(io.Discard, "", log.LstdFlags),
-	})
-	if err != nil {
+				return conn, err
+			},
+			Logger: log.New(io.Discard, "", log.LstdFlags),
+		})
+	}
+	if _, err := newServer(nil); err != nil {
 		warning("dynamic forwarding [%v] failed: %v", b, err)
 		return
 	}
 
 	name := fmt.Sprintf("dynamic forwarding [%v]", b)
-	for _, listener := range listenOnLocalTCP(gateway, b.addr, strconv.Itoa(b.port), name, unlinkUnix, bindMask) {
+	listeners := listenOnLocalTCP(gateway, b.addr, strconv.Itoa(b.port), name, unlinkUnix, bindMask)
+	if policy := getForwardPolicy(sshConn.param.args, forwardBindKey(b.addr, b.port), name); policy != nil {
+		listeners = policy.wrapListeners(listeners)
+	}
+	for _, listener := range listeners {
 		go func(listener net.Listener) {
 			defer func() { _ = listener.Close() }()
 			for {
@@ -226,6 +234,12 @@ func dynamicForward(sshConn *sshConnection, b *bindCfg, gateway bool, timeout ti
 					break
 				}
 				go func() {
+					server, err := newServer(conn)
+					if err != nil {
+						warning("dynamic forwarding [%v] failed: %v", b, err)
+						_ = conn.Close()
+						return
+					}
 					if err := server.ServeConn(conn); err != nil {
 						if !enableDebugLogging {
 							return
@@ -264,7 +278,11 @@ func localForwardTCP(sshConn *sshConnection, f *forwardCfg, gateway bool, timeou
 	}
 
 	name := fmt.Sprintf("local forwarding [%v]", f)
-	for _, listener := range listenOnLocalTCP(gateway, f.bindAddr, strconv.Itoa(f.bindPort), name, unlinkUnix, bindMask) {
+	listeners := listenOnLocalTCP(gateway, f.bindAddr, strconv.Itoa(f.bindPort), name, unlinkUnix, bindMask)
+	if policy := getForwardPolicy(sshConn.param.args, forwardBindKey(f.bindAddr, f.bindPort), name); policy != nil {
+		listeners = policy.wrapListeners(listeners)
+	}
+	for _, listener := range listeners {
 		go func(listener net.Listener) {
 			defer func() { _ = listener.Close() }()
 			for {
@@ -287,6 +305,7 @@ func localForwardTCP(sshConn *sshConnection, f *forwardCfg, gateway bool, timeou
 					_ = local.Close()
 					continue
 				}
+				setForwardDest(local, remoteAddr)
 				go tcpForward(sshConn.client, local, remote)
 			}
 		}(listener)
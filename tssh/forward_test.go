@@ -25,9 +25,18 @@ SOFTWARE.
 package tssh
 
 import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/trzsz/ssh_config"
 )
 
 func TestParseBindCfg(t *testing.T) {
@@ -320,3 +329,196 @@ func TestParseForwardArg_NormalUDP(t *testing.T) {
 	assertForwardCfg("UDP:/bind_socket:/forward_socket", "/bind_socket", -1, "/forward_socket", -1)
 	assertForwardCfg("UDP:/bind/socket:/forward/socket", "/bind/socket", -1, "/forward/socket", -1)
 }
+
+func TestParseListeningPorts(t *testing.T) {
+	assert := assert.New(t)
+
+	procNetTcp := `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 0100007F:0BB8 00000000:0000 0A 00000000:00000000 00:00000000 00000000  1000        0 12345 1
+   1: 00000000:1F90 00000000:0000 0A 00000000:00000000 00:00000000 00000000  1000        0 12346 1
+   2: 0500000A:2328 00000000:0000 0A 00000000:00000000 00:00000000 00000000  1000        0 12347 1
+   3: 0100007F:0BB8 0100007F:D431 01 00000000:00000000 00:00000000 00000000  1000        0 12348 1
+  sl  local_address                         remote_address                        st tx_queue rx_queue
+   0: 00000000000000000000000001000000:1435 00000000000000000000000000000000:0000 0A 00000000:00000000
+   1: 00000000000000000000000000000000:1F90 00000000000000000000000000000000:0000 0A 00000000:00000000
+   2: 0000000000000000FFFF00000100007F:1770 00000000000000000000000000000000:0000 0A 00000000:00000000
+`
+	assert.Equal(map[uint16]string{3000: "127.0.0.1", 8080: "127.0.0.1", 5173: "::1", 6000: "127.0.0.1"},
+		parseListeningPorts([]byte(procNetTcp)))
+
+	ssOutput := `LISTEN 0      4096       [::1]:4000        [::]:*
+LISTEN 0      511            *:5000           *:*
+LISTEN 0      4096 127.0.0.53%lo:53      0.0.0.0:*
+LISTEN 0      128    192.168.1.2:6000    0.0.0.0:*
+LISTEN 0      128           [::]:7000       [::]:*
+`
+	assert.Equal(map[uint16]string{4000: "::1", 5000: "127.0.0.1", 53: "127.0.0.1", 7000: "127.0.0.1"},
+		parseListeningPorts([]byte(ssOutput)))
+
+	assert.Equal(map[uint16]string{}, parseListeningPorts(nil))
+}
+
+func TestReadListeningPorts(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("while :; do "+kListeningPortsCommand+"; echo "+kListeningPortsEndMark+"; sleep 3 || break; done",
+		getPollListeningPortsCommand(3*time.Second))
+	assert.Contains(getPollListeningPortsCommand(0), "sleep 1 ")
+
+	var rounds []map[uint16]string
+	onPorts := func(ports map[uint16]string) { rounds = append(rounds, ports) }
+	output := "LISTEN 0 4096 127.0.0.1:3000 0.0.0.0:*\n" + kListeningPortsEndMark + "\n" +
+		"LISTEN 0 4096 127.0.0.1:3000 0.0.0.0:*\nLISTEN 0 128 [::]:7000 [::]:*\n" + kListeningPortsEndMark + "\n" +
+		"LISTEN 0 4096 127.0.0.1:4000 0.0.0.0:*\n"
+	err := readListeningPorts(strings.NewReader(output), onPorts)
+	assert.EqualError(err, "the polling command exited")
+	assert.Equal([]map[uint16]string{{3000: "127.0.0.1"}, {3000: "127.0.0.1", 7000: "127.0.0.1"}}, rounds)
+
+	rounds = nil
+	err = readListeningPorts(strings.NewReader(kListeningPortsEndMark+"\n"), onPorts)
+	assert.ErrorContains(err, "output nothing")
+	assert.Empty(rounds)
+}
+
+func TestAutoForwardUpdate(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	oriEnableWarning := enableWarningLogging
+	enableWarningLogging = false
+	defer func() { enableWarningLogging = oriEnableWarning }()
+
+	freePort := func() uint16 {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(err)
+		defer func() { _ = listener.Close() }()
+		return uint16(listener.Addr().(*net.TCPAddr).Port)
+	}
+	listenerPort := func(p *autoForwardedPort) uint16 {
+		return uint16(p.listener.Addr().(*net.TCPAddr).Port)
+	}
+
+	// the busy port is listening on the local machine, as the remote is the local machine
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(err)
+	defer func() { _ = busy.Close() }()
+	busyPort := uint16(busy.Addr().(*net.TCPAddr).Port)
+	freePort1, excluded := freePort(), freePort()
+
+	forwarder := &autoPortForwarder{
+		sshConn:  &sshConnection{},
+		excludes: map[uint16]bool{excluded: true},
+		ports:    make(map[uint16]*autoForwardedPort),
+	}
+	defer forwarder.closeAll()
+
+	forwarder.update(map[uint16]string{22: "127.0.0.1", excluded: "127.0.0.1", freePort1: "127.0.0.1", busyPort: "::1"})
+	require.Len(forwarder.ports, 2)
+	assert.True(forwarder.excludes[22])
+	assert.Equal(freePort1, listenerPort(forwarder.ports[freePort1]))
+	assert.Equal(net.JoinHostPort("::1", strconv.Itoa(int(busyPort))), forwarder.ports[busyPort].remoteAddr)
+	randomPort := listenerPort(forwarder.ports[busyPort])
+	assert.NotEqual(busyPort, randomPort)
+	assert.Equal([]string{
+		fmt.Sprintf("%d->%s", min(freePort1, busyPort), forwarder.ports[min(freePort1, busyPort)].localAddr),
+		fmt.Sprintf("%d->%s", max(freePort1, busyPort), forwarder.ports[max(freePort1, busyPort)].localAddr),
+	}, forwarder.listForwardedPorts())
+
+	// the listeners of the forwarder are not forwarded back
+	forwarder.update(map[uint16]string{freePort1: "127.0.0.1", busyPort: "127.0.0.1", randomPort: "127.0.0.1"})
+	assert.Len(forwarder.ports, 2)
+	assert.Nil(forwarder.ports[randomPort])
+
+	// the closed remote port is removed
+	closedListener := forwarder.ports[freePort1].listener
+	forwarder.update(map[uint16]string{busyPort: "127.0.0.1", randomPort: "127.0.0.1"})
+	assert.Len(forwarder.ports, 1)
+	assert.NotNil(forwarder.ports[busyPort])
+	_, err = closedListener.Accept()
+	assert.Error(err)
+
+	forwarder.closeAll()
+	assert.Empty(forwarder.listForwardedPorts())
+}
+
+func TestForwardPolicy(t *testing.T) {
+	assert := assert.New(t)
+	oriEnableWarning, oriUserConfig := enableWarningLogging, userConfig
+	enableWarningLogging, userConfig = false, &tsshConfig{}
+	defer func() { enableWarningLogging, userConfig = oriEnableWarning, oriUserConfig }()
+
+	config, err := ssh_config.DecodeBytes([]byte(`
+Host share
+    ForwardAllowFrom * 192.168.1.0/24 10.0.0.8
+    ForwardAllowFrom 8080 fd00::/8
+    ForwardDenyFrom 8080 192.168.1.100
+    ForwardMaxConnections 8080 2
+    ForwardMaxConnections * 10
+    ForwardAllowFrom 9090 invalid
+`))
+	require.NoError(t, err)
+	userConfig.exConfig = &sshConfig{"", config}
+
+	assert.Nil(getForwardPolicy(&sshArgs{Destination: "other"}, "8080", "test"))
+
+	args := &sshArgs{Destination: "share"}
+	policy := getForwardPolicy(args, "8080", "test")
+	require.NotNil(t, policy)
+	assert.Equal(int32(2), policy.maxConns)
+
+	tcpAddr := func(ip string) net.Addr {
+		return &net.TCPAddr{IP: net.ParseIP(ip), Port: 12345}
+	}
+	assert.Nil(policy.checkSource(tcpAddr("192.168.1.5")))
+	assert.Nil(policy.checkSource(tcpAddr("::ffff:192.168.1.6")))
+	assert.Nil(policy.checkSource(tcpAddr("10.0.0.8")))
+	assert.Nil(policy.checkSource(tcpAddr("fd12::1")))
+	assert.NotNil(policy.checkSource(tcpAddr("192.168.1.100")))
+	assert.NotNil(policy.checkSource(tcpAddr("10.0.0.9")))
+	assert.NotNil(policy.checkSource(tcpAddr("8.8.8.8")))
+	assert.Nil(policy.checkSource(&net.UnixAddr{Name: "@", Net: "unix"}))
+
+	assert.True(policy.acquire())
+	assert.True(policy.acquire())
+	assert.False(policy.acquire())
+	policy.release()
+	assert.True(policy.acquire())
+
+	policy = getForwardPolicy(args, "3000", "test")
+	require.NotNil(t, policy)
+	assert.Equal(int32(10), policy.maxConns)
+
+	// the count for the bind port takes precedence regardless of the order
+	require.NoError(t, args.Option.UnmarshalText([]byte("ForwardMaxConnections=* 5")))
+	assert.Equal(int32(2), getForwardPolicy(args, "8080", "test").maxConns)
+	assert.Equal(int32(5), getForwardPolicy(args, "3000", "test").maxConns)
+	assert.Nil(policy.checkSource(tcpAddr("192.168.1.100")))
+	assert.NotNil(policy.checkSource(tcpAddr("fd12::1")))
+}
+
+func TestForwardConnectionLog(t *testing.T) {
+	assert := assert.New(t)
+	logPath := filepath.Join(t.TempDir(), "forward.log")
+	policy := &forwardPolicy{name: "dynamic forwarding [1080]", logPath: logPath}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	listener = policy.wrapListeners([]net.Listener{listener})[0]
+	defer func() { _ = listener.Close() }()
+
+	client, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	defer func() { _ = client.Close() }()
+	conn, err := listener.Accept()
+	require.NoError(t, err)
+	setForwardDest(conn, "example.com:443")
+	_ = conn.Close()
+
+	content, err := os.ReadFile(logPath)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	require.Len(t, lines, 3)
+	source := client.LocalAddr().String()
+	assert.Contains(lines[0], " dynamic forwarding [1080] accepted "+source)
+	assert.Contains(lines[1], " dynamic forwarding [1080] connect "+source+" to example.com:443")
+	assert.Contains(lines[2], " dynamic forwarding [1080] closed "+source+" to example.com:443 duration ")
+}
@@ -0,0 +1,183 @@
+/*
+MIT License
+
+Copyright (c) 2023-2026 The Trzsz SSH Authors.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package tssh
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const kDefaultAlternativeRememberTime = time.Hour
+
+// getHostNameAlternativesCachePath returns the file which remembers the successful alternative of each host.
+var getHostNameAlternativesCachePath = func() string {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		cacheDir = filepath.Join(userHomeDir, ".cache")
+	}
+	return filepath.Join(cacheDir, "tssh", "host_alternatives")
+}
+
+// getHostNameAlternatives returns the address of HostName and the addresses of HostNameAlternatives in order.
+func getHostNameAlternatives(param *sshParam) []string {
+	addrs := []string{param.addr}
+	for _, value := range getAllExOptionConfig(param.args, "HostNameAlternatives", false) {
+		for _, alternative := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' }) {
+			host, port, err := net.SplitHostPort(alternative)
+			if err != nil {
+				host, port = strings.Trim(alternative, "[]"), param.port
+			}
+			if !isHostValid(host) {
+				warning("HostNameAlternatives [%s] contains invalid characters", alternative)
+				continue
+			}
+			if addr := joinHostPort(host, port); !slices.Contains(addrs, addr) {
+				addrs = append(addrs, addr)
+			}
+		}
+	}
+	return addrs
+}
+
+func getAlternativeRememberTime(args *sshArgs) time.Duration {
+	remember := getExOptionConfig(args, "HostNameAlternativesRemember")
+	if remember == "" {
+		return kDefaultAlternativeRememberTime
+	}
+	if strings.EqualFold(remember, "no") {
+		return 0
+	}
+	seconds, err := convertSshTime(remember)
+	if err != nil {
+		warning("HostNameAlternativesRemember [%s] is invalid: %v", remember, err)
+		return kDefaultAlternativeRememberTime
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// loadRememberedAlternative returns the successful alternative of the host, if it's remembered within the time.
+func loadRememberedAlternative(host string, remember time.Duration) string {
+	content, err := os.ReadFile(getHostNameAlternativesCachePath())
+	if err != nil {
+		return ""
+	}
+	for line := range strings.SplitSeq(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 || fields[0] != host {
+			continue
+		}
+		timestamp, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil || time.Since(time.Unix(timestamp, 0)) > remember {
+			return ""
+		}
+		return fields[1]
+	}
+	return ""
+}
+
+// rememberAlternative saves the successful alternative of the host, replacing the old one.
+func rememberAlternative(host, addr string) {
+	path := getHostNameAlternativesCachePath()
+	var buf bytes.Buffer
+	if content, err := os.ReadFile(path); err == nil {
+		for line := range strings.SplitSeq(string(content), "\n") {
+			if fields := strings.Fields(line); len(fields) == 3 && fields[0] != host {
+				buf.WriteString(line + "\n")
+			}
+		}
+	}
+	fmt.Fprintf(&buf, "%s %s %d\n", host, addr, time.Now().Unix())
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		debug("mkdir for [%s] failed: %v", path, err)
+		return
+	}
+	// write to a temp file and rename it, so the other tssh processes never read a partial file
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, buf.Bytes(), 0600); err != nil {
+		debug("remember alternative [%s] for [%s] failed: %v", addr, host, err)
+		return
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		_ = os.Remove(tmpPath)
+		debug("remember alternative [%s] for [%s] failed: %v", addr, host, err)
+	}
+}
+
+// dialHostNameAlternatives dials the HostName and the HostNameAlternatives in turn, or races them,
+// starting with the remembered successful one. The address of HostName is still used for the known_hosts,
+// so the host keys match regardless of which alternative is connected.
+func dialHostNameAlternatives(param *sshParam, network string,
+	dial func(ctx context.Context, network, addr string) (net.Conn, error)) (net.Conn, error) {
+	addrs := getHostNameAlternatives(param)
+	if len(addrs) == 1 {
+		return dial(context.Background(), network, param.addr)
+	}
+
+	// the resolved address of HostName, which is the same for the different forms of the destination
+	host := param.addr
+	remember := getAlternativeRememberTime(param.args)
+	var remembered string
+	if remember > 0 {
+		remembered = loadRememberedAlternative(host, remember)
+		if remembered != "" && slices.Contains(addrs, remembered) {
+			debug("try the remembered alternative [%s] first", remembered)
+			addrs = append([]string{remembered}, slices.DeleteFunc(addrs, func(addr string) bool { return addr == remembered })...)
+		}
+	}
+
+	var conn net.Conn
+	var connected string
+	var err error
+	if strings.EqualFold(getExOptionConfig(param.args, "HostNameAlternativesMode"), "race") {
+		debug("race the alternatives: %v", addrs)
+		conn, connected, err = raceDial(context.Background(), network, addrs, dial)
+	} else {
+		for _, addr := range addrs {
+			debug("try the alternative [%s]", addr)
+			if conn, err = dial(context.Background(), network, addr); err == nil {
+				connected = addr
+				break
+			}
+			debug("connect to the alternative [%s] failed: %v", addr, err)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	debug("connected to the alternative [%s]", connected)
+	if remember > 0 && connected != remembered {
+		rememberAlternative(host, connected)
+	}
+	return conn, nil
+}
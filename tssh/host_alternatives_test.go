@@ -0,0 +1,157 @@
+/*
+MIT License
+
+Copyright (c) 2023-2026 The Trzsz SSH Authors.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package tssh
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHostNameAlternatives(t *testing.T) {
+	assert := assert.New(t)
+	cachePath := filepath.Join(t.TempDir(), "host_alternatives")
+	oriUserConfig, oriEnableWarning, oriGetCachePath := userConfig, enableWarningLogging, getHostNameAlternativesCachePath
+	userConfig, enableWarningLogging = &tsshConfig{}, false
+	getHostNameAlternativesCachePath = func() string { return cachePath }
+	defer func() {
+		userConfig, enableWarningLogging, getHostNameAlternativesCachePath = oriUserConfig, oriEnableWarning, oriGetCachePath
+	}()
+
+	newParam := func(addr string, options ...string) *sshParam {
+		args := &sshArgs{Destination: "alias"}
+		for _, option := range options {
+			require.NoError(t, args.Option.UnmarshalText([]byte(option)))
+		}
+		return &sshParam{args: args, port: "22", addr: addr}
+	}
+
+	assert.Equal([]string{"10.0.0.5:22"}, getHostNameAlternatives(newParam("10.0.0.5:22")))
+	assert.Equal([]string{"10.0.0.5:22", "203.0.113.5:2222", "[2001:db8::1]:22", "[2001:db8::2]:2222", "backup:22"},
+		getHostNameAlternatives(newParam("10.0.0.5:22",
+			"HostNameAlternatives=10.0.0.5:22 203.0.113.5:2222,2001:db8::1 [2001:db8::2]:2222 backup")))
+
+	assert.Equal(time.Hour, getAlternativeRememberTime(newParam("").args))
+	assert.Equal(10*time.Minute, getAlternativeRememberTime(newParam("", "HostNameAlternativesRemember=10m").args))
+	assert.Equal(time.Duration(0), getAlternativeRememberTime(newParam("", "HostNameAlternativesRemember=no").args))
+	assert.Equal(time.Duration(0), getAlternativeRememberTime(newParam("", "HostNameAlternativesRemember=0").args))
+
+	// the unreachable addresses are skipped in turn
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer func() { _ = listener.Close() }()
+	var dialed []string
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		dialed = append(dialed, addr)
+		if addr != listener.Addr().String() {
+			return nil, fmt.Errorf("connection refused")
+		}
+		var dialer net.Dialer
+		return dialer.DialContext(ctx, network, addr)
+	}
+	param := newParam("192.0.2.1:22", "HostNameAlternatives=192.0.2.2:22 "+listener.Addr().String())
+	conn, err := dialHostNameAlternatives(param, "tcp", dial)
+	require.NoError(t, err)
+	_ = conn.Close()
+	assert.Equal([]string{"192.0.2.1:22", "192.0.2.2:22", listener.Addr().String()}, dialed)
+	assert.Equal("192.0.2.1:22", param.addr)
+	assert.Equal(listener.Addr().String(), loadRememberedAlternative("192.0.2.1:22", time.Hour))
+	assert.Equal("", loadRememberedAlternative("alias", time.Hour))
+	assert.Equal("", loadRememberedAlternative("other", time.Hour))
+
+	// the remembered address is tried first
+	dialed = nil
+	conn, err = dialHostNameAlternatives(param, "tcp", dial)
+	require.NoError(t, err)
+	_ = conn.Close()
+	assert.Equal([]string{listener.Addr().String()}, dialed)
+
+	// the remembered address is shared by the different forms of the destination
+	dialed = nil
+	param.args.Destination = "user@alias:22"
+	conn, err = dialHostNameAlternatives(param, "tcp", dial)
+	require.NoError(t, err)
+	_ = conn.Close()
+	assert.Equal([]string{listener.Addr().String()}, dialed)
+	entries, err := os.ReadDir(filepath.Dir(cachePath))
+	require.NoError(t, err)
+	assert.Len(entries, 1)
+
+	// the expired address is not used
+	require.NoError(t, os.WriteFile(cachePath, []byte(fmt.Sprintf("other 192.0.2.3:22 %d\n192.0.2.1:22 %s %d\n",
+		time.Now().Unix(), listener.Addr().String(), time.Now().Add(-2*time.Hour).Unix())), 0600))
+	assert.Equal("", loadRememberedAlternative("192.0.2.1:22", time.Hour))
+	dialed = nil
+	conn, err = dialHostNameAlternatives(param, "tcp", dial)
+	require.NoError(t, err)
+	_ = conn.Close()
+	assert.Equal([]string{"192.0.2.1:22", "192.0.2.2:22", listener.Addr().String()}, dialed)
+	assert.Equal("192.0.2.3:22", loadRememberedAlternative("other", time.Hour))
+
+	// the alternatives are raced
+	require.NoError(t, os.Remove(cachePath))
+	param = newParam("192.0.2.1:22", "HostNameAlternatives=192.0.2.2:22 "+listener.Addr().String(),
+		"HostNameAlternativesMode=race", "HostNameAlternativesRemember=no")
+	conn, err = dialHostNameAlternatives(param, "tcp", func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if addr != listener.Addr().String() {
+			return nil, fmt.Errorf("connection refused")
+		}
+		var dialer net.Dialer
+		return dialer.DialContext(ctx, network, addr)
+	})
+	require.NoError(t, err)
+	_ = conn.Close()
+	assert.NoFileExists(cachePath)
+
+	// all the alternatives fail
+	_ = listener.Close()
+	_, err = dialHostNameAlternatives(param, "tcp", dial)
+	assert.Error(err)
+}
+
+func TestHostKeyAddr(t *testing.T) {
+	assert := assert.New(t)
+	oriUserConfig, oriEnableWarning := userConfig, enableWarningLogging
+	userConfig, enableWarningLogging = &tsshConfig{}, false
+	defer func() { userConfig, enableWarningLogging = oriUserConfig, oriEnableWarning }()
+
+	newParam := func(options ...string) *sshParam {
+		args := &sshArgs{Destination: "alias"}
+		for _, option := range options {
+			require.NoError(t, args.Option.UnmarshalText([]byte(option)))
+		}
+		return &sshParam{args: args, addr: "10.0.0.5:2222"}
+	}
+	assert.Equal("10.0.0.5:2222", newParam().hostKeyAddr())
+	assert.Equal("server:22", newParam("HostKeyAlias=server").hostKeyAddr())
+	assert.Equal("10.0.0.5:2222", newParam("HostKeyAlias=server;id").hostKeyAddr())
+}
@@ -27,6 +27,7 @@ package tssh
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"crypto"
 	"crypto/aes"
 	"crypto/cipher"
@@ -40,6 +41,7 @@ import (
 	"os/exec"
 	"os/signal"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -53,6 +55,8 @@ type skSigner struct {
 	pubKey ssh.PublicKey
 	keyBuf []byte
 	flags  uint8
+	// provider is the SecurityKeyProvider, empty means $SSH_SK_PROVIDER or internal.
+	provider string
 }
 
 func (s *skSigner) PublicKey() ssh.PublicKey {
@@ -105,32 +109,88 @@ func readMessage(r io.Reader) ([]byte, error) {
 	return buf, nil
 }
 
-func (s *skSigner) Sign(rand io.Reader, data []byte) (*ssh.Signature, error) {
-	return s.SignWithAlgorithm(rand, data, "")
+// skProvider signs data with a FIDO authenticator for a security key.
+type skProvider interface {
+	sign(ctx context.Context, s *skSigner, data []byte, algorithm, pin string) (*ssh.Signature, error)
 }
 
-func (s *skSigner) SignWithAlgorithm(rand io.Reader, data []byte, algorithm string) (*ssh.Signature, error) {
-	logLevel := uint32(2) // SYSLOG_LEVEL_ERROR = 2
-	if enableDebugLogging {
-		logLevel = uint32(7) // SYSLOG_LEVEL_DEBUG3 = 7
+// errSkWrongPin is returned by skProvider when the PIN is required or incorrect.
+var errSkWrongPin = errors.New("PIN required or incorrect")
+
+var skProviders = map[string]skProvider{}
+var skProvidersMutex sync.Mutex
+
+func registerSkProvider(name string, provider skProvider) {
+	skProvidersMutex.Lock()
+	defer skProvidersMutex.Unlock()
+	skProviders[name] = provider
+}
+
+func getSkProvider(name string) skProvider {
+	skProvidersMutex.Lock()
+	defer skProvidersMutex.Unlock()
+	if provider, ok := skProviders[name]; ok {
+		return provider
 	}
-	skProvider := os.Getenv("SSH_SK_PROVIDER")
-	if skProvider == "" {
-		skProvider = "internal"
+	return &skHelperProvider{name}
+}
+
+func getSecurityKeyProvider(args *sshArgs) string {
+	if provider := getOptionConfig(args, "SecurityKeyProvider"); provider != "" {
+		if provider == "internal" {
+			return provider
+		}
+		return resolveHomeDir(provider)
 	}
+	return getDefaultSecurityKeyProvider()
+}
 
-	skHelperPath := os.Getenv("SSH_SK_HELPER")
-	if skHelperPath == "" {
-		skHelperPath = kDefaultSshSkHelperPath
+func getDefaultSecurityKeyProvider() string {
+	if provider := os.Getenv("SSH_SK_PROVIDER"); provider != "" {
+		return provider
 	}
-	if !isFileExist(skHelperPath) {
-		return nil, fmt.Errorf("ssh-sk-helper not found: %s", skHelperPath)
+	return "internal"
+}
+
+// skPinCache caches the PIN of each security key, keyed by getSkPinCacheKey,
+// since the keys of the same provider could be on different authenticators.
+var skPinCache = make(map[string]string)
+var skPinCacheMutex sync.Mutex
+
+func getSkPinCacheKey(provider string, pubKey ssh.PublicKey) string {
+	return provider + "\n" + ssh.FingerprintSHA256(pubKey)
+}
+
+func getCachedSkPin(cacheKey string) string {
+	skPinCacheMutex.Lock()
+	defer skPinCacheMutex.Unlock()
+	return skPinCache[cacheKey]
+}
+
+func setCachedSkPin(cacheKey, pin string) {
+	skPinCacheMutex.Lock()
+	defer skPinCacheMutex.Unlock()
+	if pin == "" {
+		delete(skPinCache, cacheKey)
+	} else {
+		skPinCache[cacheKey] = pin
 	}
+}
 
-	for i := range 4 {
-		debug("starting ssh-sk-helper: %s", skHelperPath)
+func (s *skSigner) Sign(rand io.Reader, data []byte) (*ssh.Signature, error) {
+	return s.SignWithAlgorithm(rand, data, "")
+}
+
+func (s *skSigner) SignWithAlgorithm(rand io.Reader, data []byte, algorithm string) (*ssh.Signature, error) {
+	providerName := s.provider
+	if providerName == "" {
+		providerName = getDefaultSecurityKeyProvider()
+	}
+	provider := getSkProvider(providerName)
 
-		pin := ""
+	cacheKey := getSkPinCacheKey(providerName, s.pubKey)
+	pin := getCachedSkPin(cacheKey)
+	for i := range 4 {
 		if i > 0 {
 			secret, err := readSecret(fmt.Sprintf("Enter PIN for %s key %s: ", shortKeyType(s.pubKey.Type()), s.path))
 			if err != nil {
@@ -139,104 +199,142 @@ func (s *skSigner) SignWithAlgorithm(rand io.Reader, data []byte, algorithm stri
 			pin = string(secret)
 		}
 
-		req := skSignRequest{
-			Version:   5, // SSH_SK_HELPER_VERSION = 5
-			ReqType:   1, // SSH_SK_HELPER_SIGN = 1
-			LogStderr: 1, // on_stderr != 0
-			LogLevel:  logLevel,
-			Key:       s.keyBuf,
-			Provider:  skProvider,
-			Algorithm: algorithm,
-			Data:      data,
-			Pin:       pin,
+		var sign *ssh.Signature
+		var err error
+		if s.flags&1 != 0 { // SSH_SK_USER_PRESENCE_REQD = 1
+			err = s.runWithPrompt(func(ctx context.Context) error {
+				signature, signErr := provider.sign(ctx, s, data, algorithm, pin)
+				sign = signature
+				return signErr
+			})
+		} else {
+			sign, err = provider.sign(context.Background(), s, data, algorithm, pin)
 		}
-
-		cmd := exec.Command(skHelperPath)
-		if enableDebugLogging {
-			cmd.Args = append(cmd.Args, "-vvv")
+		if err == errSkWrongPin {
+			setCachedSkPin(cacheKey, "")
+			continue
+		}
+		if err != nil {
+			return nil, err
 		}
+		setCachedSkPin(cacheKey, pin)
+		return sign, nil
+	}
 
-		var stdin bytes.Buffer
-		var stdout bytes.Buffer
-		cmd.Stdin = &stdin
-		cmd.Stdout = &stdout
+	return nil, fmt.Errorf("PIN incorrect")
+}
 
-		if enableDebugLogging {
-			stderr, err := cmd.StderrPipe()
-			if err != nil {
-				return nil, fmt.Errorf("stderr pipe failed: %v", err)
-			}
-			go func() {
-				defer func() { _ = stderr.Close() }()
-				scanner := bufio.NewScanner(stderr)
-				for scanner.Scan() {
-					debug("%s", scanner.Text())
-				}
-			}()
-		} else {
-			cmd.Stderr = os.Stderr
-		}
+// skHelperProvider signs by speaking the ssh-sk-helper protocol,
+// with the name passed to the helper as the middleware library.
+type skHelperProvider struct {
+	name string
+}
 
-		if err := writeMessage(&stdin, ssh.Marshal(req)); err != nil {
-			return nil, fmt.Errorf("write request failed: %v", err)
-		}
+func (p *skHelperProvider) sign(ctx context.Context, s *skSigner, data []byte, algorithm, pin string) (*ssh.Signature, error) {
+	logLevel := uint32(2) // SYSLOG_LEVEL_ERROR = 2
+	if enableDebugLogging {
+		logLevel = uint32(7) // SYSLOG_LEVEL_DEBUG3 = 7
+	}
 
-		if s.flags&1 != 0 { // SSH_SK_USER_PRESENCE_REQD = 1
-			if err := s.runWithPrompt(cmd); err != nil {
-				return nil, err
-			}
-		} else {
-			if err := cmd.Run(); err != nil {
-				return nil, fmt.Errorf("run %s failed: %v", skHelperPath, err)
-			}
-		}
+	skHelperPath := os.Getenv("SSH_SK_HELPER")
+	if skHelperPath == "" {
+		skHelperPath = kDefaultSshSkHelperPath
+	}
+	if !isFileExist(skHelperPath) {
+		return nil, fmt.Errorf("ssh-sk-helper not found: %s", skHelperPath)
+	}
+
+	debug("starting ssh-sk-helper: %s", skHelperPath)
+
+	req := skSignRequest{
+		Version:   5, // SSH_SK_HELPER_VERSION = 5
+		ReqType:   1, // SSH_SK_HELPER_SIGN = 1
+		LogStderr: 1, // on_stderr != 0
+		LogLevel:  logLevel,
+		Key:       s.keyBuf,
+		Provider:  p.name,
+		Algorithm: algorithm,
+		Data:      data,
+		Pin:       pin,
+	}
+
+	cmd := exec.CommandContext(ctx, skHelperPath)
+	if enableDebugLogging {
+		cmd.Args = append(cmd.Args, "-vvv")
+	}
+
+	var stdin bytes.Buffer
+	var stdout bytes.Buffer
+	cmd.Stdin = &stdin
+	cmd.Stdout = &stdout
 
-		respPayload, err := readMessage(&stdout)
+	if enableDebugLogging {
+		stderr, err := cmd.StderrPipe()
 		if err != nil {
-			return nil, fmt.Errorf("read response failed: %v", err)
+			return nil, fmt.Errorf("stderr pipe failed: %v", err)
 		}
+		go func() {
+			defer func() { _ = stderr.Close() }()
+			scanner := bufio.NewScanner(stderr)
+			for scanner.Scan() {
+				debug("%s", scanner.Text())
+			}
+		}()
+	} else {
+		cmd.Stderr = os.Stderr
+	}
+
+	if err := writeMessage(&stdin, ssh.Marshal(req)); err != nil {
+		return nil, fmt.Errorf("write request failed: %v", err)
+	}
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("run %s failed: %v", skHelperPath, err)
+	}
+
+	respPayload, err := readMessage(&stdout)
+	if err != nil {
+		return nil, fmt.Errorf("read response failed: %v", err)
+	}
 
-		var resp skSignResponse
-		if err := ssh.Unmarshal(respPayload, &resp); err != nil {
-			return nil, fmt.Errorf("unmarshal response failed: %v", err)
+	var resp skSignResponse
+	if err := ssh.Unmarshal(respPayload, &resp); err != nil {
+		return nil, fmt.Errorf("unmarshal response failed: %v", err)
+	}
+	if resp.Version != 5 { // SSH_SK_HELPER_VERSION = 5
+		return nil, fmt.Errorf("unexpected ssh-sk-helper version: %d", resp.Version)
+	}
+	switch resp.RespType {
+	case 0: // SSH_SK_HELPER_ERROR
+		code := binary.BigEndian.Uint32(resp.Rest)
+		if code == 60 { // SSH_ERR_DEVICE_NOT_FOUND = -60
+			return nil, fmt.Errorf("device not found")
 		}
-		if resp.Version != 5 { // SSH_SK_HELPER_VERSION = 5
-			return nil, fmt.Errorf("unexpected ssh-sk-helper version: %d", resp.Version)
+		if code == 43 { // SSH_ERR_KEY_WRONG_PASSPHRASE = -43
+			return nil, errSkWrongPin
 		}
-		switch resp.RespType {
-		case 0: // SSH_SK_HELPER_ERROR
-			code := binary.BigEndian.Uint32(resp.Rest)
-			if code == 60 { // SSH_ERR_DEVICE_NOT_FOUND = -60
-				return nil, fmt.Errorf("device not found")
-			}
-			if code == 43 { // SSH_ERR_KEY_WRONG_PASSPHRASE = -43
-				continue
-			}
-			return nil, fmt.Errorf("ssh-sk-helper error with code: %d", code)
-		case 1: // SSH_SK_HELPER_SIGN = 1
-			var skSign skSignSignature
-			if err := ssh.Unmarshal(resp.Rest, &skSign); err != nil {
-				return nil, fmt.Errorf("unmarshal sk_signature failed: %v", err)
-			}
-			var sign ssh.Signature
-			if err := ssh.Unmarshal(skSign.Signature, &sign); err != nil {
-				return nil, fmt.Errorf("unmarshal signature failed: %v", err)
-			}
-			return &sign, nil
-		default:
-			return nil, fmt.Errorf("unexpected ssh-sk-helper response type: %d", resp.RespType)
+		return nil, fmt.Errorf("ssh-sk-helper error with code: %d", code)
+	case 1: // SSH_SK_HELPER_SIGN = 1
+		var skSign skSignSignature
+		if err := ssh.Unmarshal(resp.Rest, &skSign); err != nil {
+			return nil, fmt.Errorf("unmarshal sk_signature failed: %v", err)
+		}
+		var sign ssh.Signature
+		if err := ssh.Unmarshal(skSign.Signature, &sign); err != nil {
+			return nil, fmt.Errorf("unmarshal signature failed: %v", err)
 		}
+		return &sign, nil
+	default:
+		return nil, fmt.Errorf("unexpected ssh-sk-helper response type: %d", resp.RespType)
 	}
-
-	return nil, fmt.Errorf("PIN incorrect")
 }
 
-func (s *skSigner) runWithPrompt(cmd *exec.Cmd) error {
-	if err := cmd.Start(); err != nil {
-		return fmt.Errorf("start %s failed: %v", cmd.Path, err)
-	}
+func (s *skSigner) runWithPrompt(sign func(ctx context.Context) error) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
-	fmt.Fprintf(os.Stderr, "%s%s", ansi.HideCursor, ansi.ResetModeAutoWrap)
+	// ring the bell once, so that the terminal can notify the user to touch the key.
+	fmt.Fprintf(os.Stderr, "\a%s%s", ansi.HideCursor, ansi.ResetModeAutoWrap)
 	defer fmt.Fprintf(os.Stderr, "\r%s%s%s", ansi.EraseLineRight, ansi.SetModeAutoWrap, ansi.ShowCursor)
 
 	sigChan := make(chan os.Signal, 1)
@@ -246,11 +344,7 @@ func (s *skSigner) runWithPrompt(cmd *exec.Cmd) error {
 	doneChan := make(chan error, 1)
 	go func() {
 		defer close(doneChan)
-		if err := cmd.Wait(); err != nil {
-			doneChan <- fmt.Errorf("wait %s failed: %v", cmd.Path, err)
-			return
-		}
-		doneChan <- nil
+		doneChan <- sign(ctx)
 	}()
 
 	ticker := time.NewTicker(500 * time.Millisecond)
@@ -265,7 +359,7 @@ func (s *skSigner) runWithPrompt(cmd *exec.Cmd) error {
 	for {
 		select {
 		case sig := <-sigChan:
-			_ = cmd.Process.Kill()
+			cancel()
 			if sig == os.Interrupt || sig.String() == "interrupt" {
 				return fmt.Errorf("interrupted by user")
 			}
@@ -25,8 +25,15 @@ SOFTWARE.
 package tssh
 
 import (
+	"context"
+	"crypto/ed25519"
 	"crypto/rand"
+	"crypto/sha256"
 	"crypto/x509"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
 	"testing"
 
 	"golang.org/x/crypto/ssh"
@@ -222,3 +229,132 @@ func TestParsePrivateKeyWithPassphrase_SKKey(t *testing.T) {
 		})
 	}
 }
+
+// softSkProvider is a software FIDO authenticator for sk-ssh-ed25519 keys.
+type softSkProvider struct {
+	key     ed25519.PrivateKey
+	pin     string
+	counter uint32
+}
+
+func (p *softSkProvider) sign(ctx context.Context, s *skSigner, data []byte, algorithm, pin string) (*ssh.Signature, error) {
+	if pin != p.pin {
+		return nil, errSkWrongPin
+	}
+	p.counter++
+	appDigest := sha256.Sum256([]byte("ssh:"))
+	dataDigest := sha256.Sum256(data)
+	flags := ssh.Marshal(struct {
+		Flags   byte
+		Counter uint32
+	}{s.flags, p.counter})
+	signed := append(append(appDigest[:], flags...), dataDigest[:]...)
+	return &ssh.Signature{Format: ssh.KeyAlgoSKED25519, Blob: ed25519.Sign(p.key, signed), Rest: flags}, nil
+}
+
+func TestSecurityKeyProvider(t *testing.T) {
+	oriUserConfig := userConfig
+	userConfig = &tsshConfig{}
+	defer func() { userConfig = oriUserConfig }()
+
+	t.Setenv("SSH_SK_PROVIDER", "")
+	if provider := getSecurityKeyProvider(&sshArgs{}); provider != "internal" {
+		t.Fatalf("unexpected default provider: %s", provider)
+	}
+	t.Setenv("SSH_SK_PROVIDER", "/usr/lib/libsk-env.so")
+	if provider := getSecurityKeyProvider(&sshArgs{}); provider != "/usr/lib/libsk-env.so" {
+		t.Fatalf("unexpected env provider: %s", provider)
+	}
+	args := &sshArgs{}
+	if err := args.Option.UnmarshalText([]byte("SecurityKeyProvider=/usr/lib/libsk-host.so")); err != nil {
+		t.Fatalf("set option failed: %v", err)
+	}
+	if provider := getSecurityKeyProvider(args); provider != "/usr/lib/libsk-host.so" {
+		t.Fatalf("unexpected host provider: %s", provider)
+	}
+	if provider, ok := getSkProvider("/usr/lib/libsk-host.so").(*skHelperProvider); !ok || provider.name != "/usr/lib/libsk-host.so" {
+		t.Fatalf("unexpected sk provider: %#v", provider)
+	}
+}
+
+func TestSoftwareSecurityKey(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("askpass script is not supported on windows")
+	}
+
+	dir := t.TempDir()
+	countFile := filepath.Join(dir, "count")
+	askpass := filepath.Join(dir, "askpass")
+	if err := os.WriteFile(askpass, []byte("#!/bin/sh\necho x >> "+countFile+"\necho 1234\n"), 0700); err != nil {
+		t.Fatalf("write askpass failed: %v", err)
+	}
+	t.Setenv("SSH_ASKPASS", askpass)
+	t.Setenv("SSH_ASKPASS_REQUIRE", "force")
+
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key failed: %v", err)
+	}
+	pubKey, err := ssh.ParsePublicKey(ssh.Marshal(struct {
+		Type        string
+		PublicKey   []byte
+		Application string
+	}{ssh.KeyAlgoSKED25519, pub, "ssh:"}))
+	if err != nil {
+		t.Fatalf("parse public key failed: %v", err)
+	}
+
+	provider := &softSkProvider{key: key, pin: "1234"}
+	registerSkProvider("test-soft-fido", provider)
+	defer setCachedSkPin(getSkPinCacheKey("test-soft-fido", pubKey), "")
+	signer := &skSigner{path: "soft", pubKey: pubKey, flags: 1, provider: "test-soft-fido"}
+
+	data := []byte("session data")
+	for range 2 {
+		signature, err := signer.Sign(rand.Reader, data)
+		if err != nil {
+			t.Fatalf("sign failed: %v", err)
+		}
+		if err := pubKey.Verify(data, signature); err != nil {
+			t.Fatalf("verify failed: %v", err)
+		}
+	}
+
+	// the PIN is asked once, and cached for the session.
+	count, err := os.ReadFile(countFile)
+	if err != nil {
+		t.Fatalf("read count failed: %v", err)
+	}
+	if n := strings.Count(string(count), "x"); n != 1 {
+		t.Fatalf("unexpected askpass count: %d", n)
+	}
+
+	// the PIN is cached for each key, not for the provider.
+	if getCachedSkPin(getSkPinCacheKey("test-soft-fido", pubKey)) != "1234" {
+		t.Fatalf("the PIN of the key is not cached")
+	}
+	otherPub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key failed: %v", err)
+	}
+	otherKey, err := ssh.ParsePublicKey(ssh.Marshal(struct {
+		Type        string
+		PublicKey   []byte
+		Application string
+	}{ssh.KeyAlgoSKED25519, otherPub, "ssh:"}))
+	if err != nil {
+		t.Fatalf("parse public key failed: %v", err)
+	}
+	if getCachedSkPin(getSkPinCacheKey("test-soft-fido", otherKey)) != "" {
+		t.Fatalf("the PIN of another key is cached")
+	}
+
+	// the cached PIN is removed once it is rejected.
+	provider.pin = "5678"
+	if _, err := signer.Sign(rand.Reader, data); err == nil {
+		t.Fatalf("sign with the wrong PIN succeeded")
+	}
+	if pin := getCachedSkPin(getSkPinCacheKey("test-soft-fido", pubKey)); pin != "" {
+		t.Fatalf("the rejected PIN is still cached: %s", pin)
+	}
+}
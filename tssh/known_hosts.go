@@ -208,7 +208,8 @@ func ensureNewline(file *os.File) error {
 	return nil
 }
 
-func writeKnownHost(args *sshArgs, path, host string, key ssh.PublicKey) error {
+// writeKnownHost appends the key of the host, and of the IP address if it's not empty, to the known_hosts file.
+func writeKnownHost(args *sshArgs, path, host, ip string, key ssh.PublicKey) error {
 	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0600)
 	if err != nil {
 		return err
@@ -219,21 +220,29 @@ func writeKnownHost(args *sshArgs, path, host string, key ssh.PublicKey) error {
 		return err
 	}
 
-	hostNormalized := knownhosts.Normalize(host)
-	if strings.ContainsAny(hostNormalized, "\t ") {
-		return fmt.Errorf("host '%s' contains spaces", hostNormalized)
+	addresses := []string{knownhosts.Normalize(host)}
+	if ip != "" {
+		addresses = append(addresses, knownhosts.Normalize(ip))
 	}
-
-	address := hostNormalized
-	if strings.EqualFold(getOptionConfig(args, "HashKnownHosts"), "yes") {
-		address = xkh.HashHostname(hostNormalized)
+	for _, address := range addresses {
+		if strings.ContainsAny(address, "\t ") {
+			return fmt.Errorf("host '%s' contains spaces", address)
+		}
 	}
 
-	line := knownhosts.Line([]string{address}, key) + "\n"
+	// the host and the ip are written in separate lines, so that UpdateHostKeys can rotate them separately
+	hashKnownHosts := strings.EqualFold(getOptionConfig(args, "HashKnownHosts"), "yes")
+	var line string
+	for _, address := range addresses {
+		if hashKnownHosts {
+			address = xkh.HashHostname(address)
+		}
+		line += knownhosts.Line([]string{address}, key) + "\n"
+	}
 	return writeAll(file, []byte(line))
 }
 
-func addHostKey(args *sshArgs, path, host string, key ssh.PublicKey, ask bool, dnsHint string) error {
+func addHostKey(args *sshArgs, path, host, ip string, key ssh.PublicKey, ask bool, dnsHint string) error {
 	addHostKeyMutex.Lock()
 	defer addHostKeyMutex.Unlock()
 
@@ -243,14 +252,30 @@ func addHostKey(args *sshArgs, path, host string, key ssh.PublicKey, ask bool, d
 	}
 
 	// writing only during the login process with the user's permission
+	if ask && batchMode.Load() {
+		return fmt.Errorf("host key for '%s' is not known and cannot be confirmed in batch mode", host)
+	}
 	if ask {
 		fingerprint := ssh.FingerprintSHA256(key)
-		fmt.Fprintf(os.Stderr, "The authenticity of host '%s' can't be established.\r\n"+
+		message := fmt.Sprintf("The authenticity of host '%s' can't be established.\r\n"+
 			"%s key fingerprint is %s.\r\n", host, shortKeyType(key.Type()), fingerprint)
 		if dnsHint != "" {
-			fmt.Fprintf(os.Stderr, "%s\r\n", dnsHint)
+			message += dnsHint + "\r\n"
 		}
 
+		if askpass := getAskpassProgram(); askpass != "" {
+			answer, err := runAskpass(askpass, message+"Are you sure you want to continue connecting (yes/no/[fingerprint])?", false)
+			if err != nil {
+				return err
+			}
+			input := strings.TrimSpace(string(answer))
+			if input != fingerprint && !strings.EqualFold(input, "yes") {
+				return fmt.Errorf("host key not trusted")
+			}
+			return writeKnownHostAndWarn(args, path, host, ip, key)
+		}
+		_, _ = os.Stderr.WriteString(message)
+
 		stdin, closer, err := getKeyboardInput()
 		if err != nil {
 			return err
@@ -277,59 +302,87 @@ func addHostKey(args *sshArgs, path, host string, key ssh.PublicKey, ask bool, d
 		}
 	}
 
-	if err := writeKnownHost(args, path, host, key); err != nil {
+	return writeKnownHostAndWarn(args, path, host, ip, key)
+}
+
+func writeKnownHostAndWarn(args *sshArgs, path, host, ip string, key ssh.PublicKey) error {
+	if err := writeKnownHost(args, path, host, ip, key); err != nil {
 		warning("Failed to add the host to the list of known hosts (%s): %v", path, err)
 		return nil
 	}
 
+	if ip != "" {
+		host = knownhosts.Normalize(host) + "," + knownhosts.Normalize(ip)
+	}
 	warning("Permanently added '%s' (%s) to the list of known hosts.", host, shortKeyType(key.Type()))
 	return nil
 }
 
-func getHostKeyCallback(param *sshParam) (ssh.HostKeyCallback, []string, error) {
+// getKnownHostsFiles returns the readable known_hosts files configured by the key, or the default files.
+func getKnownHostsFiles(param *sshParam, key string, user bool, defaults []string) ([]string, error) {
+	knownHostsFiles := getOptionConfigSplits(param.args, key)
+	if len(knownHostsFiles) == 0 {
+		if enableDebugLogging {
+			debug("%s not configured, using default: %s", key, strings.Join(defaults, ", "))
+		}
+		knownHostsFiles = defaults
+	}
+	if len(knownHostsFiles) == 1 && strings.EqualFold(knownHostsFiles[0], "none") {
+		debug("%s disabled (set to 'none')", key)
+		return nil, nil
+	}
 	var files []string
-	addKnownHostsFiles := func(key string, user bool, defaults []string) error {
-		knownHostsFiles := getOptionConfigSplits(param.args, key)
-		if len(knownHostsFiles) == 0 {
-			if enableDebugLogging {
-				debug("%s not configured, using default: %s", key, strings.Join(defaults, ", "))
+	for _, path := range knownHostsFiles {
+		var resolvedPath string
+		if user {
+			expandedPath, err := expandTokens(path, param, "%CdhijkLlnpru")
+			if err != nil {
+				return nil, fmt.Errorf("expand UserKnownHostsFile [%s] failed: %v", path, err)
 			}
-			knownHostsFiles = defaults
+			resolvedPath = resolveHomeDir(expandedPath)
+		} else {
+			resolvedPath = path
 		}
-		if len(knownHostsFiles) == 1 && strings.EqualFold(knownHostsFiles[0], "none") {
-			debug("%s disabled (set to 'none')", key)
-			return nil
+		if !isFileExist(resolvedPath) {
+			debug("%s [%s] does not exist", key, resolvedPath)
+			continue
 		}
-		for _, path := range knownHostsFiles {
-			var resolvedPath string
+		if !canReadFile(resolvedPath) {
 			if user {
-				expandedPath, err := expandTokens(path, param, "%CdhijkLlnpru")
-				if err != nil {
-					return fmt.Errorf("expand UserKnownHostsFile [%s] failed: %v", path, err)
-				}
-				resolvedPath = resolveHomeDir(expandedPath)
+				warning("%s [%s] can't be read", key, resolvedPath)
 			} else {
-				resolvedPath = path
+				debug("%s [%s] can't be read", key, resolvedPath)
 			}
-			if !isFileExist(resolvedPath) {
-				debug("%s [%s] does not exist", key, resolvedPath)
-				continue
-			}
-			if !canReadFile(resolvedPath) {
-				if user {
-					warning("%s [%s] can't be read", key, resolvedPath)
-				} else {
-					debug("%s [%s] can't be read", key, resolvedPath)
-				}
-				continue
-			}
-			debug("add %s: %s", key, resolvedPath)
-			files = append(files, resolvedPath)
+			continue
 		}
-		return nil
+		debug("add %s: %s", key, resolvedPath)
+		files = append(files, resolvedPath)
 	}
+	return files, nil
+}
 
-	if err := addKnownHostsFiles("UserKnownHostsFile", true, []string{"~/.ssh/known_hosts", "~/.ssh/known_hosts2"}); err != nil {
+func getUserKnownHostsFiles(param *sshParam) ([]string, error) {
+	return getKnownHostsFiles(param, "UserKnownHostsFile", true, []string{"~/.ssh/known_hosts", "~/.ssh/known_hosts2"})
+}
+
+func getGlobalKnownHostsFiles(param *sshParam) ([]string, error) {
+	return getKnownHostsFiles(param, "GlobalKnownHostsFile", false, []string{"/etc/ssh/ssh_known_hosts", "/etc/ssh/ssh_known_hosts2"})
+}
+
+// getKnownHostsBundleData returns the verified KnownHostsBundle, or empty data if there is no valid bundle.
+func getKnownHostsBundleData(param *sshParam) knownHostsData {
+	if bundle := getKnownHostsBundle(param); bundle != nil {
+		if data := bundle.getData(); data != nil {
+			debug("add KnownHostsBundle: %s", bundle.source)
+			return knownHostsData{"KnownHostsBundle", data}
+		}
+	}
+	return knownHostsData{}
+}
+
+func getHostKeyCallback(param *sshParam) (ssh.HostKeyCallback, []string, error) {
+	files, err := getUserKnownHostsFiles(param)
+	if err != nil {
 		return nil, nil, err
 	}
 
@@ -345,11 +398,18 @@ func getHostKeyCallback(param *sshParam) (ssh.HostKeyCallback, []string, error)
 		}
 	}
 
-	if err := addKnownHostsFiles("GlobalKnownHostsFile", false, []string{"/etc/ssh/ssh_known_hosts", "/etc/ssh/ssh_known_hosts2"}); err != nil {
+	globalFiles, err := getGlobalKnownHostsFiles(param)
+	if err != nil {
 		return nil, nil, err
 	}
+	files = append(files, globalFiles...)
+
+	bundleData := getKnownHostsBundleData(param)
+	newKnownHostsDBWithCommand := func(output []byte) (*knownhosts.HostKeyDB, []malformedKnownHost, error) {
+		return newKnownHostsDBWithData(files, bundleData, knownHostsData{"KnownHostsCommand", output})
+	}
 
-	khdb, malformed, err := newKnownHostsDB(files...)
+	khdb, malformed, err := newKnownHostsDBWithCommand(runKnownHostsCommand(param, param.hostKeyAddr(), "ORDER", nil))
 	if err != nil {
 		return nil, nil, fmt.Errorf("new knownhosts failed: %v", err)
 	}
@@ -363,6 +423,7 @@ func getHostKeyCallback(param *sshParam) (ssh.HostKeyCallback, []string, error)
 			if enableDebugLogging {
 				debug("host key [%s] has been accepted", ssh.FingerprintSHA256(key))
 			}
+			param.verifiedHostKey = &verifiedHostKey{host, key, primaryPath}
 			return nil
 		}
 
@@ -372,8 +433,22 @@ func getHostKeyCallback(param *sshParam) (ssh.HostKeyCallback, []string, error)
 			}
 		}()
 
-		err = khdb.HostKeyCallback()(host, remote, key)
+		db := khdb
+		if output := runKnownHostsCommand(param, host, "HOSTNAME", key); output != nil {
+			if cmdDB, _, err := newKnownHostsDBWithCommand(output); err == nil {
+				db = cmdDB
+			} else {
+				warning("merge KnownHostsCommand output failed: %v", err)
+			}
+		}
+
+		err = db.HostKeyCallback()(host, remote, key)
+		ipCheck := newHostIPCheck(param, db, host, remote, key)
 		if err == nil {
+			if err := ipCheck.verify(param.args, host, primaryPath, key); err != nil {
+				return err
+			}
+			param.verifiedHostKey = &verifiedHostKey{host, key, primaryPath}
 			return nil
 		}
 
@@ -381,7 +456,7 @@ func getHostKeyCallback(param *sshParam) (ssh.HostKeyCallback, []string, error)
 		if verifyDNS := getOptionConfig(param.args, "VerifyHostKeyDNS"); strings.EqualFold(verifyDNS, "yes") ||
 			strings.EqualFold(verifyDNS, "true") || strings.EqualFold(verifyDNS, "ask") {
 			dnsHint = "\033[0;33mNo matching host key fingerprint found in DNS.\033[0m"
-			found, matched, authenticate, err := verifyHostKeyDNS(host, key)
+			found, matched, authenticate, err := verifyHostKeyDNS(param.args, host, key)
 			if err != nil {
 				warning("Verify host key DNS failed: %v", err)
 			} else if found {
@@ -404,6 +479,7 @@ func getHostKeyCallback(param *sshParam) (ssh.HostKeyCallback, []string, error)
 			if path == "" {
 				path = "~/.ssh/known_hosts"
 			}
+			ipCheck.warnDnsSpoofing(host, key)
 			warnChangedKey(key)
 			fmt.Fprintf(os.Stderr, "Add correct host key in %s to get rid of this message.\r\n", path)
 			if primaryPath != "" {
@@ -425,7 +501,16 @@ func getHostKeyCallback(param *sshParam) (ssh.HostKeyCallback, []string, error)
 			case "accept-new", "no", "off", "false":
 				ask = false
 			}
-			return addHostKey(param.args, primaryPath, host, key, ask, dnsHint)
+			if err := addHostKey(param.args, primaryPath, host, ipCheck.newAddr(), key, ask, dnsHint); err != nil {
+				return err
+			}
+			if ipCheck.newAddr() == "" {
+				if err := ipCheck.verify(param.args, host, primaryPath, key); err != nil {
+					return err
+				}
+			}
+			param.verifiedHostKey = &verifiedHostKey{host, key, primaryPath}
+			return nil
 		}
 		switch strictHostKeyChecking {
 		case "no", "off", "false":
@@ -435,7 +520,7 @@ func getHostKeyCallback(param *sshParam) (ssh.HostKeyCallback, []string, error)
 		}
 	}
 
-	return hostKeyCallback, khdb.HostKeyAlgorithms(param.addr), err
+	return hostKeyCallback, khdb.HostKeyAlgorithms(param.hostKeyAddr()), err
 }
 
 func warnChangedKey(key ssh.PublicKey) {
@@ -452,47 +537,27 @@ func warnChangedKey(key ssh.PublicKey) {
 }
 
 func removeHostKey(path string, param *sshParam) error {
-	input, err := os.ReadFile(path)
-	if err != nil {
-		return fmt.Errorf("read known_hosts %q failed: %v", path, err)
-	}
+	normalizedTarget := knownhosts.Normalize(param.hostKeyAddr())
 
-	info, err := os.Stat(path)
-	if err != nil {
-		return fmt.Errorf("stat known_hosts %q failed: %v", path, err)
-	}
-	filePerm := info.Mode().Perm()
-
-	normalizedTarget := knownhosts.Normalize(param.addr)
-
-	inputLines := bytes.Split(input, []byte{'\n'})
-	outputLines := make([][]byte, 0, len(inputLines))
-	removedCount := 0
-
-	for _, line := range inputLines {
+	removedCount, backupPath, err := editKnownHosts(path, func(line []byte) [][]byte {
 		trimedLine := bytes.TrimSpace(line)
 		if len(trimedLine) == 0 || trimedLine[0] == '#' {
-			outputLines = append(outputLines, line)
-			continue
+			return [][]byte{line}
 		}
 
 		fields := bytes.Fields(trimedLine)
-		if len(fields) == 0 {
-			outputLines = append(outputLines, line)
-			continue
-		}
-
 		hostField := string(fields[0])
 		if strings.HasPrefix(hostField, "@") && len(fields) > 1 {
 			hostField = string(fields[1])
 		}
 
 		if matchKnownHosts(hostField, normalizedTarget) {
-			removedCount++
-			continue
+			return nil
 		}
-
-		outputLines = append(outputLines, line)
+		return [][]byte{line}
+	})
+	if err != nil {
+		return err
 	}
 
 	if removedCount == 0 {
@@ -500,15 +565,6 @@ func removeHostKey(path string, param *sshParam) error {
 		return nil
 	}
 
-	backupPath := path + ".old"
-	if err := os.WriteFile(backupPath, input, filePerm); err != nil {
-		return fmt.Errorf("create backup %q failed: %v", backupPath, err)
-	}
-
-	if err := os.WriteFile(path, bytes.Join(outputLines, []byte{'\n'}), filePerm); err != nil {
-		return fmt.Errorf("update known_hosts %q failed: %v", path, err)
-	}
-
 	fmt.Fprintf(os.Stderr, "\033[0;36mRemoved %d outdated key(s) for %s from %s (backup saved to %s)\033[0m\r\n",
 		removedCount, normalizedTarget, path, backupPath)
 	return nil
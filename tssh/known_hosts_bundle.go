@@ -0,0 +1,322 @@
+/*
+MIT License
+
+Copyright (c) 2023-2026 The Trzsz SSH Authors.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package tssh
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	kSshSigMagic              = "SSHSIG"
+	kKnownHostsBundleNS       = "tssh-known-hosts"
+	kKnownHostsBundleMaxSize  = 16 << 20
+	kDefaultBundleRefreshTime = time.Hour
+	kKnownHostsBundleSerial   = "# tssh-known-hosts-serial:"
+)
+
+// knownHostsBundle is a known_hosts file signed by `ssh-keygen -Y sign -n tssh-known-hosts`,
+// loaded from a file or an URL, with the signature in the same place with a `.sig` suffix.
+type knownHostsBundle struct {
+	source   string
+	signers  []ssh.PublicKey
+	refresh  time.Duration
+	cacheDir string
+}
+
+func getKnownHostsBundle(param *sshParam) *knownHostsBundle {
+	source := getExOptionConfig(param.args, "KnownHostsBundle")
+	if source == "" {
+		return nil
+	}
+	bundle := &knownHostsBundle{source: source, refresh: kDefaultBundleRefreshTime}
+	if !isHttpUrl(source) {
+		bundle.source = resolveHomeDir(source)
+	}
+
+	for _, value := range getAllExOptionConfig(param.args, "KnownHostsBundleSigner", false) {
+		data := []byte(value)
+		if !strings.Contains(value, " ") {
+			var err error
+			if data, err = os.ReadFile(resolveHomeDir(value)); err != nil {
+				warning("read KnownHostsBundleSigner [%s] failed: %v", value, err)
+				continue
+			}
+		}
+		for len(bytes.TrimSpace(data)) > 0 {
+			key, _, _, rest, err := ssh.ParseAuthorizedKey(data)
+			if err != nil {
+				warning("parse KnownHostsBundleSigner [%s] failed: %v", value, err)
+				break
+			}
+			bundle.signers = append(bundle.signers, key)
+			data = rest
+		}
+	}
+	if len(bundle.signers) == 0 {
+		warning("KnownHostsBundle [%s] is ignored as no valid KnownHostsBundleSigner is configured", source)
+		return nil
+	}
+
+	if refresh := getExOptionConfig(param.args, "KnownHostsBundleRefresh"); refresh != "" {
+		seconds, err := convertSshTime(refresh)
+		if err != nil {
+			warning("KnownHostsBundleRefresh [%s] is invalid: %v", refresh, err)
+		} else {
+			bundle.refresh = time.Duration(seconds) * time.Second
+		}
+	}
+
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		cacheDir = filepath.Join(userHomeDir, ".cache")
+	}
+	bundle.cacheDir = filepath.Join(cacheDir, "tssh", "known_hosts")
+	return bundle
+}
+
+func isHttpUrl(source string) bool {
+	lower := strings.ToLower(source)
+	return strings.HasPrefix(lower, "https://") || strings.HasPrefix(lower, "http://")
+}
+
+func (b *knownHostsBundle) cachePath() string {
+	return filepath.Join(b.cacheDir, fmt.Sprintf("%x", sha256.Sum256([]byte(b.source)))[:32])
+}
+
+// getData returns the content of the verified bundle, or nil if there is no valid bundle.
+// The verified content is returned instead of the path, so the bundle is not read again after verified.
+func (b *knownHostsBundle) getData() []byte {
+	if !isHttpUrl(b.source) {
+		data, err := b.readFile(b.source)
+		if err != nil {
+			warning("KnownHostsBundle [%s] is ignored: %v", b.source, err)
+			return nil
+		}
+		return data
+	}
+
+	path := b.cachePath()
+	cached, cacheErr := b.readFile(path)
+	if cacheErr == nil {
+		if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) < b.refresh {
+			debug("use cached KnownHostsBundle [%s] for [%s]", path, b.source)
+			return cached
+		}
+	}
+
+	data, err := b.download(path, cached)
+	if err != nil {
+		if cacheErr == nil {
+			warning("update KnownHostsBundle [%s] failed, using the cached one: %v", b.source, err)
+			return cached
+		}
+		warning("KnownHostsBundle [%s] is ignored: %v", b.source, err)
+		return nil
+	}
+	debug("downloaded KnownHostsBundle [%s] to [%s]", b.source, path)
+	return data
+}
+
+// getBundleSerial returns the serial in the `# tssh-known-hosts-serial: <number>` line of the bundle,
+// or 0 if there is no serial line, so the signed bundle can not be replaced by an older one.
+func getBundleSerial(data []byte) (uint64, error) {
+	for line := range strings.Lines(string(data)) {
+		value, ok := strings.CutPrefix(strings.TrimSpace(line), kKnownHostsBundleSerial)
+		if !ok {
+			continue
+		}
+		serial, err := strconv.ParseUint(strings.TrimSpace(value), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid serial [%s]", strings.TrimSpace(value))
+		}
+		return serial, nil
+	}
+	return 0, nil
+}
+
+func fetchKnownHostsBundle(url string) ([]byte, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("get [%s] http response status code %d", url, resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, kKnownHostsBundleMaxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > kKnownHostsBundleMaxSize {
+		return nil, fmt.Errorf("[%s] is too large", url)
+	}
+	return data, nil
+}
+
+func (b *knownHostsBundle) download(path string, cached []byte) ([]byte, error) {
+	data, err := fetchKnownHostsBundle(b.source)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := fetchKnownHostsBundle(b.source + ".sig")
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySshSig(data, signature, kKnownHostsBundleNS, b.signers); err != nil {
+		return nil, err
+	}
+	serial, err := getBundleSerial(data)
+	if err != nil {
+		return nil, err
+	}
+	if cached != nil {
+		if cachedSerial, err := getBundleSerial(cached); err == nil && serial < cachedSerial {
+			return nil, fmt.Errorf("serial %d is older than the cached serial %d", serial, cachedSerial)
+		}
+	}
+
+	if err := os.MkdirAll(b.cacheDir, 0700); err != nil {
+		return nil, err
+	}
+	// write the signature first, so the cached bundle is never used with a mismatched signature
+	if err := os.WriteFile(path+".sig", signature, 0600); err != nil {
+		return nil, err
+	}
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0600); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// readFile reads the bundle and its signature, returns the content only if it is verified.
+func (b *knownHostsBundle) readFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := os.ReadFile(path + ".sig")
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySshSig(data, signature, kKnownHostsBundleNS, b.signers); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// verifySshSig verifies the armored signature generated by `ssh-keygen -Y sign`,
+// see https://github.com/openssh/openssh-portable/blob/master/PROTOCOL.sshsig
+func verifySshSig(message, armored []byte, namespace string, signers []ssh.PublicKey) error {
+	text := strings.TrimSpace(string(armored))
+	text, ok := strings.CutPrefix(text, "-----BEGIN SSH SIGNATURE-----")
+	if !ok {
+		return fmt.Errorf("invalid signature: missing begin line")
+	}
+	text, ok = strings.CutSuffix(text, "-----END SSH SIGNATURE-----")
+	if !ok {
+		return fmt.Errorf("invalid signature: missing end line")
+	}
+	blob, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(text), ""))
+	if err != nil {
+		return fmt.Errorf("invalid signature: %v", err)
+	}
+	data, ok := bytes.CutPrefix(blob, []byte(kSshSigMagic))
+	if !ok {
+		return fmt.Errorf("invalid signature: bad magic")
+	}
+	var sig struct {
+		Version       uint32
+		PublicKey     []byte
+		Namespace     string
+		Reserved      string
+		HashAlgorithm string
+		Signature     []byte
+	}
+	if err := ssh.Unmarshal(data, &sig); err != nil {
+		return fmt.Errorf("invalid signature: %v", err)
+	}
+	if sig.Version != 1 {
+		return fmt.Errorf("unsupported signature version %d", sig.Version)
+	}
+	if sig.Namespace != namespace {
+		return fmt.Errorf("signature namespace [%s] is not [%s]", sig.Namespace, namespace)
+	}
+
+	var hash []byte
+	switch sig.HashAlgorithm {
+	case "sha256":
+		sum := sha256.Sum256(message)
+		hash = sum[:]
+	case "sha512":
+		sum := sha512.Sum512(message)
+		hash = sum[:]
+	default:
+		return fmt.Errorf("unsupported signature hash algorithm [%s]", sig.HashAlgorithm)
+	}
+
+	var signer ssh.PublicKey
+	for _, key := range signers {
+		if bytes.Equal(key.Marshal(), sig.PublicKey) {
+			signer = key
+			break
+		}
+	}
+	if signer == nil {
+		return fmt.Errorf("signature is not signed by a trusted signer")
+	}
+
+	var signature ssh.Signature
+	if err := ssh.Unmarshal(sig.Signature, &signature); err != nil {
+		return fmt.Errorf("invalid signature: %v", err)
+	}
+	signedData := append([]byte(kSshSigMagic), ssh.Marshal(struct {
+		Namespace     string
+		Reserved      string
+		HashAlgorithm string
+		Hash          []byte
+	}{sig.Namespace, sig.Reserved, sig.HashAlgorithm, hash})...)
+	if err := signer.Verify(signedData, &signature); err != nil {
+		return fmt.Errorf("verify signature failed: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,181 @@
+/*
+MIT License
+
+Copyright (c) 2023-2026 The Trzsz SSH Authors.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package tssh
+
+import (
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/skeema/knownhosts"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+// signSshSig generates the same armored signature as `ssh-keygen -Y sign`.
+func signSshSig(t *testing.T, signer ssh.Signer, namespace string, message []byte) []byte {
+	hash := sha512.Sum512(message)
+	signedData := append([]byte(kSshSigMagic), ssh.Marshal(struct {
+		Namespace     string
+		Reserved      string
+		HashAlgorithm string
+		Hash          []byte
+	}{namespace, "", "sha512", hash[:]})...)
+	signature, err := signer.Sign(rand.Reader, signedData)
+	require.NoError(t, err)
+	blob := append([]byte(kSshSigMagic), ssh.Marshal(struct {
+		Version       uint32
+		PublicKey     []byte
+		Namespace     string
+		Reserved      string
+		HashAlgorithm string
+		Signature     []byte
+	}{1, signer.PublicKey().Marshal(), namespace, "", "sha512", ssh.Marshal(signature)})...)
+	encoded := base64.StdEncoding.EncodeToString(blob)
+	var buf strings.Builder
+	buf.WriteString("-----BEGIN SSH SIGNATURE-----\n")
+	for len(encoded) > 70 {
+		buf.WriteString(encoded[:70] + "\n")
+		encoded = encoded[70:]
+	}
+	buf.WriteString(encoded + "\n-----END SSH SIGNATURE-----\n")
+	return []byte(buf.String())
+}
+
+func TestVerifySshSig(t *testing.T) {
+	assert := assert.New(t)
+	signer, other := newTestHostKey(t), newTestHostKey(t)
+	message := []byte("example.com ssh-ed25519 AAAA\n")
+	signature := signSshSig(t, signer, kKnownHostsBundleNS, message)
+	signers := []ssh.PublicKey{other.PublicKey(), signer.PublicKey()}
+
+	assert.Nil(verifySshSig(message, signature, kKnownHostsBundleNS, signers))
+	assert.ErrorContains(verifySshSig(append(message, '#'), signature, kKnownHostsBundleNS, signers), "verify signature failed")
+	assert.ErrorContains(verifySshSig(message, signature, "file", signers), "namespace")
+	assert.ErrorContains(verifySshSig(message, signature, kKnownHostsBundleNS, signers[:1]), "trusted signer")
+	assert.ErrorContains(verifySshSig(message, signSshSig(t, signer, "git", message), kKnownHostsBundleNS, signers), "namespace")
+	assert.Error(verifySshSig(message, []byte("garbage"), kKnownHostsBundleNS, signers))
+}
+
+func TestKnownHostsBundle(t *testing.T) {
+	assert := assert.New(t)
+	oriEnableWarning, oriUserConfig := enableWarningLogging, userConfig
+	enableWarningLogging, userConfig = false, &tsshConfig{}
+	defer func() { enableWarningLogging, userConfig = oriEnableWarning, oriUserConfig }()
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+
+	signer, hostKey := newTestHostKey(t), newTestHostKey(t)
+	content := []byte(knownhosts.Line([]string{"example.com"}, hostKey.PublicKey()) + "\n")
+	signature := signSshSig(t, signer, kKnownHostsBundleNS, content)
+	signerLine := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(signer.PublicKey())))
+
+	newParam := func(options ...string) *sshParam {
+		args := &sshArgs{Destination: "example.com"}
+		for _, option := range options {
+			require.NoError(t, args.Option.UnmarshalText([]byte(option)))
+		}
+		return &sshParam{args: args, host: "example.com", port: "22", addr: "example.com:22"}
+	}
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "known_hosts")
+	require.NoError(t, os.WriteFile(path, content, 0600))
+	require.NoError(t, os.WriteFile(path+".sig", signature, 0600))
+	assert.Nil(getKnownHostsBundle(newParam("KnownHostsBundle=" + path)))
+	bundle := getKnownHostsBundle(newParam("KnownHostsBundle="+path, "KnownHostsBundleSigner="+signerLine))
+	require.NotNil(t, bundle)
+	assert.Equal(content, bundle.getData())
+
+	signerFile := filepath.Join(dir, "signer.pub")
+	require.NoError(t, os.WriteFile(signerFile, []byte(signerLine+"\n"), 0600))
+	require.NoError(t, os.WriteFile(path, append(content, '#'), 0600))
+	assert.Nil(getKnownHostsBundle(newParam("KnownHostsBundle="+path, "KnownHostsBundleSigner="+signerFile)).getData())
+
+	var requests atomic.Int32
+	var broken atomic.Bool
+	var served atomic.Pointer[[2][]byte]
+	served.Store(&[2][]byte{content, signature})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		switch {
+		case broken.Load():
+			w.WriteHeader(http.StatusInternalServerError)
+		case r.URL.Path == "/known_hosts":
+			_, _ = w.Write(served.Load()[0])
+		case r.URL.Path == "/known_hosts.sig":
+			_, _ = w.Write(served.Load()[1])
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	param := newParam("KnownHostsBundle="+server.URL+"/known_hosts", "KnownHostsBundleSigner="+signerLine,
+		"UserKnownHostsFile=none", "GlobalKnownHostsFile=none", "StrictHostKeyChecking=yes", "UpdateHostKeys=no")
+	cachePath := getKnownHostsBundle(param).cachePath()
+	assert.Equal(content, getKnownHostsBundle(param).getData())
+	assert.Equal(int32(2), requests.Load())
+	assert.Equal(content, getKnownHostsBundle(param).getData())
+	assert.Equal(int32(2), requests.Load())
+
+	callback, _, err := getHostKeyCallback(param)
+	require.NoError(t, err)
+	remote := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 22}
+	assert.Nil(callback("example.com:22", remote, hostKey.PublicKey()))
+	assert.Error(callback("example.com:22", remote, signer.PublicKey()))
+
+	// the verified content is used even if the cached file is replaced after verified
+	require.NoError(t, os.WriteFile(cachePath, []byte("# replaced\n"), 0600))
+	assert.Nil(callback("example.com:22", remote, hostKey.PublicKey()))
+
+	broken.Store(true)
+	require.NoError(t, os.WriteFile(cachePath, content, 0600))
+	require.NoError(t, param.args.Option.UnmarshalText([]byte("KnownHostsBundleRefresh=0")))
+	assert.Equal(content, getKnownHostsBundle(param).getData())
+	assert.Equal(int32(3), requests.Load())
+	require.NoError(t, os.Remove(cachePath+".sig"))
+	assert.Nil(getKnownHostsBundle(param).getData())
+	broken.Store(false)
+
+	// a bundle with an older serial can not replace the cached one
+	newer := append([]byte(kKnownHostsBundleSerial+" 2\n"), content...)
+	served.Store(&[2][]byte{newer, signSshSig(t, signer, kKnownHostsBundleNS, newer)})
+	assert.Equal(newer, getKnownHostsBundle(param).getData())
+	older := append([]byte(kKnownHostsBundleSerial+" 1\n"), content...)
+	served.Store(&[2][]byte{older, signSshSig(t, signer, kKnownHostsBundleNS, older)})
+	assert.Equal(newer, getKnownHostsBundle(param).getData())
+	served.Store(&[2][]byte{content, signature})
+	assert.Equal(newer, getKnownHostsBundle(param).getData())
+}
@@ -0,0 +1,153 @@
+/*
+MIT License
+
+Copyright (c) 2023-2026 The Trzsz SSH Authors.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package tssh
+
+import (
+	"bytes"
+	"encoding/base64"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+
+	"github.com/skeema/knownhosts"
+	"golang.org/x/crypto/ssh"
+)
+
+// hostKeyTokens are the %H %I %K %f %t tokens of KnownHostsCommand.
+type hostKeyTokens struct {
+	host   string
+	reason string
+	key    ssh.PublicKey
+}
+
+func (t *hostKeyTokens) expand(token rune) string {
+	if t == nil {
+		return ""
+	}
+	switch token {
+	case 'H':
+		return t.host
+	case 'I':
+		return t.reason
+	}
+	if t.key == nil {
+		return ""
+	}
+	switch token {
+	case 'K':
+		return base64.StdEncoding.EncodeToString(t.key.Marshal())
+	case 'f':
+		return ssh.FingerprintSHA256(t.key)
+	case 't':
+		return t.key.Type()
+	}
+	return ""
+}
+
+// runKnownHostsCommand runs KnownHostsCommand and returns its output in known_hosts format.
+// The reason is ORDER when getting the host key algorithms before connecting,
+// or HOSTNAME when verifying the host key sent by the server.
+func runKnownHostsCommand(param *sshParam, host, reason string, key ssh.PublicKey) []byte {
+	command := getOptionConfig(param.args, "KnownHostsCommand")
+	if command == "" || strings.EqualFold(command, "none") {
+		return nil
+	}
+
+	tokens := &hostKeyTokens{host: knownhosts.Normalize(host), reason: reason, key: key}
+	if !isHostValid(tokens.host) {
+		warning("KnownHostsCommand host [%s] contains invalid characters", tokens.host)
+		return nil
+	}
+	expanded, err := expandHostKeyTokens(command, param, "%CHIKLfhklnprtu", tokens)
+	if err != nil {
+		warning("expand KnownHostsCommand [%s] failed: %v", command, err)
+		return nil
+	}
+	argv, err := splitCommandLine(expanded)
+	if err != nil || len(argv) == 0 {
+		warning("split KnownHostsCommand [%s] failed: %v", expanded, err)
+		return nil
+	}
+	debug("run KnownHostsCommand [%s] for %s", expanded, reason)
+
+	cmd := exec.Command(argv[0], argv[1:]...)
+	var outBuf, errBuf bytes.Buffer
+	cmd.Stdout = &outBuf
+	cmd.Stderr = &errBuf
+	if err := cmd.Run(); err != nil {
+		if errBuf.Len() > 0 {
+			warning("exec KnownHostsCommand [%s] failed: %v, %s", expanded, err, strings.TrimSpace(errBuf.String()))
+		} else {
+			warning("exec KnownHostsCommand [%s] failed: %v", expanded, err)
+		}
+		return nil
+	}
+	if enableDebugLogging && errBuf.Len() > 0 {
+		debug("KnownHostsCommand stderr output: %s", errBuf.String())
+	}
+	return outBuf.Bytes()
+}
+
+// knownHostsData is the known_hosts content not read from the known_hosts files,
+// such as the verified KnownHostsBundle or the output of KnownHostsCommand.
+type knownHostsData struct {
+	name string
+	data []byte
+}
+
+// newKnownHostsDBWithData merges the known_hosts content into the knownhosts DB of the files.
+func newKnownHostsDBWithData(files []string, extras ...knownHostsData) (*knownhosts.HostKeyDB, []malformedKnownHost, error) {
+	files = slices.Clone(files)
+	names := make(map[string]string)
+	for _, extra := range extras {
+		if len(bytes.TrimSpace(extra.data)) == 0 {
+			continue
+		}
+		file, err := os.CreateTemp("", "tssh_known_hosts_data_*")
+		if err != nil {
+			return nil, nil, err
+		}
+		path := file.Name()
+		defer func() { _ = os.Remove(path) }()
+		if err := writeAll(file, extra.data); err != nil {
+			_ = file.Close()
+			return nil, nil, err
+		}
+		if err := file.Close(); err != nil {
+			return nil, nil, err
+		}
+		files = append(files, path)
+		names[path] = extra.name
+	}
+
+	db, malformed, err := newKnownHostsDB(files...)
+	for i := range malformed {
+		if name, ok := names[malformed[i].path]; ok {
+			malformed[i].path = name
+		}
+	}
+	return db, malformed, err
+}
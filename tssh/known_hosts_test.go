@@ -31,6 +31,7 @@ import (
 	"net"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strconv"
 	"strings"
 	"testing"
@@ -147,3 +148,145 @@ func TestNewKnownHostsDBKeepsNonParseErrorsFatal(t *testing.T) {
 	require.Error(t, err)
 	assert.Nil(t, malformed)
 }
+
+func TestKnownHostsCommand(t *testing.T) {
+	assert := assert.New(t)
+	oriEnableWarning, oriUserConfig := enableWarningLogging, userConfig
+	enableWarningLogging, userConfig = false, &tsshConfig{}
+	defer func() { enableWarningLogging, userConfig = oriEnableWarning, oriUserConfig }()
+
+	key := newKnownHostsTestKey(t)
+	tokens := &hostKeyTokens{host: "[example.com]:2222", reason: "HOSTNAME", key: key}
+	param := &sshParam{args: &sshArgs{Destination: "example"}, host: "example.com", port: "2222"}
+	expanded, err := expandHostKeyTokens("cmd %H %I %t %f %K %h", param, "%CHIKLfhklnprtu", tokens)
+	require.NoError(t, err)
+	assert.Equal(strings.Join([]string{"cmd", "[example.com]:2222", "HOSTNAME", key.Type(), ssh.FingerprintSHA256(key),
+		strings.Fields(string(ssh.MarshalAuthorizedKey(key)))[1], "example.com"}, " "), expanded)
+	tokens = &hostKeyTokens{host: "example.com", reason: "ORDER"}
+	expanded, err = expandHostKeyTokens("cmd %I [%K]", param, "%CHIKLfhklnprtu", tokens)
+	require.NoError(t, err)
+	assert.Equal("cmd ORDER []", expanded)
+	expanded, err = expandTokens("cmd [%H]", param, "%CHIKLfhklnprtu")
+	require.NoError(t, err)
+	assert.Equal("cmd []", expanded)
+
+	knownHosts := writeKnownHostsTestFile(t, t.TempDir(), "known_hosts", "")
+	newParam := func(command string) *sshParam {
+		args := &sshArgs{Destination: "example.com"}
+		for _, option := range []string{"KnownHostsCommand=" + command, "UserKnownHostsFile=" + knownHosts,
+			"GlobalKnownHostsFile=none", "StrictHostKeyChecking=yes", "UpdateHostKeys=no"} {
+			require.NoError(t, args.Option.UnmarshalText([]byte(option)))
+		}
+		return &sshParam{args: args, host: "example.com", port: "22", addr: "example.com:22"}
+	}
+
+	if runtime.GOOS == "windows" {
+		return
+	}
+	// the command trusts the key for HOSTNAME only, and prints nothing for ORDER
+	script := filepath.Join(t.TempDir(), "known_hosts_command.sh")
+	require.NoError(t, os.WriteFile(script, []byte("#!/bin/sh\n[ \"$2\" = HOSTNAME ] && echo \"$1 $3 $4\"\nexit 0\n"), 0700))
+	callback, _, err := getHostKeyCallback(newParam(script + " %H %I %t %K"))
+	require.NoError(t, err)
+	remote := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 22}
+	assert.Nil(callback("example.com:22", remote, key))
+
+	callback, _, err = getHostKeyCallback(newParam("echo other.com " + strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))))
+	require.NoError(t, err)
+	assert.True(knownhosts.IsHostUnknown(callback("example.com:22", remote, newKnownHostsTestKey(t))))
+}
+
+func TestCheckHostIP(t *testing.T) {
+	assert := assert.New(t)
+	oriEnableWarning, oriUserConfig := enableWarningLogging, userConfig
+	enableWarningLogging, userConfig = false, &tsshConfig{}
+	defer func() { enableWarningLogging, userConfig = oriEnableWarning, oriUserConfig }()
+
+	dir := t.TempDir()
+	remote := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 22}
+	remote2222 := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 2222}
+	newParam := func(knownHosts, strict string) *sshParam {
+		args := &sshArgs{Destination: "example.com"}
+		for _, option := range []string{"CheckHostIP=yes", "UserKnownHostsFile=" + knownHosts, "GlobalKnownHostsFile=none",
+			"StrictHostKeyChecking=" + strict, "UpdateHostKeys=no"} {
+			require.NoError(t, args.Option.UnmarshalText([]byte(option)))
+		}
+		return &sshParam{args: args, host: "example.com", port: "22", addr: "example.com:22"}
+	}
+	readKnownHosts := func(path string) string {
+		content, err := os.ReadFile(path)
+		require.NoError(t, err)
+		return string(content)
+	}
+	keyLine := func(key ssh.PublicKey) string {
+		return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+	}
+
+	assert.Equal("", getCheckHostIPAddr(newParam("none", "yes"), "192.0.2.1:22", remote))
+	assert.Equal("", getCheckHostIPAddr(newParam("none", "yes"), "example.com:22", &net.TCPAddr{IP: net.IPv4zero, Port: 22}))
+	assert.Equal("192.0.2.1:2222", getCheckHostIPAddr(newParam("none", "yes"), "example.com:2222", remote2222))
+	assert.Equal("192.0.2.1:2222", getCheckHostIPAddr(newParam("none", "yes"), "alias:22", remote2222))
+	proxyParam := newParam("none", "yes")
+	proxyParam.command = "nc %h %p"
+	assert.Equal("", getCheckHostIPAddr(proxyParam, "example.com:22", remote))
+
+	// a new host is recorded along with its IP
+	key := newKnownHostsTestKey(t)
+	path := writeKnownHostsTestFile(t, dir, "known_hosts_new", "")
+	callback, _, err := getHostKeyCallback(newParam(path, "accept-new"))
+	require.NoError(t, err)
+	assert.Nil(callback("example.com:22", remote, key))
+	assert.Equal("example.com "+keyLine(key)+"\n192.0.2.1 "+keyLine(key)+"\n", readKnownHosts(path))
+
+	// the unknown IP of a known host is recorded
+	key = newKnownHostsTestKey(t)
+	path = writeKnownHostsTestFile(t, dir, "known_hosts_ip", "[example.com]:2222 "+keyLine(key)+"\n")
+	callback, _, err = getHostKeyCallback(newParam(path, "yes"))
+	require.NoError(t, err)
+	assert.Nil(callback("example.com:2222", remote2222, key))
+	assert.Equal("[example.com]:2222 "+keyLine(key)+"\n[192.0.2.1]:2222 "+keyLine(key)+"\n", readKnownHosts(path))
+
+	// the IP with a different key is rejected in strict mode, and only warned otherwise
+	key = newKnownHostsTestKey(t)
+	content := "example.com " + keyLine(key) + "\n192.0.2.1 " + keyLine(newKnownHostsTestKey(t)) + "\n"
+	path = writeKnownHostsTestFile(t, dir, "known_hosts_changed", content)
+	callback, _, err = getHostKeyCallback(newParam(path, "yes"))
+	require.NoError(t, err)
+	assert.NotNil(callback("example.com:22", remote, key))
+	callback, _, err = getHostKeyCallback(newParam(path, "no"))
+	require.NoError(t, err)
+	assert.Nil(callback("example.com:22", remote, key))
+	assert.Equal(content, readKnownHosts(path))
+}
+
+func TestAddHostKeyAskpass(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the stub askpass is a shell script")
+	}
+	assert := assert.New(t)
+	oriEnableWarning, oriUserConfig := enableWarningLogging, userConfig
+	enableWarningLogging, userConfig = false, &tsshConfig{}
+	defer func() { enableWarningLogging, userConfig = oriEnableWarning, oriUserConfig }()
+
+	// the askpass answers only when a text entry is requested instead of a confirmation
+	dir := t.TempDir()
+	answer := filepath.Join(dir, "answer")
+	askpass := filepath.Join(dir, "askpass.sh")
+	require.NoError(t, os.WriteFile(askpass, []byte("#!/bin/sh\n[ \"$SSH_ASKPASS_PROMPT\" = confirm ] && exit 1\ncat "+answer+"\n"), 0700))
+	t.Setenv("SSH_ASKPASS", askpass)
+	t.Setenv("SSH_ASKPASS_REQUIRE", "force")
+
+	args := &sshArgs{}
+	require.NoError(t, args.Option.UnmarshalText([]byte("HashKnownHosts=no")))
+	knownHosts := writeKnownHostsTestFile(t, dir, "known_hosts", "")
+	key := newKnownHostsTestKey(t)
+
+	require.NoError(t, os.WriteFile(answer, []byte("no\n"), 0600))
+	assert.EqualError(addHostKey(args, knownHosts, "example.com:22", "", key, true, ""), "host key not trusted")
+
+	require.NoError(t, os.WriteFile(answer, []byte(ssh.FingerprintSHA256(key)+"\n"), 0600))
+	assert.Nil(addHostKey(args, knownHosts, "example.com:22", "", key, true, ""))
+	content, err := os.ReadFile(knownHosts)
+	require.NoError(t, err)
+	assert.Equal(knownhosts.Line([]string{"example.com:22"}, key)+"\n", string(content))
+}
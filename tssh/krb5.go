@@ -99,6 +99,55 @@ func getKrb5CacheFile() string {
 	return cachePath
 }
 
+// getKrb5Principal returns the user name and the realm of the kerberos principal,
+// from GSSAPIClientIdentity or the login user. Empty realm means the default realm.
+func getKrb5Principal(param *sshParam) (string, string) {
+	principal := getExOptionConfig(param.args, "GSSAPIClientIdentity")
+	if principal == "" {
+		principal = param.user
+	}
+	if idx := strings.LastIndexByte(principal, '@'); idx >= 0 {
+		return principal[:idx], principal[idx+1:]
+	}
+	return principal, ""
+}
+
+// newKrb5Client creates a krb5 client from the keytab if GSSAPIKeytab is configured, otherwise from the
+// credentials cache, and if there is no valid cache, acquires a ticket with the remembered password.
+func newKrb5Client(param *sshParam, krb5Config string) (*krb5.Krb5InitiatorClient, error) {
+	userName, realm := getKrb5Principal(param)
+
+	if keytab := getExOptionConfig(param.args, "GSSAPIKeytab"); keytab != "" {
+		keytab = resolveHomeDir(keytab)
+		debug("krb5 keytab: %s, principal: %s, realm: %s", keytab, userName, realm)
+		krb5Client, err := krb5.NewKrb5InitiatorClientWithKeytab(userName, realm, krb5Config, keytab)
+		if err != nil {
+			return nil, fmt.Errorf("new krb5 client with keytab [%s] failed: %v", keytab, err)
+		}
+		return &krb5Client, nil
+	}
+
+	if krb5CacheFile := getKrb5CacheFile(); krb5CacheFile != "" {
+		debug("krb5 cache file: %s", krb5CacheFile)
+		krb5Client, err := krb5.NewKrb5InitiatorClientWithCache(krb5Config, krb5CacheFile)
+		if err == nil {
+			return &krb5Client, nil
+		}
+		warning("new krb5 client with config [%s] cache [%s] failed: %v", krb5Config, krb5CacheFile, err)
+	}
+
+	password := getSecretConfig(param, "Password")
+	if password == "" {
+		return nil, nil
+	}
+	debug("krb5 acquire ticket with the remembered password, principal: %s, realm: %s", userName, realm)
+	krb5Client, err := krb5.NewKrb5InitiatorClientWithPassword(userName, realm, password, krb5Config)
+	if err != nil {
+		return nil, fmt.Errorf("new krb5 client with the remembered password failed: %v", err)
+	}
+	return &krb5Client, nil
+}
+
 func getGSSAPIWithMICAuthMethod(param *sshParam) ssh.AuthMethod {
 	if !strings.EqualFold(getOptionConfig(param.args, "GSSAPIAuthentication"), "yes") {
 		debug("disable auth method: gssapi-with-mic authentication")
@@ -111,18 +160,22 @@ func getGSSAPIWithMICAuthMethod(param *sshParam) ssh.AuthMethod {
 	}
 	debug("krb5 config path: %s", krb5Config)
 
-	krb5CacheFile := getKrb5CacheFile()
-	if krb5CacheFile == "" {
+	krb5Client, err := newKrb5Client(param, krb5Config)
+	if err != nil {
+		warning("%v", err)
 		return nil
 	}
-	debug("krb5 cache file: %s", krb5CacheFile)
-
-	krb5Client, err := krb5.NewKrb5InitiatorClientWithCache(krb5Config, krb5CacheFile)
-	if err != nil {
-		warning("new krb5 client with config [%s] cache [%s] failed: %v", krb5Config, krb5CacheFile, err)
+	if krb5Client == nil {
+		debug("no krb5 credentials for gssapi-with-mic authentication")
 		return nil
 	}
 
+	if strings.EqualFold(getOptionConfig(param.args, "GSSAPIDelegateCredentials"), "yes") {
+		if err := krb5Client.DelegateCredentials(); err != nil {
+			warning("krb5 delegate credentials failed: %v", err)
+		}
+	}
+
 	hostName := param.host
 	if ips, _ := net.LookupIP(param.host); len(ips) > 0 {
 		if names, _ := net.LookupAddr(ips[0].String()); len(names) > 0 {
@@ -131,5 +184,5 @@ func getGSSAPIWithMICAuthMethod(param *sshParam) ssh.AuthMethod {
 	}
 	debug("krb5 host name: %s", hostName)
 
-	return ssh.GSSAPIWithMICAuthMethod(&krb5Client, hostName)
+	return ssh.GSSAPIWithMICAuthMethod(krb5Client, hostName)
 }
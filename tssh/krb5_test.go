@@ -0,0 +1,337 @@
+/*
+MIT License
+
+Copyright (c) 2023-2026 The Trzsz SSH Authors.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package tssh
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jcmturner/gofork/encoding/asn1"
+	"github.com/jcmturner/gokrb5/v8/crypto"
+	"github.com/jcmturner/gokrb5/v8/gssapi"
+	"github.com/jcmturner/gokrb5/v8/iana/etypeID"
+	"github.com/jcmturner/gokrb5/v8/iana/flags"
+	"github.com/jcmturner/gokrb5/v8/iana/keyusage"
+	"github.com/jcmturner/gokrb5/v8/iana/msgtype"
+	"github.com/jcmturner/gokrb5/v8/iana/patype"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+	"github.com/jcmturner/gokrb5/v8/messages"
+	"github.com/jcmturner/gokrb5/v8/spnego"
+	"github.com/jcmturner/gokrb5/v8/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/trzsz/ssh_config"
+)
+
+func TestNewKrb5Client(t *testing.T) {
+	assert := assert.New(t)
+	oriEnableWarning, oriUserConfig := enableWarningLogging, userConfig
+	enableWarningLogging, userConfig = false, &tsshConfig{}
+	defer func() { enableWarningLogging, userConfig = oriEnableWarning, oriUserConfig }()
+
+	dir := t.TempDir()
+	krb5Config := filepath.Join(dir, "krb5.conf")
+	require.NoError(t, os.WriteFile(krb5Config, []byte("[libdefaults]\n  default_realm = EXAMPLE.COM\n"), 0600))
+	t.Setenv("KRB5CCNAME", "FILE:"+filepath.Join(dir, "krb5cc_none"))
+
+	newParam := func(options ...string) *sshParam {
+		args := &sshArgs{Destination: "alice@krb5host"}
+		for _, option := range options {
+			require.NoError(t, args.Option.UnmarshalText([]byte(option)))
+		}
+		param, err := getSshParam(args, false)
+		require.NoError(t, err)
+		return param
+	}
+
+	userName, realm := getKrb5Principal(newParam())
+	assert.Equal("alice", userName)
+	assert.Equal("", realm)
+	userName, realm = getKrb5Principal(newParam("GSSAPIClientIdentity=svc/build@CORP.EXAMPLE.COM"))
+	assert.Equal("svc/build", userName)
+	assert.Equal("CORP.EXAMPLE.COM", realm)
+
+	krb5Client, err := newKrb5Client(newParam(), krb5Config)
+	assert.Nil(err)
+	assert.Nil(krb5Client)
+
+	_, err = newKrb5Client(newParam("GSSAPIKeytab="+filepath.Join(dir, "none.keytab")), krb5Config)
+	assert.ErrorContains(err, "new krb5 client with keytab")
+}
+
+const kStubKdcRealm = "EXAMPLE.COM"
+
+// stubKdc issues tickets for the users in userKeytab, encrypted with the service keys in kdcKeytab.
+type stubKdc struct {
+	userKeytab *keytab.Keytab
+	kdcKeytab  *keytab.Keytab
+}
+
+func (k *stubKdc) serve(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer func() { _ = conn.Close() }()
+			var size uint32
+			if err := binary.Read(conn, binary.BigEndian, &size); err != nil {
+				return
+			}
+			req := make([]byte, size)
+			if _, err := io.ReadFull(conn, req); err != nil {
+				return
+			}
+			rep, err := k.handle(req)
+			if err != nil {
+				return
+			}
+			_ = binary.Write(conn, binary.BigEndian, uint32(len(rep)))
+			_, _ = conn.Write(rep)
+		}()
+	}
+}
+
+func (k *stubKdc) handle(req []byte) ([]byte, error) {
+	var asReq messages.ASReq
+	if err := asReq.Unmarshal(req); err == nil {
+		clientKey, _, err := k.userKeytab.GetEncryptionKey(asReq.ReqBody.CName, kStubKdcRealm, 0, etypeID.AES256_CTS_HMAC_SHA1_96)
+		if err != nil {
+			return nil, err
+		}
+		ticketFlags := asn1.BitString{Bytes: make([]byte, 4), BitLength: 32}
+		if types.IsFlagSet(&asReq.ReqBody.KDCOptions, flags.Forwardable) {
+			types.SetFlag(&ticketFlags, flags.Forwardable)
+		}
+		return k.reply(asReq.ReqBody, asReq.ReqBody.CName, ticketFlags, clientKey, keyusage.AS_REP_ENCPART, msgtype.KRB_AS_REP)
+	}
+
+	var tgsReq messages.TGSReq
+	if err := tgsReq.Unmarshal(req); err != nil {
+		return nil, err
+	}
+	for _, pa := range tgsReq.PAData {
+		if pa.PADataType != patype.PA_TGS_REQ {
+			continue
+		}
+		var apReq messages.APReq
+		if err := apReq.Unmarshal(pa.PADataValue); err != nil {
+			return nil, err
+		}
+		if err := apReq.Ticket.DecryptEncPart(k.kdcKeytab, nil); err != nil {
+			return nil, err
+		}
+		tgt := apReq.Ticket.DecryptedEncPart
+		ticketFlags := asn1.BitString{Bytes: make([]byte, 4), BitLength: 32}
+		if types.IsFlagSet(&tgt.Flags, flags.Forwardable) && types.IsFlagSet(&tgsReq.ReqBody.KDCOptions, flags.Forwarded) {
+			types.SetFlag(&ticketFlags, flags.Forwardable)
+			types.SetFlag(&ticketFlags, flags.Forwarded)
+		}
+		return k.reply(tgsReq.ReqBody, tgt.CName, ticketFlags, tgt.Key, keyusage.TGS_REP_ENCPART_SESSION_KEY, msgtype.KRB_TGS_REP)
+	}
+	return nil, fmt.Errorf("no PA-TGS-REQ")
+}
+
+func (k *stubKdc) reply(body messages.KDCReqBody, cname types.PrincipalName, ticketFlags asn1.BitString,
+	replyKey types.EncryptionKey, usage uint32, msgType int) ([]byte, error) {
+	now := time.Now().UTC().Truncate(time.Second)
+	ticket, sessionKey, err := messages.NewTicket(cname, kStubKdcRealm, body.SName, kStubKdcRealm, ticketFlags,
+		k.kdcKeytab, etypeID.AES256_CTS_HMAC_SHA1_96, 1, now, now, now.Add(time.Hour), now.Add(time.Hour))
+	if err != nil {
+		return nil, err
+	}
+	encPart := messages.EncKDCRepPart{
+		Key:       sessionKey,
+		LastReqs:  []messages.LastReq{{LRValue: now}},
+		Nonce:     body.Nonce,
+		Flags:     ticketFlags,
+		AuthTime:  now,
+		StartTime: now,
+		EndTime:   now.Add(time.Hour),
+		RenewTill: now.Add(time.Hour),
+		SRealm:    kStubKdcRealm,
+		SName:     body.SName,
+	}
+	b, err := encPart.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	encData, err := crypto.GetEncryptedData(b, replyKey, usage, 1)
+	if err != nil {
+		return nil, err
+	}
+	fields := messages.KDCRepFields{
+		PVNO:    5,
+		MsgType: msgType,
+		CRealm:  kStubKdcRealm,
+		CName:   cname,
+		Ticket:  ticket,
+		EncPart: encData,
+	}
+	if msgType == msgtype.KRB_AS_REP {
+		rep := messages.ASRep{KDCRepFields: fields}
+		return rep.Marshal()
+	}
+	rep := messages.TGSRep{KDCRepFields: fields}
+	return rep.Marshal()
+}
+
+// newStubKdc starts a stub KDC, returns the krb5 config, the keytab file of alice and the keytab of the KDC.
+func newStubKdc(t *testing.T) (string, string, *keytab.Keytab) {
+	now := time.Now()
+	userKeytab := keytab.New()
+	require.NoError(t, userKeytab.AddEntry("alice", kStubKdcRealm, "alice-pass", now, 1, etypeID.AES256_CTS_HMAC_SHA1_96))
+	kdcKeytab := keytab.New()
+	require.NoError(t, kdcKeytab.AddEntry("krbtgt/"+kStubKdcRealm, kStubKdcRealm, "krbtgt-pass", now, 1, etypeID.AES256_CTS_HMAC_SHA1_96))
+	require.NoError(t, kdcKeytab.AddEntry("host/server.example.com", kStubKdcRealm, "host-pass", now, 1, etypeID.AES256_CTS_HMAC_SHA1_96))
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = listener.Close() })
+	go (&stubKdc{userKeytab, kdcKeytab}).serve(listener)
+
+	dir := t.TempDir()
+	krb5Config := filepath.Join(dir, "krb5.conf")
+	require.NoError(t, os.WriteFile(krb5Config, fmt.Appendf(nil, `[libdefaults]
+  default_realm = %s
+  dns_lookup_kdc = false
+  udp_preference_limit = 1
+[realms]
+  %s = {
+    kdc = %s
+  }
+`, kStubKdcRealm, kStubKdcRealm, listener.Addr().String()), 0600))
+
+	keytabData, err := userKeytab.Marshal()
+	require.NoError(t, err)
+	keytabPath := filepath.Join(dir, "alice.keytab")
+	require.NoError(t, os.WriteFile(keytabPath, keytabData, 0600))
+	t.Setenv("KRB5CCNAME", "FILE:"+filepath.Join(dir, "krb5cc_none"))
+
+	return krb5Config, keytabPath, kdcKeytab
+}
+
+func TestKrb5Login(t *testing.T) {
+	assert := assert.New(t)
+	oriEnableWarning, oriUserConfig := enableWarningLogging, userConfig
+	enableWarningLogging, userConfig = false, &tsshConfig{}
+	defer func() { enableWarningLogging, userConfig = oriEnableWarning, oriUserConfig }()
+
+	krb5Config, keytabPath, kdcKeytab := newStubKdc(t)
+	config, err := ssh_config.DecodeBytes([]byte(`
+Host krb5host
+    Password alice-pass
+`))
+	require.NoError(t, err)
+	userConfig.exConfig = &sshConfig{"", config}
+
+	newParam := func(options ...string) *sshParam {
+		args := &sshArgs{Destination: "alice@krb5host"}
+		for _, option := range options {
+			require.NoError(t, args.Option.UnmarshalText([]byte(option)))
+		}
+		param, err := getSshParam(args, false)
+		require.NoError(t, err)
+		return param
+	}
+
+	assertLogin := func(param *sshParam) {
+		t.Helper()
+		krb5Client, err := newKrb5Client(param, krb5Config)
+		require.NoError(t, err)
+		require.NotNil(t, krb5Client)
+		token, _, err := krb5Client.InitSecContext("host@server.example.com", nil, false)
+		require.NoError(t, err)
+		var krb5Token spnego.KRB5Token
+		require.NoError(t, krb5Token.Unmarshal(token))
+		require.NoError(t, krb5Token.APReq.Ticket.DecryptEncPart(kdcKeytab, nil))
+		assert.Equal([]string{"alice"}, krb5Token.APReq.Ticket.DecryptedEncPart.CName.NameString)
+	}
+
+	assertLogin(newParam("GSSAPIKeytab=" + keytabPath))
+	assertLogin(newParam())
+}
+
+func TestKrb5DelegateCredentials(t *testing.T) {
+	assert := assert.New(t)
+	oriEnableWarning, oriUserConfig := enableWarningLogging, userConfig
+	enableWarningLogging, userConfig = false, &tsshConfig{}
+	defer func() { enableWarningLogging, userConfig = oriEnableWarning, oriUserConfig }()
+
+	krb5Config, keytabPath, kdcKeytab := newStubKdc(t)
+	args := &sshArgs{Destination: "alice@krb5host"}
+	require.NoError(t, args.Option.UnmarshalText([]byte("GSSAPIKeytab="+keytabPath)))
+	param, err := getSshParam(args, false)
+	require.NoError(t, err)
+
+	krb5Client, err := newKrb5Client(param, krb5Config)
+	require.NoError(t, err)
+	require.NotNil(t, krb5Client)
+	require.NoError(t, krb5Client.DelegateCredentials())
+	token, _, err := krb5Client.InitSecContext("host@server.example.com", nil, false)
+	require.NoError(t, err)
+
+	var krb5Token spnego.KRB5Token
+	require.NoError(t, krb5Token.Unmarshal(token))
+	apReq := krb5Token.APReq
+	require.NoError(t, apReq.Ticket.DecryptEncPart(kdcKeytab, nil))
+	require.NoError(t, apReq.DecryptAuthenticator(apReq.Ticket.DecryptedEncPart.Key))
+	chksum := apReq.Authenticator.Cksum.Checksum
+	require.Greater(t, len(chksum), 28)
+	assert.NotZero(binary.LittleEndian.Uint32(chksum[20:24]) & gssapi.ContextFlagDeleg)
+	assert.Equal(uint16(1), binary.LittleEndian.Uint16(chksum[24:26]))
+	assert.Equal(len(chksum)-28, int(binary.LittleEndian.Uint16(chksum[26:28])))
+
+	var cred messages.KRBCred
+	require.NoError(t, cred.Unmarshal(chksum[28:]))
+	require.Len(t, cred.Tickets, 1)
+	tgt := cred.Tickets[0]
+	require.NoError(t, tgt.DecryptEncPart(kdcKeytab, nil))
+	assert.Equal([]string{"krbtgt", kStubKdcRealm}, tgt.SName.NameString)
+	assert.True(types.IsFlagSet(&tgt.DecryptedEncPart.Flags, flags.Forwarded))
+
+	b, err := crypto.DecryptEncPart(cred.EncPart, apReq.Authenticator.SubKey, keyusage.KRB_CRED_ENCPART)
+	require.NoError(t, err)
+	var encPart struct {
+		TicketInfo []struct {
+			Key    types.EncryptionKey `asn1:"explicit,tag:0"`
+			PRealm string              `asn1:"generalstring,optional,explicit,tag:1"`
+			PName  types.PrincipalName `asn1:"optional,explicit,tag:2"`
+		} `asn1:"explicit,tag:0"`
+	}
+	_, err = asn1.UnmarshalWithParams(b, &encPart, "application,explicit,tag:29")
+	require.NoError(t, err)
+	require.Len(t, encPart.TicketInfo, 1)
+	assert.Equal(tgt.DecryptedEncPart.Key, encPart.TicketInfo[0].Key)
+	assert.Equal(kStubKdcRealm, encPart.TicketInfo[0].PRealm)
+	assert.Equal([]string{"alice"}, encPart.TicketInfo[0].PName.NameString)
+}
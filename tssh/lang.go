@@ -47,6 +47,9 @@ var english = map[string]string{
 	"console/suspend":   "Suspend the current SSH process ( ^Z : Ctrl + Z )",
 	"console/terminate": "Terminate the current SSH session ( . : Exit / Kill )",
 	"console/detach":    "Detach the current SSH session ( d : Detach )",
+	"console/ports":     "Auto forwarded ports ( p : {0} )",
+	"console/no_ports":  "None",
+	"console/open_url":  "Open in the local browser ( o : {0} )",
 	"console/notes":     "↑/↓/j/k Move • Enter Select • q Quit",
 }
 
@@ -67,6 +70,9 @@ var chinese = map[string]string{
 	"console/suspend":   "暂停当前 SSH 进程 ( ^Z : Ctrl + Z )",
 	"console/terminate": "退出当前 SSH 会话 ( . : Exit / Kill )",
 	"console/detach":    "分离当前 SSH 会话 ( d : Detach )",
+	"console/ports":     "自动转发的端口 ( p : {0} )",
+	"console/no_ports":  "无",
+	"console/open_url":  "在本地浏览器中打开 ( o : {0} )",
 	"console/notes":     "↑/↓/j/k 移动 • Enter 选择 • q 退出",
 }
 
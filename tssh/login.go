@@ -26,6 +26,7 @@ package tssh
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"io"
 	"net"
@@ -63,6 +64,8 @@ type sshParam struct {
 	udpMode udpModeType
 	ipv4    bool
 	ipv6    bool
+
+	verifiedHostKey *verifiedHostKey
 }
 
 func (p *sshParam) setNetworkAddressFamily(conn net.Conn) {
@@ -78,6 +81,17 @@ func (p *sshParam) setNetworkAddressFamily(conn net.Conn) {
 	}
 }
 
+// hostKeyAddr returns the address used for the known_hosts, which is HostKeyAlias if it's set.
+func (p *sshParam) hostKeyAddr() string {
+	if hostKeyAlias := getOptionConfig(p.args, "HostKeyAlias"); hostKeyAlias != "" {
+		if isHostValid(hostKeyAlias) {
+			return joinHostPort(hostKeyAlias, "22")
+		}
+		warning("HostKeyAlias [%s] contains invalid characters", hostKeyAlias)
+	}
+	return p.addr
+}
+
 func joinHostPort(host, port string) string {
 	if !strings.HasPrefix(host, "[") && strings.ContainsRune(host, ':') {
 		return fmt.Sprintf("[%s]:%s", host, port)
@@ -220,7 +234,7 @@ func getSshParam(args *sshArgs, proxy bool) (*sshParam, error) {
 
 	// dns srv
 	if dnsSrvName := getExOptionConfig(args, "DnsSrvName"); dnsSrvName != "" {
-		host, port, err := lookupDnsSrv(dnsSrvName)
+		host, port, err := lookupDnsSrv(args, dnsSrvName)
 		if err != nil {
 			warning("lookup dns srv [%s] failed: %v", dnsSrvName, err)
 		} else {
@@ -572,9 +586,14 @@ func getClientConfig(param *sshParam) (*ssh.ClientConfig, error) {
 	if err != nil {
 		return nil, err
 	}
+	auth := make([]ssh.AuthMethod, 0, len(authMethods))
+	for _, authMethod := range authMethods {
+		auth = append(auth, authMethod.method)
+	}
 	return &ssh.ClientConfig{
 		User:              param.user,
-		Auth:              authMethods,
+		Auth:              auth,
+		AuthCallback:      getAuthCallback(param, authMethods),
 		Timeout:           getConnectTimeout(param.args),
 		HostKeyCallback:   hostKeyCallback,
 		HostKeyAlgorithms: hostKeyAlgorithms,
@@ -588,12 +607,14 @@ func getClientConfig(param *sshParam) (*ssh.ClientConfig, error) {
 func connectViaProxyJump(param *sshParam, config *ssh.ClientConfig) (SshClient, error) {
 	debug("login to [%s] via proxy jump [%s] addr: %s", param.args.Destination, param.proxy.name, param.addr)
 	network, _, _ := getNetworkAddressFamily(param.args)
-	conn, err := param.proxy.client.DialTimeout(network, param.addr, config.Timeout)
+	conn, err := dialHostNameAlternatives(param, network, func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return param.proxy.client.DialTimeout(network, addr, config.Timeout)
+	})
 	if err != nil {
 		return nil, fmt.Errorf("proxy jump [%s] dial [%s] [%s] failed: %v", param.proxy.name, network, param.addr, err)
 	}
 	param.setNetworkAddressFamily(conn)
-	ncc, chans, reqs, err := ssh.NewClientConn(&connWithTimeout{conn, config.Timeout, true}, param.addr, config)
+	ncc, chans, reqs, err := ssh.NewClientConn(&connWithTimeout{conn, config.Timeout, true}, param.hostKeyAddr(), config)
 	if err != nil {
 		return nil, fmt.Errorf("proxy jump [%s] new conn [%s] failed: %v", param.proxy.name, param.addr, err)
 	}
@@ -602,7 +623,7 @@ func connectViaProxyJump(param *sshParam, config *ssh.ClientConfig) (SshClient,
 		_ = param.proxy.client.Close()
 		debug("proxy jump [%s] close completed", param.proxy.name)
 	})
-	return sshNewClient(ncc, chans, reqs), nil
+	return sshNewClient(ncc, chans, handleHostKeysRequests(param, ncc, reqs)), nil
 }
 
 func connectViaProxyCommand(param *sshParam, config *ssh.ClientConfig) (SshClient, error) {
@@ -611,32 +632,28 @@ func connectViaProxyCommand(param *sshParam, config *ssh.ClientConfig) (SshClien
 	if err != nil {
 		return nil, fmt.Errorf("proxy command [%s] exec failed: %v", cmd, err)
 	}
-	ncc, chans, reqs, err := ssh.NewClientConn(conn, param.addr, config)
+	ncc, chans, reqs, err := ssh.NewClientConn(conn, param.hostKeyAddr(), config)
 	if err != nil {
 		return nil, fmt.Errorf("proxy command [%s] new conn [%s] failed: %v", cmd, param.addr, err)
 	}
 	debug("login to [%s] via proxy command [%s] success", param.args.Destination, cmd)
-	return sshNewClient(ncc, chans, reqs), nil
+	return sshNewClient(ncc, chans, handleHostKeysRequests(param, ncc, reqs)), nil
 }
 
 func connectDirectly(param *sshParam, config *ssh.ClientConfig) (SshClient, error) {
 	debug("login to [%s] addr: %s", param.args.Destination, param.addr)
-	var dialer net.Dialer
-	if config.Timeout > 0 {
-		dialer.Timeout = config.Timeout
-	}
 	network, _, _ := getNetworkAddressFamily(param.args)
-	conn, err := dialer.Dial(network, param.addr)
+	conn, err := dialWithAttempts(param, config.Timeout)
 	if err != nil {
 		return nil, fmt.Errorf("login to [%s] dial [%s] [%s] failed: %v", param.args.Destination, network, param.addr, err)
 	}
 	param.setNetworkAddressFamily(conn)
-	ncc, chans, reqs, err := ssh.NewClientConn(&connWithTimeout{conn, config.Timeout, true}, param.addr, config)
+	ncc, chans, reqs, err := ssh.NewClientConn(&connWithTimeout{conn, config.Timeout, true}, param.hostKeyAddr(), config)
 	if err != nil {
 		return nil, fmt.Errorf("login to [%s] new conn [%s] failed: %v", param.args.Destination, param.addr, err)
 	}
 	debug("login to [%s] success", param.args.Destination)
-	return sshNewClient(ncc, chans, reqs), nil
+	return sshNewClient(ncc, chans, handleHostKeysRequests(param, ncc, reqs)), nil
 }
 
 func tcpLogin(param *sshParam, proxy *proxyJump, requireUDP udpModeType) (SshClient, error) {
@@ -718,6 +735,10 @@ func sshLogin(param *sshParam, proxy *proxyJump, requireUDP udpModeType) (SshCli
 	resetLogLevel := setupLogLevel(param.args)
 	defer resetLogLevel()
 
+	// setup batch mode of this hop
+	resetBatchMode := setupBatchMode(param.args)
+	defer resetBatchMode()
+
 	// tcp login
 	tcpClient, err := tcpLogin(param, proxy, requireUDP)
 	if err != nil {
@@ -731,9 +752,10 @@ func sshLogin(param *sshParam, proxy *proxyJump, requireUDP udpModeType) (SshCli
 	return udpLogin(param, tcpClient)
 }
 
-func keepAlive(sshConn *sshConnection) {
+// keepAlive sends keep alive requests until done is closed, and calls onTimeout if the server does not respond.
+func keepAlive(param *sshParam, client SshClient, done <-chan struct{}, onTimeout func(cause string)) {
 	serverAliveInterval := uint32(0)
-	if c := getOptionConfig(sshConn.param.args, "ServerAliveInterval"); c != "" {
+	if c := getOptionConfig(param.args, "ServerAliveInterval"); c != "" {
 		v, err := strconv.ParseUint(c, 10, 32)
 		if err != nil {
 			warning("ServerAliveInterval [%s] is invalid: %v", c, err)
@@ -742,12 +764,12 @@ func keepAlive(sshConn *sshConnection) {
 		}
 	}
 	if serverAliveInterval == 0 {
-		debug("no keep alive for [%s]", sshConn.param.args.Destination)
+		debug("no keep alive for [%s]", param.args.Destination)
 		return
 	}
 
 	serverAliveCountMax := uint32(3)
-	if c := getOptionConfig(sshConn.param.args, "ServerAliveCountMax"); c != "" {
+	if c := getOptionConfig(param.args, "ServerAliveCountMax"); c != "" {
 		v, err := strconv.ParseUint(c, 10, 32)
 		if err != nil {
 			warning("ServerAliveCountMax [%s] is invalid: %v", c, err)
@@ -760,12 +782,12 @@ func keepAlive(sshConn *sshConnection) {
 
 	sendKeepAlive := func(idx int) {
 		if enableDebugLogging {
-			writeDebugLog(time.Now().UnixMilli(), sshConn.param.args.Destination, fmt.Sprintf("keep alive [%d] sending", idx))
+			writeDebugLog(time.Now().UnixMilli(), param.args.Destination, fmt.Sprintf("keep alive [%d] sending", idx))
 		}
 
 		beginMilli := time.Now().UnixMilli()
 
-		if _, _, err := sshConn.client.SendRequest("keepalive@openssh.com", true, nil); err != nil {
+		if _, _, err := client.SendRequest("keepalive@openssh.com", true, nil); err != nil {
 			if !tsshd.IsClosedError(err) {
 				debug("keep alive [%d] failed: %v", idx, err)
 			}
@@ -773,11 +795,20 @@ func keepAlive(sshConn *sshConnection) {
 		}
 
 		if showRTT {
-			setTerminalTitle(fmt.Sprintf("%s %dms", sshConn.param.args.Destination, time.Now().UnixMilli()-beginMilli))
+			setTerminalTitle(fmt.Sprintf("%s %dms", param.args.Destination, time.Now().UnixMilli()-beginMilli))
 		}
 
 		if enableDebugLogging {
-			writeDebugLog(time.Now().UnixMilli(), sshConn.param.args.Destination, fmt.Sprintf("keep alive [%d] success", idx))
+			writeDebugLog(time.Now().UnixMilli(), param.args.Destination, fmt.Sprintf("keep alive [%d] success", idx))
+		}
+	}
+
+	sleep := func(d time.Duration) bool {
+		select {
+		case <-done:
+			return false
+		case <-time.After(d):
+			return true
 		}
 	}
 
@@ -790,7 +821,9 @@ func keepAlive(sshConn *sshConnection) {
 		for {
 			sleepTime := lastServerAliveTime.Load() + intervalTime - time.Now().UnixMilli()
 			if sleepTime > 0 {
-				time.Sleep(time.Duration(sleepTime) * time.Millisecond)
+				if !sleep(time.Duration(sleepTime) * time.Millisecond) {
+					return
+				}
 				continue
 			}
 
@@ -798,17 +831,26 @@ func keepAlive(sshConn *sshConnection) {
 			go sendKeepAlive(n)
 
 			ticker := time.NewTicker(time.Duration(intervalTime) * time.Millisecond)
-			for range ticker.C {
+			for {
+				select {
+				case <-done:
+					ticker.Stop()
+					return
+				case <-ticker.C:
+				}
+
 				sleepTime = lastServerAliveTime.Load() + intervalTime - time.Now().UnixMilli()
 				if sleepTime > 0 {
 					ticker.Stop()
-					time.Sleep(time.Duration(sleepTime) * time.Millisecond)
+					if !sleep(time.Duration(sleepTime) * time.Millisecond) {
+						return
+					}
 					break
 				}
 
 				if aliveTimeout > 0 && time.Now().UnixMilli()-lastServerAliveTime.Load() > aliveTimeout {
 					ticker.Stop()
-					sshConn.forceExit(kExitCodeKeepAlive, fmt.Sprintf(
+					onTimeout(fmt.Sprintf(
 						"keep-alive timeout [%ds], ServerAliveInterval [%d], ServerAliveCountMax [%d]",
 						aliveTimeout/1000, serverAliveInterval, serverAliveCountMax))
 					return
@@ -839,6 +881,9 @@ func sshConnect(args *sshArgs) (*sshConnection, error) {
 	// init log level
 	_ = setupLogLevel(args)
 
+	// disable interactive prompts if necessary
+	_ = setupBatchMode(args)
+
 	// parse cmd and tty
 	cmd, tty, err := parseCmdAndTTY(param)
 	if err != nil {
@@ -850,6 +895,17 @@ func sshConnect(args *sshArgs) (*sshConnection, error) {
 		tty = true
 	}
 
+	// login on the first forwarded connection
+	if isLazyForward(param) {
+		return &sshConnection{
+			exitChan: make(chan int, 1),
+			client:   newLazySshClient(param),
+			param:    param,
+			cmd:      cmd,
+			tty:      tty,
+		}, nil
+	}
+
 	// ssh login
 	client, err := sshLogin(param, nil, kUdpModeNo)
 	if err != nil {
@@ -872,7 +928,7 @@ func sshConnect(args *sshArgs) (*sshConnection, error) {
 
 	// tcp keep alive
 	if !param.control && param.udpMode == kUdpModeNo {
-		keepAlive(sshConn)
+		keepAlive(param, client, nil, func(cause string) { sshConn.forceExit(kExitCodeKeepAlive, cause) })
 	}
 
 	//  cleanup
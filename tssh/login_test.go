@@ -28,6 +28,7 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
 )
 
 func TestParseDestination(t *testing.T) {
@@ -68,3 +69,44 @@ func TestParseDestination(t *testing.T) {
 	assertDestEqual("[fe80::6358:bbae:26f8:7859]:1022", "", "fe80::6358:bbae:26f8:7859", "1022")
 	assertDestEqual("user@[fe80::6358:bbae:26f8:7859]:1022", "user", "fe80::6358:bbae:26f8:7859", "1022")
 }
+
+func TestPreferredAuthentications(t *testing.T) {
+	assert := assert.New(t)
+	oriEnableWarning, oriUserConfig := enableWarningLogging, userConfig
+	enableWarningLogging, userConfig = false, &tsshConfig{}
+	defer func() { enableWarningLogging, userConfig = oriEnableWarning, oriUserConfig }()
+
+	newArgs := func(options ...string) *sshArgs {
+		args := &sshArgs{Destination: "host"}
+		for _, option := range options {
+			assert.Nil(args.Option.UnmarshalText([]byte(option)))
+		}
+		return args
+	}
+
+	assert.Equal(kDefaultPreferredAuthentications, getPreferredAuthentications(newArgs()))
+	assert.Equal([]string{"keyboard-interactive", "publickey"},
+		getPreferredAuthentications(newArgs("PreferredAuthentications=keyboard-interactive, PublicKey,hostbased,publickey")))
+
+	assert.Equal(3, getNumberOfPasswordPrompts(newArgs()))
+	assert.Equal(0, getNumberOfPasswordPrompts(newArgs("NumberOfPasswordPrompts=0")))
+	assert.Equal(5, getNumberOfPasswordPrompts(newArgs("NumberOfPasswordPrompts=5")))
+	assert.Equal(3, getNumberOfPasswordPrompts(newArgs("NumberOfPasswordPrompts=-1")))
+
+	keyboard := ssh.RetryableAuthMethod(ssh.Password("kbd"), 1)
+	publickey := ssh.RetryableAuthMethod(ssh.Password("key"), 1)
+	password := ssh.RetryableAuthMethod(ssh.Password("pwd"), 1)
+	callback := getAuthCallback(&sshParam{args: newArgs()}, []*sshAuthMethod{
+		{"keyboard-interactive", keyboard}, {"publickey", publickey}, {"password", password}})
+
+	next := func(allowed, partial, tried []string) ssh.AuthMethod {
+		t.Helper()
+		method, err := callback(&ssh.ClientAuthContext{AllowedMethods: allowed, PartialSuccessMethods: partial, TriedMethods: tried})
+		assert.Nil(err)
+		return method
+	}
+	assert.Same(keyboard, next([]string{"publickey", "password", "keyboard-interactive"}, nil, []string{"none"}))
+	assert.Same(publickey, next([]string{"publickey", "password"}, nil, []string{"none", "keyboard-interactive"}))
+	assert.Same(keyboard, next([]string{"keyboard-interactive"}, []string{"publickey"}, []string{"none"}))
+	assert.Nil(next([]string{"hostbased"}, nil, []string{"none"}))
+}
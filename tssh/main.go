@@ -224,6 +224,29 @@ func addOnExitFunc(f func()) {
 	onExitFuncs = append(onExitFuncs, f)
 }
 
+func countOnExitFuncs() int {
+	onExitMutex.Lock()
+	defer onExitMutex.Unlock()
+	return len(onExitFuncs)
+}
+
+// takeOnExitFuncs removes the funcs added after the first count ones,
+// and returns a func to run them in the same order as cleanupOnExit.
+func takeOnExitFuncs(count int) func() {
+	onExitMutex.Lock()
+	defer onExitMutex.Unlock()
+	if count >= len(onExitFuncs) {
+		return func() {}
+	}
+	funcs := append([]func(){}, onExitFuncs[count:]...)
+	onExitFuncs = onExitFuncs[:count]
+	return func() {
+		for i := len(funcs) - 1; i >= 0; i-- {
+			funcs[i]()
+		}
+	}
+}
+
 var onCloseFuncs []func()
 var onCloseMutex sync.Mutex
 
@@ -351,16 +374,13 @@ func TsshMain(argv []string) int {
 		return 0
 	}
 
+	args.Destination = dest
+	args.originalDest = dest
+
 	// custom DNS server
-	if args.DNS != "" {
-		setDNS(args.DNS)
-	} else if userConfig.customDnsServer != "" {
-		setDNS(userConfig.customDnsServer)
-	}
+	setupCustomDNS(&args)
 
 	// start ssh program
-	args.Destination = dest
-	args.originalDest = dest
 	var code int
 	code, err = sshStart(&args)
 	return code
@@ -377,8 +397,10 @@ func sshStart(args *sshArgs) (int, error) {
 		sshConn.Close()
 	}()
 
-	// execute local command if necessary
-	execLocalCommand(sshConn.param)
+	// execute local command if necessary, the lazy forwarding executes it after login
+	if _, lazy := sshConn.client.(*lazySshClient); !lazy {
+		execLocalCommand(sshConn.param)
+	}
 
 	// handle signals
 	handleExitSignals(sshConn)
@@ -438,6 +460,9 @@ func sshStart(args *sshArgs) (int, error) {
 		warning("waypipe may not be working properly: %v", err)
 	}
 
+	// set the envs rejected by the server through the remote command
+	sshConn.cmd = getRemoteEnvCommand(sshConn.cmd, sshConn.remoteEnvs)
+
 	// run command or start shell
 	if sess, ok := sshConn.session.(*tsshd.SshUdpSession); ok && udpAttachSessionID > 0 {
 		if err := sess.Attach(udpAttachSessionID); err != nil {
@@ -556,6 +581,21 @@ func openSession(sshConn *sshConnection) (err error) {
 		return fmt.Errorf("stderr pipe for [%s] failed: %v", sshConn.param.args.Destination, err)
 	}
 
+	// open remote urls in the local browser
+	browserEnvs, err := enableRemoteBrowser(sshConn)
+	if err != nil {
+		warning("remote browser may not be working properly: %v", err)
+	}
+
+	// forward audio to the local pulse server
+	audioEnvs, err := enableAudioForward(sshConn)
+	if err != nil {
+		warning("audio forwarding may not be working properly: %v", err)
+	}
+
+	// set the envs of remote browser and audio forwarding, keep the rejected ones for the remote command
+	sshConn.remoteEnvs = setRemoteEnvs(sshConn.session, append(browserEnvs, audioEnvs...))
+
 	// send and set env
 	term, err := sendAndSetEnv(sshConn.param.args, sshConn.session)
 	if err != nil {
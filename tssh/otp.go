@@ -26,11 +26,21 @@ package tssh
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/pquerna/otp/totp"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/hotp"
 )
 
 func getOtpCommandOutput(command, question string) string {
@@ -65,10 +75,143 @@ func getOtpCommandOutput(command, question string) string {
 	return strings.TrimSpace(outBuf.String())
 }
 
-func getTotpCode(secret string) string {
-	code, err := totp.GenerateCode(secret, time.Now())
+// kOtpMinRemainingTime is the minimum remaining time of a totp code, otherwise wait for the next window.
+const kOtpMinRemainingTime = 3 * time.Second
+
+var hotpCounterMutex sync.Mutex
+
+// otpSecret is a totp or hotp secret with its parameters, parsed from a base32 secret or an otpauth:// URI.
+type otpSecret struct {
+	hotp    bool
+	secret  string
+	period  uint64
+	counter uint64
+	opts    hotp.ValidateOpts
+}
+
+func parseOtpSecret(secret string) (*otpSecret, error) {
+	if !strings.HasPrefix(strings.ToLower(secret), "otpauth://") {
+		return &otpSecret{secret: secret, period: 30, opts: hotp.ValidateOpts{Digits: otp.DigitsSix, Algorithm: otp.AlgorithmSHA1}}, nil
+	}
+	key, err := otp.NewKeyFromURL(secret)
+	if err != nil {
+		return nil, err
+	}
+	if key.Secret() == "" {
+		return nil, fmt.Errorf("no secret in the otpauth uri")
+	}
+	if digits := key.Digits(); digits < 6 || digits > 8 {
+		return nil, fmt.Errorf("unsupported digits [%d] in the otpauth uri", digits)
+	}
+	s := &otpSecret{secret: key.Secret(), opts: hotp.ValidateOpts{Digits: key.Digits(), Algorithm: key.Algorithm(), Encoder: key.Encoder()}}
+	switch strings.ToLower(key.Type()) {
+	case "totp":
+		if s.period = key.Period(); s.period == 0 {
+			return nil, fmt.Errorf("invalid period in the otpauth uri")
+		}
+	case "hotp":
+		s.hotp = true
+		u, _ := url.Parse(key.URL())
+		if counter := u.Query().Get("counter"); counter != "" {
+			if s.counter, err = strconv.ParseUint(counter, 10, 64); err != nil {
+				return nil, fmt.Errorf("invalid counter [%s] in the otpauth uri", counter)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unknown otp type [%s] in the otpauth uri", key.Type())
+	}
+	return s, nil
+}
+
+func getHotpCounterPath() string {
+	return filepath.Join(userHomeDir, ".ssh", "tssh_hotp_counters")
+}
+
+// nextHotpCounter returns the counter to use and saves the next one, so each hotp code is used only once.
+// The counters are saved by the hash of the secret, initialized from the counter in the otpauth uri.
+// The lock file guards the counters against the other tssh processes.
+func nextHotpCounter(secret string, initial uint64) (uint64, error) {
+	hotpCounterMutex.Lock()
+	defer hotpCounterMutex.Unlock()
+
+	sum := sha256.Sum256([]byte(secret))
+	id := hex.EncodeToString(sum[:8])
+	path := getHotpCounterPath()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return 0, err
+	}
+	lock, err := os.OpenFile(path+".lock", os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = lock.Close() }()
+	if err := lockFile(lock); err != nil {
+		return 0, fmt.Errorf("lock [%s] failed: %v", lock.Name(), err)
+	}
+	defer func() { _ = unlockFile(lock) }()
+
+	content, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return 0, err
+	}
+	counter := initial
+	var lines []string
+	for line := range strings.Lines(string(content)) {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == id {
+			if counter, err = strconv.ParseUint(fields[1], 10, 64); err != nil {
+				return 0, fmt.Errorf("invalid hotp counter [%s] in [%s]", fields[1], path)
+			}
+			continue
+		}
+		if len(fields) > 0 {
+			lines = append(lines, strings.TrimRight(line, "\r\n"))
+		}
+	}
+	lines = append(lines, fmt.Sprintf("%s %d", id, counter+1))
+
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		_ = os.Remove(tmpPath)
+		return 0, err
+	}
+	return counter, nil
+}
+
+// getOtpCode generates a totp or hotp code. For totp, if the current code is about to expire,
+// waits for the next time window, so the code will not expire before being verified.
+func getOtpCode(secret string) string {
+	s, err := parseOtpSecret(secret)
+	if err != nil {
+		warning("parse otp secret failed: %v", err)
+		return ""
+	}
+
+	counter := s.counter
+	if s.hotp {
+		if counter, err = nextHotpCounter(s.secret, s.counter); err != nil {
+			warning("get hotp counter failed: %v", err)
+			return ""
+		}
+		debug("generate hotp code with counter %d", counter)
+	} else {
+		period := time.Duration(s.period) * time.Second
+		now := time.Now()
+		if remaining := period - time.Duration(now.UnixNano())%period; remaining < min(kOtpMinRemainingTime, period/3) {
+			debug("totp code expires in %v, wait for the next time window", remaining)
+			time.Sleep(remaining)
+			now = now.Add(remaining)
+		}
+		counter = uint64(now.Unix()) / s.period
+	}
+
+	code, err := hotp.GenerateCodeCustom(s.secret, counter, s.opts)
 	if err != nil {
-		warning("generate totp code failed: %v", err)
+		warning("generate otp code failed: %v", err)
 		return ""
 	}
 	return code
@@ -0,0 +1,147 @@
+/*
+MIT License
+
+Copyright (c) 2023-2026 The Trzsz SSH Authors.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package tssh
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// the rfc 4226 test secret "12345678901234567890" in base32
+const kTestOtpSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestParseOtpSecret(t *testing.T) {
+	assert := assert.New(t)
+
+	s, err := parseOtpSecret(kTestOtpSecret)
+	require.NoError(t, err)
+	assert.False(s.hotp)
+	assert.Equal(uint64(30), s.period)
+	assert.Equal(otp.DigitsSix, s.opts.Digits)
+	assert.Equal(otp.AlgorithmSHA1, s.opts.Algorithm)
+
+	s, err = parseOtpSecret("otpauth://totp/corp:alice?secret=" + kTestOtpSecret + "&algorithm=SHA256&digits=8&period=60")
+	require.NoError(t, err)
+	assert.False(s.hotp)
+	assert.Equal(kTestOtpSecret, s.secret)
+	assert.Equal(uint64(60), s.period)
+	assert.Equal(otp.DigitsEight, s.opts.Digits)
+	assert.Equal(otp.AlgorithmSHA256, s.opts.Algorithm)
+
+	s, err = parseOtpSecret("OTPAUTH://hotp/legacy?secret=" + kTestOtpSecret + "&algorithm=sha512&counter=5")
+	require.NoError(t, err)
+	assert.True(s.hotp)
+	assert.Equal(uint64(5), s.counter)
+	assert.Equal(otp.AlgorithmSHA512, s.opts.Algorithm)
+
+	for _, uri := range []string{
+		"otpauth://totp/corp?algorithm=SHA256",
+		"otpauth://totp/corp?secret=" + kTestOtpSecret + "&digits=10",
+		"otpauth://totp/corp?secret=" + kTestOtpSecret + "&period=0",
+		"otpauth://hotp/corp?secret=" + kTestOtpSecret + "&counter=x",
+		"otpauth://motp/corp?secret=" + kTestOtpSecret,
+	} {
+		_, err := parseOtpSecret(uri)
+		assert.Error(err, uri)
+	}
+}
+
+func TestGetOtpCode(t *testing.T) {
+	assert := assert.New(t)
+	oriEnableWarning, oriUserHomeDir := enableWarningLogging, userHomeDir
+	enableWarningLogging, userHomeDir = false, t.TempDir()
+	defer func() { enableWarningLogging, userHomeDir = oriEnableWarning, oriUserHomeDir }()
+
+	// the rfc 4226 test vectors
+	uri := "otpauth://hotp/legacy?secret=" + kTestOtpSecret
+	assert.Equal("755224", getOtpCode(uri))
+	assert.Equal("287082", getOtpCode(uri))
+	assert.Equal("359152", getOtpCode(uri))
+	assert.Len(getOtpCode("otpauth://hotp/other?secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJR&counter=9"), 6)
+	content, err := os.ReadFile(getHotpCounterPath())
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	require.Len(t, lines, 2)
+	assert.True(strings.HasSuffix(lines[0], " 3"))
+	assert.True(strings.HasSuffix(lines[1], " 10"))
+
+	code := getOtpCode("otpauth://totp/corp?secret=" + kTestOtpSecret + "&algorithm=SHA256&digits=8")
+	now := time.Now()
+	assert.Len(code, 8)
+	opts := totp.ValidateOpts{Digits: otp.DigitsEight, Algorithm: otp.AlgorithmSHA256}
+	expected, err := totp.GenerateCodeCustom(kTestOtpSecret, now, opts)
+	require.NoError(t, err)
+	previous, err := totp.GenerateCodeCustom(kTestOtpSecret, now.Add(-30*time.Second), opts)
+	require.NoError(t, err)
+	assert.Contains([]string{expected, previous}, code)
+
+	assert.Equal("", getOtpCode("otpauth://totp/corp?digits=8"))
+}
+
+func TestHotpCounterLock(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	oriUserHomeDir := userHomeDir
+	userHomeDir = t.TempDir()
+	defer func() { userHomeDir = oriUserHomeDir }()
+
+	// another tssh process holds the lock
+	require.NoError(os.MkdirAll(filepath.Dir(getHotpCounterPath()), 0700))
+	lock, err := os.OpenFile(getHotpCounterPath()+".lock", os.O_RDWR|os.O_CREATE, 0600)
+	require.NoError(err)
+	defer func() { _ = lock.Close() }()
+	require.NoError(lockFile(lock))
+
+	done := make(chan uint64, 1)
+	go func() {
+		counter, err := nextHotpCounter(kTestOtpSecret, 5)
+		assert.NoError(err)
+		done <- counter
+	}()
+	select {
+	case <-done:
+		assert.Fail("the hotp counter is updated while the lock is held")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	require.NoError(unlockFile(lock))
+	select {
+	case counter := <-done:
+		assert.Equal(uint64(5), counter)
+	case <-time.After(5 * time.Second):
+		assert.Fail("the hotp counter is not updated after the lock is released")
+	}
+	counter, err := nextHotpCounter(kTestOtpSecret, 5)
+	require.NoError(err)
+	assert.Equal(uint64(6), counter)
+}
@@ -0,0 +1,398 @@
+/*
+MIT License
+
+Copyright (c) 2023-2026 The Trzsz SSH Authors.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package tssh
+
+import (
+	"bufio"
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"encoding/asn1"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	kAgentFailure            = 5  // SSH_AGENT_FAILURE
+	kAgentSuccess            = 6  // SSH_AGENT_SUCCESS
+	kAgentIdentitiesAnswer   = 12 // SSH2_AGENT_IDENTITIES_ANSWER
+	kAgentSignRequest        = 13 // SSH2_AGENTC_SIGN_REQUEST
+	kAgentSignResponse       = 14 // SSH2_AGENT_SIGN_RESPONSE
+	kAgentAddSmartcardKey    = 20 // SSH_AGENTC_ADD_SMARTCARD_KEY
+	kAgentRemoveSmartcardKey = 21 // SSH_AGENTC_REMOVE_SMARTCARD_KEY
+)
+
+// DER encoded DigestInfo prefixes, the helper does raw PKCS#1 v1.5 signing for RSA keys.
+var kPkcs11RsaHashPrefixes = map[crypto.Hash][]byte{
+	crypto.SHA1:   {0x30, 0x21, 0x30, 0x09, 0x06, 0x05, 0x2b, 0x0e, 0x03, 0x02, 0x1a, 0x05, 0x00, 0x04, 0x14},
+	crypto.SHA256: {0x30, 0x31, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x01, 0x05, 0x00, 0x04, 0x20},
+	crypto.SHA512: {0x30, 0x51, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x03, 0x05, 0x00, 0x04, 0x40},
+}
+
+type pkcs11Provider struct {
+	mutex      sync.Mutex
+	path       string
+	param      *sshParam
+	reader     io.Reader
+	writer     io.Writer
+	closer     func()
+	registered bool
+	loggedIn   bool
+}
+
+type pkcs11Signer struct {
+	provider *pkcs11Provider
+	pubKey   ssh.PublicKey
+	blob     []byte
+	label    string
+}
+
+func (p *pkcs11Provider) request(req []byte) ([]byte, error) {
+	if err := writeMessage(p.writer, req); err != nil {
+		return nil, fmt.Errorf("write request failed: %v", err)
+	}
+	resp, err := readMessage(p.reader)
+	if err != nil {
+		return nil, fmt.Errorf("read response failed: %v", err)
+	}
+	if len(resp) == 0 {
+		return nil, fmt.Errorf("empty response")
+	}
+	return resp, nil
+}
+
+func (p *pkcs11Provider) addProvider(pin string) ([]*pkcs11Signer, error) {
+	req := ssh.Marshal(struct {
+		Type     uint8
+		Provider string
+		Pin      string
+	}{kAgentAddSmartcardKey, p.path, pin})
+	resp, err := p.request(req)
+	if err != nil {
+		return nil, err
+	}
+	switch resp[0] {
+	case kAgentIdentitiesAnswer:
+	case kAgentFailure:
+		return nil, fmt.Errorf("ssh-pkcs11-helper failed to load provider")
+	default:
+		return nil, fmt.Errorf("unexpected ssh-pkcs11-helper response type: %d", resp[0])
+	}
+
+	p.registered = true
+	var answer struct {
+		Count uint32
+		Rest  []byte `ssh:"rest"`
+	}
+	if err := ssh.Unmarshal(resp[1:], &answer); err != nil {
+		return nil, fmt.Errorf("unmarshal identities answer failed: %v", err)
+	}
+	var signers []*pkcs11Signer
+	rest := answer.Rest
+	for range answer.Count {
+		var key struct {
+			Blob  []byte
+			Label string
+			Rest  []byte `ssh:"rest"`
+		}
+		if err := ssh.Unmarshal(rest, &key); err != nil {
+			return nil, fmt.Errorf("unmarshal identity failed: %v", err)
+		}
+		rest = key.Rest
+		pubKey, err := ssh.ParsePublicKey(key.Blob)
+		if err != nil {
+			debug("pkcs11 key [%s] parse failed: %v", key.Label, err)
+			continue
+		}
+		if keyType := pubKey.Type(); keyType != ssh.KeyAlgoRSA && !strings.HasPrefix(keyType, "ecdsa-sha2-") {
+			debug("pkcs11 key [%s] type [%s] is not supported", key.Label, keyType)
+			continue
+		}
+		signers = append(signers, &pkcs11Signer{provider: p, pubKey: pubKey, blob: key.Blob, label: key.Label})
+	}
+	return signers, nil
+}
+
+func (p *pkcs11Provider) removeProvider() error {
+	req := ssh.Marshal(struct {
+		Type     uint8
+		Provider string
+		Pin      string
+	}{kAgentRemoveSmartcardKey, p.path, ""})
+	resp, err := p.request(req)
+	if err != nil {
+		return err
+	}
+	if resp[0] != kAgentSuccess {
+		return fmt.Errorf("ssh-pkcs11-helper failed to remove provider")
+	}
+	p.registered = false
+	return nil
+}
+
+func (p *pkcs11Provider) login() error {
+	for range 3 {
+		secret, err := readSecret(fmt.Sprintf("Enter PIN for PKCS#11 provider %s: ", p.path))
+		if err != nil {
+			return fmt.Errorf("read pin failed: %v", err)
+		}
+		if p.registered {
+			if err := p.removeProvider(); err != nil {
+				return err
+			}
+		}
+		if _, err := p.addProvider(string(secret)); err != nil {
+			debug("pkcs11 login with pin failed: %v", err)
+			continue
+		}
+		p.loggedIn = true
+		return nil
+	}
+	return fmt.Errorf("PIN incorrect")
+}
+
+func (p *pkcs11Provider) sign(blob, data []byte) ([]byte, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	req := ssh.Marshal(struct {
+		Type  uint8
+		Blob  []byte
+		Data  []byte
+		Flags uint32
+	}{kAgentSignRequest, blob, data, 0})
+
+	for {
+		resp, err := p.request(req)
+		if err != nil {
+			return nil, err
+		}
+		switch resp[0] {
+		case kAgentSignResponse:
+			var sign struct {
+				Signature []byte
+			}
+			if err := ssh.Unmarshal(resp[1:], &sign); err != nil {
+				return nil, fmt.Errorf("unmarshal sign response failed: %v", err)
+			}
+			return sign.Signature, nil
+		case kAgentFailure:
+			if p.loggedIn {
+				return nil, fmt.Errorf("ssh-pkcs11-helper failed to sign")
+			}
+			// the token may require login before signing, ask for the PIN and retry once.
+			if err := p.login(); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("unexpected ssh-pkcs11-helper response type: %d", resp[0])
+		}
+	}
+}
+
+func (p *pkcs11Provider) close() {
+	if p.closer != nil {
+		p.closer()
+	}
+}
+
+func (s *pkcs11Signer) PublicKey() ssh.PublicKey {
+	return s.pubKey
+}
+
+func (s *pkcs11Signer) getPath() string {
+	return "pkcs11:" + s.label
+}
+
+func (s *pkcs11Signer) Sign(rand io.Reader, data []byte) (*ssh.Signature, error) {
+	return s.SignWithAlgorithm(rand, data, "")
+}
+
+func (s *pkcs11Signer) SignWithAlgorithm(rand io.Reader, data []byte, algorithm string) (*ssh.Signature, error) {
+	if s.pubKey.Type() == ssh.KeyAlgoRSA {
+		if algorithm == "" {
+			algorithm = ssh.KeyAlgoRSA
+		}
+		var hash crypto.Hash
+		switch algorithm {
+		case ssh.KeyAlgoRSA:
+			hash = crypto.SHA1
+		case ssh.KeyAlgoRSASHA256:
+			hash = crypto.SHA256
+		case ssh.KeyAlgoRSASHA512:
+			hash = crypto.SHA512
+		default:
+			return nil, fmt.Errorf("unsupported algorithm [%s] for pkcs11 rsa key", algorithm)
+		}
+		h := hash.New()
+		h.Write(data)
+		digest := append(bytes.Clone(kPkcs11RsaHashPrefixes[hash]), h.Sum(nil)...)
+		blob, err := s.provider.sign(s.blob, digest)
+		if err != nil {
+			return nil, err
+		}
+		return &ssh.Signature{Format: algorithm, Blob: blob}, nil
+	}
+
+	if algorithm != "" && algorithm != s.pubKey.Type() {
+		return nil, fmt.Errorf("unsupported algorithm [%s] for pkcs11 key type [%s]", algorithm, s.pubKey.Type())
+	}
+	cryptoPubKey, ok := s.pubKey.(ssh.CryptoPublicKey)
+	if !ok {
+		return nil, fmt.Errorf("unsupported pkcs11 key type [%s]", s.pubKey.Type())
+	}
+	ecdsaPubKey, ok := cryptoPubKey.CryptoPublicKey().(*ecdsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("unsupported pkcs11 key type [%s]", s.pubKey.Type())
+	}
+	var hash crypto.Hash
+	switch ecdsaPubKey.Curve {
+	case elliptic.P256():
+		hash = crypto.SHA256
+	case elliptic.P384():
+		hash = crypto.SHA384
+	case elliptic.P521():
+		hash = crypto.SHA512
+	default:
+		return nil, fmt.Errorf("unsupported pkcs11 ecdsa curve [%s]", ecdsaPubKey.Curve.Params().Name)
+	}
+	h := hash.New()
+	h.Write(data)
+	der, err := s.provider.sign(s.blob, h.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	var sig struct {
+		R, S *big.Int
+	}
+	if _, err := asn1.Unmarshal(der, &sig); err != nil {
+		return nil, fmt.Errorf("unmarshal pkcs11 ecdsa signature failed: %v", err)
+	}
+	return &ssh.Signature{Format: s.pubKey.Type(), Blob: ssh.Marshal(sig)}, nil
+}
+
+func startPkcs11Helper(path string) (*pkcs11Provider, error) {
+	helperPath := os.Getenv("SSH_PKCS11_HELPER")
+	if helperPath == "" {
+		helperPath = kDefaultSshPkcs11HelperPath
+	}
+	if !isFileExist(helperPath) {
+		return nil, fmt.Errorf("ssh-pkcs11-helper not found: %s", helperPath)
+	}
+
+	debug("starting ssh-pkcs11-helper: %s", helperPath)
+	cmd := exec.Command(helperPath)
+	if enableDebugLogging {
+		cmd.Args = append(cmd.Args, "-vvv")
+	}
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("stdin pipe failed: %v", err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("stdout pipe failed: %v", err)
+	}
+	if enableDebugLogging {
+		stderr, err := cmd.StderrPipe()
+		if err != nil {
+			return nil, fmt.Errorf("stderr pipe failed: %v", err)
+		}
+		go func() {
+			scanner := bufio.NewScanner(stderr)
+			for scanner.Scan() {
+				debug("%s", scanner.Text())
+			}
+		}()
+	} else {
+		cmd.Stderr = os.Stderr
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("start %s failed: %v", helperPath, err)
+	}
+
+	var closeOnce sync.Once
+	return &pkcs11Provider{path: path, reader: stdout, writer: stdin, closer: func() {
+		closeOnce.Do(func() {
+			_ = stdin.Close()
+			_ = cmd.Wait()
+			debug("ssh-pkcs11-helper exited: %s", helperPath)
+		})
+	}}, nil
+}
+
+func loadPkcs11Signers(provider *pkcs11Provider) ([]sshSigner, error) {
+	pin := ""
+	if provider.param != nil {
+		pin = getSecretConfig(provider.param, "PKCS11Pin")
+	}
+	signers, err := provider.addProvider(pin)
+	if err != nil {
+		return nil, err
+	}
+	provider.loggedIn = pin != ""
+
+	var sshSigners []sshSigner
+	for _, signer := range signers {
+		sshSigners = append(sshSigners, newSshSigner(signer.getPath(), nil, signer.pubKey, signer))
+	}
+	return sshSigners, nil
+}
+
+func getPkcs11Signers(param *sshParam) []sshSigner {
+	path := getOptionConfig(param.args, "PKCS11Provider")
+	if path == "" || strings.EqualFold(path, "none") {
+		return nil
+	}
+	path = resolveHomeDir(path)
+
+	provider, err := startPkcs11Helper(path)
+	if err != nil {
+		warning("load PKCS11Provider [%s] failed: %v", path, err)
+		return nil
+	}
+	provider.param = param
+	addAfterLoginFunc(provider.close)
+	addOnExitFunc(provider.close)
+
+	signers, err := loadPkcs11Signers(provider)
+	if err != nil {
+		warning("load PKCS11Provider [%s] failed: %v", path, err)
+		provider.close()
+		return nil
+	}
+	if len(signers) == 0 {
+		debug("no usable keys in PKCS11Provider [%s]", path)
+	}
+	return signers
+}
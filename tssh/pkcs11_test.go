@@ -0,0 +1,184 @@
+/*
+MIT License
+
+Copyright (c) 2023-2026 The Trzsz SSH Authors.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package tssh
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+func runFakePkcs11Helper(conn net.Conn, pin string, keys map[string]crypto.Signer) {
+	defer func() { _ = conn.Close() }()
+	loggedIn := false
+	for {
+		req, err := readMessage(conn)
+		if err != nil || len(req) == 0 {
+			return
+		}
+		var resp []byte
+		switch req[0] {
+		case kAgentAddSmartcardKey:
+			var add struct {
+				Provider string
+				Pin      string
+			}
+			_ = ssh.Unmarshal(req[1:], &add)
+			if add.Pin != "" && add.Pin != pin {
+				resp = []byte{kAgentFailure}
+				break
+			}
+			loggedIn = add.Pin == pin
+			resp = ssh.Marshal(struct {
+				Type  uint8
+				Count uint32
+			}{kAgentIdentitiesAnswer, uint32(len(keys))})
+			for label, key := range keys {
+				pubKey, _ := ssh.NewPublicKey(key.Public())
+				resp = append(resp, ssh.Marshal(struct {
+					Blob  []byte
+					Label string
+				}{pubKey.Marshal(), label})...)
+			}
+		case kAgentRemoveSmartcardKey:
+			resp = []byte{kAgentSuccess}
+		case kAgentSignRequest:
+			var sign struct {
+				Blob  []byte
+				Data  []byte
+				Flags uint32
+			}
+			_ = ssh.Unmarshal(req[1:], &sign)
+			resp = []byte{kAgentFailure}
+			if !loggedIn {
+				break
+			}
+			for _, key := range keys {
+				pubKey, _ := ssh.NewPublicKey(key.Public())
+				if string(pubKey.Marshal()) != string(sign.Blob) {
+					continue
+				}
+				var signature []byte
+				switch k := key.(type) {
+				case *rsa.PrivateKey:
+					signature, _ = rsa.SignPKCS1v15(rand.Reader, k, crypto.Hash(0), sign.Data)
+				case *ecdsa.PrivateKey:
+					signature, _ = ecdsa.SignASN1(rand.Reader, k, sign.Data)
+				}
+				resp = ssh.Marshal(struct {
+					Type      uint8
+					Signature []byte
+				}{kAgentSignResponse, signature})
+			}
+		}
+		if err := writeMessage(conn, resp); err != nil {
+			return
+		}
+	}
+}
+
+func TestPkcs11Signers(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.Nil(err)
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	require.Nil(err)
+
+	client, server := net.Pipe()
+	go runFakePkcs11Helper(server, "", map[string]crypto.Signer{"rsa": rsaKey, "ecdsa": ecdsaKey})
+	provider := &pkcs11Provider{path: "/usr/lib/fake-pkcs11.so", reader: client, writer: client,
+		closer: func() { _ = client.Close() }}
+	defer provider.close()
+
+	signers, err := loadPkcs11Signers(provider)
+	require.Nil(err)
+	require.Len(signers, 2)
+
+	provider.loggedIn = true
+	data := []byte("session data")
+	for _, signer := range signers {
+		assert.Contains([]string{"pkcs11:rsa", "pkcs11:ecdsa"}, signer.getPath())
+		algorithms := []string{""}
+		if signer.PublicKey().Type() == ssh.KeyAlgoRSA {
+			algorithms = []string{ssh.KeyAlgoRSA, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSASHA512}
+		}
+		for _, algorithm := range algorithms {
+			var signature *ssh.Signature
+			if algorithm == "" {
+				signature, err = signer.Sign(rand.Reader, data)
+			} else {
+				signature, err = signer.(ssh.AlgorithmSigner).SignWithAlgorithm(rand.Reader, data, algorithm)
+			}
+			require.Nil(err)
+			assert.Nil(signer.PublicKey().Verify(data, signature))
+		}
+	}
+}
+
+func TestPkcs11Login(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("askpass script is not supported on windows")
+	}
+	assert := assert.New(t)
+	require := require.New(t)
+
+	askpass := filepath.Join(t.TempDir(), "askpass")
+	require.Nil(os.WriteFile(askpass, []byte("#!/bin/sh\necho 123456\n"), 0700))
+	t.Setenv("SSH_ASKPASS", askpass)
+	t.Setenv("SSH_ASKPASS_REQUIRE", "force")
+
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(err)
+
+	client, server := net.Pipe()
+	go runFakePkcs11Helper(server, "123456", map[string]crypto.Signer{"ecdsa": ecdsaKey})
+	provider := &pkcs11Provider{path: "/usr/lib/fake-pkcs11.so", reader: client, writer: client,
+		closer: func() { _ = client.Close() }}
+	defer provider.close()
+
+	signers, err := loadPkcs11Signers(provider)
+	require.Nil(err)
+	require.Len(signers, 1)
+	assert.False(provider.loggedIn)
+
+	data := []byte("session data")
+	signature, err := signers[0].Sign(rand.Reader, data)
+	require.Nil(err)
+	assert.True(provider.loggedIn)
+	assert.Nil(signers[0].PublicKey().Verify(data, signature))
+}
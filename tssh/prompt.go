@@ -694,8 +694,15 @@ func chooseOrPredictDest(args *sshArgs) (string, bool, error) {
 		if !isTerminal {
 			return "", false, fmt.Errorf("destination is required when running tssh in non-interactive mode")
 		}
+		if strings.EqualFold(getOptionConfig(args, "BatchMode"), "yes") {
+			return "", false, fmt.Errorf("destination is required when running tssh in batch mode")
+		}
 		return chooseAlias(args, "")
 	}
 
+	if strings.EqualFold(getOptionConfig(args, "BatchMode"), "yes") {
+		return args.Destination, false, nil
+	}
+
 	return predictDestination(args, args.Destination)
 }
@@ -0,0 +1,77 @@
+/*
+MIT License
+
+Copyright (c) 2023-2026 The Trzsz SSH Authors.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package tssh
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// writeRemoteFile writes the content to a file on the remote host, which only the user can access.
+func writeRemoteFile(client SshClient, path string, content []byte, mode os.FileMode) error {
+	session, err := client.NewSession()
+	if err != nil {
+		return fmt.Errorf("new session for writing [%s] failed: %v", path, err)
+	}
+	defer func() { _ = session.Close() }()
+
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		return fmt.Errorf("stdin pipe for writing [%s] failed: %v", path, err)
+	}
+	cmd := fmt.Sprintf("umask 077 && cat > '%s' && chmod %o '%s'", path, mode, path)
+	if err := session.Start(cmd); err != nil {
+		return fmt.Errorf("start [%s] failed: %v", cmd, err)
+	}
+	if err := writeAll(stdin, content); err != nil {
+		return fmt.Errorf("write remote file [%s] failed: %v", path, err)
+	}
+	_ = stdin.Close()
+	if err := session.Wait(); err != nil {
+		return fmt.Errorf("write remote file [%s] failed: %v", path, err)
+	}
+	return nil
+}
+
+// removeRemoteFilesOnClose removes the temporary files on the remote host before closing.
+func removeRemoteFilesOnClose(client SshClient, paths ...string) {
+	addOnCloseFunc(func() {
+		session, err := client.NewSession()
+		if err != nil {
+			debug("new session for removing %v failed: %v", paths, err)
+			return
+		}
+		defer func() { _ = session.Close() }()
+		var buf strings.Builder
+		buf.WriteString("rm -f")
+		for _, path := range paths {
+			fmt.Fprintf(&buf, " '%s'", path)
+		}
+		if err := session.Run(buf.String()); err != nil {
+			debug("remove remote files %v failed: %v", paths, err)
+		}
+	})
+}
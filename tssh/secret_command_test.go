@@ -115,3 +115,51 @@ Host tokenhost
 	// %n token in PasswordCommand should be expanded to the alias
 	assert.Equal("password-for-tokenhost", getSecretConfig(param("tokenhost"), "Password"))
 }
+
+func TestReadQuestionAnswerConfig(t *testing.T) {
+	assert := assert.New(t)
+	oriEnableWarning, oriUserConfig := enableWarningLogging, userConfig
+	enableWarningLogging, userConfig = false, &tsshConfig{}
+	defer func() { enableWarningLogging, userConfig = oriEnableWarning, oriUserConfig }()
+
+	config, err := ssh_config.DecodeBytes([]byte(`
+Host rulehost
+    QuestionPattern1 ^Challenge \d+ for \w+:
+    QuestionPatternOtp1 echo %q
+    QuestionPattern2 (?i)^password
+    QuestionPatternAnswer2Command echo rule-password
+    QuestionPattern3 [invalid
+    QuestionPattern4 (?i)verification code
+    QuestionPatternTotp4 otpauth://hotp/test?secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ&counter=1
+    QuestionPattern5 unanswered
+    QuestionPattern12 ^after the gap
+    QuestionPatternAnswer12 gap-answer
+    QuestionAnswer1 positional-answer
+    6f746865723a20 hex-answer
+`))
+	require.NoError(t, err)
+	userConfig.exConfig = &sshConfig{"", config}
+
+	oriUserHomeDir := userHomeDir
+	userHomeDir = t.TempDir()
+	defer func() { userHomeDir = oriUserHomeDir }()
+
+	param, err := getSshParam(&sshArgs{Destination: "rulehost"}, false)
+	require.NoError(t, err)
+
+	assert.Equal("Challenge 1234 for alice:", readQuestionAnswerConfig(param, 1, "Challenge 1234 for alice:"))
+	assert.Equal("rule-password", readQuestionAnswerConfig(param, 1, "Password: "))
+	assert.Equal("287082", readQuestionAnswerConfig(param, 1, "Verification code: "))
+	assert.Equal("positional-answer", readQuestionAnswerConfig(param, 1, "unanswered question: "))
+	assert.Equal("hex-answer", readQuestionAnswerConfig(param, 2, "other: "))
+	assert.Equal("", readQuestionAnswerConfig(param, 2, "nothing: "))
+	assert.Equal("gap-answer", readQuestionAnswerConfig(param, 2, "after the gap: "))
+
+	args := &sshArgs{Destination: "rulehost"}
+	assert.NoError(args.Option.UnmarshalText([]byte("QuestionPattern20=^option")))
+	assert.NoError(args.Option.UnmarshalText([]byte("QuestionPatternOtp20=echo option %q")))
+	param, err = getSshParam(args, false)
+	require.NoError(t, err)
+	assert.Equal([]int{1, 2, 3, 4, 5, 12, 20}, getExOptionIndexes(param.args, "QuestionPattern"))
+	assert.Equal("option option:", readQuestionAnswerConfig(param, 2, "option: "))
+}
@@ -0,0 +1,474 @@
+/*
+MIT License
+
+Copyright (c) 2023-2026 The Trzsz SSH Authors.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package tssh
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// kSecretMasterKeyPrefix is the version prefix of the secrets encoded with the master key.
+const kSecretMasterKeyPrefix = "v2:"
+
+const kMasterKeySize = 32
+
+const kDefaultMasterKeyCacheTimeout = 8 * time.Hour
+
+var (
+	masterKeyMutex  sync.Mutex
+	cachedMasterKey []byte
+)
+
+// masterKeyParams are the argon2id parameters of the passphrase mode, which are saved
+// together with the salt and a check value, so the passphrase can be verified.
+type masterKeyParams struct {
+	time    uint32
+	memory  uint32
+	threads uint8
+	salt    []byte
+	check   []byte
+}
+
+func getSecretMasterKeyMode() string {
+	if userConfig == nil {
+		return ""
+	}
+	return strings.ToLower(strings.TrimSpace(userConfig.secretMasterKey))
+}
+
+func getMasterKeyParamsPath() string {
+	return filepath.Join(userHomeDir, ".ssh", "tssh_master_key")
+}
+
+// getMasterKeyCheckPath returns the file which saves the check value of the keyring master key.
+func getMasterKeyCheckPath() string {
+	return filepath.Join(userHomeDir, ".ssh", "tssh_master_key_check")
+}
+
+func getMasterKeyCheck(key []byte) []byte {
+	sum := sha256.Sum256(append(bytes.Clone(key), "tssh master key check"...))
+	return sum[:8]
+}
+
+func (p *masterKeyParams) deriveKey(passphrase []byte) []byte {
+	return argon2.IDKey(passphrase, p.salt, p.time, p.memory, p.threads, kMasterKeySize)
+}
+
+func (p *masterKeyParams) String() string {
+	return fmt.Sprintf("argon2id %d %d %d %x %x", p.time, p.memory, p.threads, p.salt, p.check)
+}
+
+func parseMasterKeyParams(content string) (*masterKeyParams, error) {
+	fields := strings.Fields(content)
+	if len(fields) != 6 || fields[0] != "argon2id" {
+		return nil, fmt.Errorf("invalid format")
+	}
+	var nums [3]uint64
+	for i, bits := range []int{32, 32, 8} {
+		n, err := strconv.ParseUint(fields[i+1], 10, bits)
+		if err != nil {
+			return nil, err
+		}
+		nums[i] = n
+	}
+	salt, err := hex.DecodeString(fields[4])
+	if err != nil {
+		return nil, err
+	}
+	check, err := hex.DecodeString(fields[5])
+	if err != nil {
+		return nil, err
+	}
+	return &masterKeyParams{uint32(nums[0]), uint32(nums[1]), uint8(nums[2]), salt, check}, nil
+}
+
+func getPassphraseMasterKey(create bool) ([]byte, error) {
+	path := getMasterKeyParamsPath()
+	content, err := os.ReadFile(path)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) || !create {
+			return nil, fmt.Errorf("read master key params [%s] failed: %v", path, err)
+		}
+		return createPassphraseMasterKey(path)
+	}
+	params, err := parseMasterKeyParams(string(content))
+	if err != nil {
+		return nil, fmt.Errorf("parse master key params [%s] failed: %v", path, err)
+	}
+	for range 3 {
+		passphrase, err := readSecret("Enter tssh master passphrase: ")
+		if err != nil {
+			return nil, err
+		}
+		key := params.deriveKey(passphrase)
+		if subtle.ConstantTimeCompare(getMasterKeyCheck(key), params.check) == 1 {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("master passphrase incorrect")
+}
+
+func createPassphraseMasterKey(path string) ([]byte, error) {
+	passphrase, err := readSecret("Enter new tssh master passphrase: ")
+	if err != nil {
+		return nil, err
+	}
+	if len(passphrase) == 0 {
+		return nil, fmt.Errorf("empty master passphrase")
+	}
+	again, err := readSecret("Enter the same master passphrase again: ")
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(passphrase, again) {
+		return nil, fmt.Errorf("master passphrases do not match")
+	}
+
+	params := &masterKeyParams{time: 3, memory: 64 * 1024, threads: 4, salt: make([]byte, 16)}
+	if _, err := rand.Read(params.salt); err != nil {
+		return nil, err
+	}
+	key := params.deriveKey(passphrase)
+	params.check = getMasterKeyCheck(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, []byte(params.String()+"\n"), 0600); err != nil {
+		return nil, fmt.Errorf("write master key params [%s] failed: %v", path, err)
+	}
+	return key, nil
+}
+
+// getStoredMasterKeyCheck returns the check value of the master key saved on the disk,
+// which is used to verify the master key got from the session cache helper.
+func getStoredMasterKeyCheck() []byte {
+	switch getSecretMasterKeyMode() {
+	case "passphrase":
+		content, err := os.ReadFile(getMasterKeyParamsPath())
+		if err != nil {
+			return nil
+		}
+		params, err := parseMasterKeyParams(string(content))
+		if err != nil {
+			return nil
+		}
+		return params.check
+	case "keyring":
+		content, err := os.ReadFile(getMasterKeyCheckPath())
+		if err != nil {
+			return nil
+		}
+		check, err := hex.DecodeString(strings.TrimSpace(string(content)))
+		if err != nil {
+			return nil
+		}
+		return check
+	}
+	return nil
+}
+
+func saveKeyringMasterKeyCheck(key []byte) {
+	check := getMasterKeyCheck(key)
+	if bytes.Equal(getStoredMasterKeyCheck(), check) {
+		return
+	}
+	path := getMasterKeyCheckPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		warning("mkdir [%s] failed: %v", filepath.Dir(path), err)
+		return
+	}
+	if err := os.WriteFile(path, []byte(hex.EncodeToString(check)+"\n"), 0600); err != nil {
+		warning("write master key check [%s] failed: %v", path, err)
+	}
+}
+
+func getKeyringMasterKey(create bool) ([]byte, error) {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("security", "find-generic-password", "-s", "tssh", "-a", "master-key", "-w")
+	case "windows":
+		return nil, fmt.Errorf("keyring is not supported on windows, please use the passphrase mode")
+	default:
+		cmd = exec.Command("secret-tool", "lookup", "service", "tssh", "account", "master-key")
+	}
+	output, err := cmd.Output()
+	if err == nil {
+		key, err := hex.DecodeString(strings.TrimSpace(string(output)))
+		if err != nil || len(key) != kMasterKeySize {
+			return nil, fmt.Errorf("invalid master key in keyring")
+		}
+		saveKeyringMasterKeyCheck(key)
+		return key, nil
+	}
+	if !create {
+		return nil, fmt.Errorf("get master key from keyring failed: %v", err)
+	}
+
+	key := make([]byte, kMasterKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	if runtime.GOOS == "darwin" {
+		// read the command from stdin, so that the master key won't appear in the process arguments
+		cmd = exec.Command("security", "-i")
+		cmd.Stdin = strings.NewReader(fmt.Sprintf("add-generic-password -U -s tssh -a master-key -w %s\n", hex.EncodeToString(key)))
+	} else {
+		cmd = exec.Command("secret-tool", "store", "--label=tssh master key", "service", "tssh", "account", "master-key")
+		cmd.Stdin = strings.NewReader(hex.EncodeToString(key))
+	}
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("save master key to keyring failed: %v, %s", err, strings.TrimSpace(string(output)))
+	}
+	saveKeyringMasterKeyCheck(key)
+	return key, nil
+}
+
+// getMasterKey returns the master key from memory, the session cache helper, or the
+// configured source. If create is true, a new master key is created if there is none.
+func getMasterKey(create bool) ([]byte, error) {
+	masterKeyMutex.Lock()
+	defer masterKeyMutex.Unlock()
+	if cachedMasterKey != nil {
+		return cachedMasterKey, nil
+	}
+
+	if key := loadSessionMasterKey(); key != nil {
+		cachedMasterKey = key
+		return key, nil
+	}
+
+	var key []byte
+	var err error
+	switch mode := getSecretMasterKeyMode(); mode {
+	case "passphrase":
+		key, err = getPassphraseMasterKey(create)
+	case "keyring":
+		key, err = getKeyringMasterKey(create)
+	case "":
+		err = fmt.Errorf("SecretMasterKey is not configured")
+	default:
+		err = fmt.Errorf("unknown SecretMasterKey [%s]", mode)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	cachedMasterKey = key
+	startSessionMasterKeyCache(key)
+	return key, nil
+}
+
+func getMasterKeyCacheTimeout() time.Duration {
+	if userConfig == nil {
+		return kDefaultMasterKeyCacheTimeout
+	}
+	value := userConfig.masterKeyCacheTimeout
+	if value == "" {
+		return kDefaultMasterKeyCacheTimeout
+	}
+	if strings.EqualFold(value, "no") {
+		return 0
+	}
+	seconds, err := convertSshTime(value)
+	if err != nil {
+		warning("MasterKeyCacheTimeout [%s] is invalid: %v", value, err)
+		return kDefaultMasterKeyCacheTimeout
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// loadSessionMasterKey returns the master key from the session cache helper,
+// only if it matches the check value saved on the disk.
+func loadSessionMasterKey() []byte {
+	if getMasterKeyCacheTimeout() <= 0 {
+		return nil
+	}
+	check := getStoredMasterKeyCheck()
+	if len(check) == 0 {
+		return nil
+	}
+	addr, err := getUserSocketPath("tssh-master-key")
+	if err != nil {
+		return nil
+	}
+	conn, err := dialAgent(addr)
+	if err != nil {
+		return nil
+	}
+	defer func() { _ = conn.Close() }()
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		debug("read master key from session cache failed: %v", err)
+		return nil
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(line))
+	if err != nil || len(key) != kMasterKeySize {
+		debug("invalid master key from session cache")
+		return nil
+	}
+	if subtle.ConstantTimeCompare(getMasterKeyCheck(key), check) != 1 {
+		warning("the master key from session cache [%s] does not match, ignore it", addr)
+		return nil
+	}
+	debug("got master key from session cache [%s]", addr)
+	return key
+}
+
+func startSessionMasterKeyCache(key []byte) {
+	if getMasterKeyCacheTimeout() <= 0 {
+		return
+	}
+	cmd := exec.Command(getExePath("tssh"), "--master-key-cache")
+	setDetachedProcess(cmd)
+	cmd.Stdin = strings.NewReader(hex.EncodeToString(key) + "\n")
+	if err := cmd.Start(); err != nil {
+		debug("start master key session cache failed: %v", err)
+		return
+	}
+	_ = cmd.Process.Release()
+}
+
+// serveMasterKey sends the master key to each connection until the timeout.
+func serveMasterKey(listener net.Listener, key []byte, timeout time.Duration) {
+	timer := time.AfterFunc(timeout, func() { _ = listener.Close() })
+	defer timer.Stop()
+	line := []byte(hex.EncodeToString(key) + "\n")
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		_, _ = conn.Write(line)
+		_ = conn.Close()
+	}
+}
+
+func execMasterKeyCache() (int, bool) {
+	line, err := bufio.NewReader(io.LimitReader(os.Stdin, 1024)).ReadString('\n')
+	if err != nil {
+		return kExitCodeToolsError, true
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(line))
+	if err != nil || len(key) != kMasterKeySize {
+		return kExitCodeToolsError, true
+	}
+	addr, err := getUserSocketPath("tssh-master-key")
+	if err != nil {
+		return kExitCodeToolsError, true
+	}
+	listener, err := listenAgent(addr)
+	if err != nil {
+		return kExitCodeToolsError, true
+	}
+	defer func() { _ = listener.Close() }()
+	serveMasterKey(listener, key, getMasterKeyCacheTimeout())
+	return 0, true
+}
+
+var encSecretLineRegexp = regexp.MustCompile(`^(\s*(?:#!!\s*)?enc\w+(?:\s*=\s*|\s+))([0-9a-fA-F]+)(\s*(?:#.*)?)$`)
+
+// migrateSecrets re-encrypts the enc* secrets encoded with the built-in key using the master key.
+func migrateSecrets(content []byte) ([]byte, int, error) {
+	var buf bytes.Buffer
+	count := 0
+	for line := range strings.SplitAfterSeq(string(content), "\n") {
+		body := strings.TrimRight(line, "\r\n")
+		match := encSecretLineRegexp.FindStringSubmatch(body)
+		if match == nil {
+			buf.WriteString(line)
+			continue
+		}
+		secret, err := openSecret(secretEncodeKey, match[2])
+		if err != nil {
+			return nil, 0, fmt.Errorf("decode [%s] failed: %v", strings.TrimSpace(body), err)
+		}
+		encoded, err := encodeSecret([]byte(secret))
+		if err != nil {
+			return nil, 0, err
+		}
+		buf.WriteString(match[1] + encoded + match[3] + line[len(body):])
+		count++
+	}
+	return buf.Bytes(), count, nil
+}
+
+func execMigrateSecrets() (int, bool) {
+	if getSecretMasterKeyMode() == "" {
+		toolsErrorExit("please configure SecretMasterKey in ~/.tssh.conf first")
+	}
+	if _, err := getMasterKey(true); err != nil {
+		toolsErrorExit("get master key failed: %v", err)
+	}
+	for _, path := range []string{userConfig.configPath, userConfig.exConfigPath} {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			if !errors.Is(err, os.ErrNotExist) {
+				warning("read [%s] failed: %v", path, err)
+			}
+			continue
+		}
+		migrated, count, err := migrateSecrets(content)
+		if err != nil {
+			toolsErrorExit("migrate secrets in [%s] failed: %v", path, err)
+		}
+		if count == 0 {
+			fmt.Printf("No secrets to migrate in %s\r\n", path)
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			toolsErrorExit("stat [%s] failed: %v", path, err)
+		}
+		if err := os.WriteFile(path+".bak", content, 0600); err != nil {
+			toolsErrorExit("backup [%s] failed: %v", path, err)
+		}
+		if err := os.WriteFile(path, migrated, info.Mode().Perm()); err != nil {
+			toolsErrorExit("write [%s] failed: %v", path, err)
+		}
+		fmt.Printf("Migrated %d secrets in %s, the original file is saved as %s.bak\r\n", count, path, path)
+	}
+	return 0, true
+}
@@ -0,0 +1,177 @@
+/*
+MIT License
+
+Copyright (c) 2023-2026 The Trzsz SSH Authors.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package tssh
+
+import (
+	"bufio"
+	"encoding/hex"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMasterKeyParams(t *testing.T) {
+	assert := assert.New(t)
+
+	params := &masterKeyParams{time: 1, memory: 64, threads: 1, salt: []byte("0123456789abcdef")}
+	key := params.deriveKey([]byte("passphrase"))
+	params.check = getMasterKeyCheck(key)
+
+	parsed, err := parseMasterKeyParams(params.String() + "\n")
+	require.NoError(t, err)
+	assert.Equal(params, parsed)
+	assert.Equal(key, parsed.deriveKey([]byte("passphrase")))
+	assert.NotEqual(params.check, getMasterKeyCheck(parsed.deriveKey([]byte("wrong"))))
+
+	for _, content := range []string{"", "scrypt 1 64 1 00 00", "argon2id 1 64 1 00", "argon2id 1 64 256 00 00", "argon2id 1 64 1 zz 00"} {
+		_, err := parseMasterKeyParams(content)
+		assert.Error(err, content)
+	}
+}
+
+func TestSecretMasterKey(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("askpass script is not supported on windows")
+	}
+	assert := assert.New(t)
+	oriUserConfig, oriUserHomeDir, oriCachedMasterKey := userConfig, userHomeDir, cachedMasterKey
+	userConfig = &tsshConfig{secretMasterKey: "Passphrase", masterKeyCacheTimeout: "0"}
+	userHomeDir, cachedMasterKey = t.TempDir(), nil
+	defer func() { userConfig, userHomeDir, cachedMasterKey = oriUserConfig, oriUserHomeDir, oriCachedMasterKey }()
+
+	params := &masterKeyParams{time: 1, memory: 64, threads: 1, salt: []byte("0123456789abcdef")}
+	params.check = getMasterKeyCheck(params.deriveKey([]byte("my master passphrase")))
+	require.NoError(t, os.MkdirAll(filepath.Join(userHomeDir, ".ssh"), 0700))
+	require.NoError(t, os.WriteFile(getMasterKeyParamsPath(), []byte(params.String()+"\n"), 0600))
+
+	askpass := filepath.Join(t.TempDir(), "askpass.sh")
+	require.NoError(t, os.WriteFile(askpass, []byte("#!/bin/sh\necho 'my master passphrase'\n"), 0700))
+	t.Setenv("SSH_ASKPASS", askpass)
+	t.Setenv("SSH_ASKPASS_REQUIRE", "force")
+
+	legacy, err := sealSecret(secretEncodeKey, []byte("legacy secret"))
+	require.NoError(t, err)
+	encoded, err := encodeSecret([]byte("new secret"))
+	require.NoError(t, err)
+	assert.True(strings.HasPrefix(encoded, kSecretMasterKeyPrefix))
+
+	cachedMasterKey = nil
+	decoded, err := decodeSecret(encoded)
+	require.NoError(t, err)
+	assert.Equal("new secret", decoded)
+	decoded, err = decodeSecret(legacy)
+	require.NoError(t, err)
+	assert.Equal("legacy secret", decoded)
+
+	cachedMasterKey = nil
+	require.NoError(t, os.WriteFile(askpass, []byte("#!/bin/sh\necho 'wrong passphrase'\n"), 0700))
+	_, err = decodeSecret(encoded)
+	assert.EqualError(err, "master passphrase incorrect")
+
+	cachedMasterKey = params.deriveKey([]byte("my master passphrase"))
+	content := "Host test\n  #!! encPassword " + legacy + " # comment\r\n  encOtpSecret = " + legacy + "\n  #!! encPassword " + encoded + "\n"
+	migrated, count, err := migrateSecrets([]byte(content))
+	require.NoError(t, err)
+	assert.Equal(2, count)
+	lines := strings.Split(string(migrated), "\n")
+	require.Len(t, lines, 5)
+	assert.Equal("Host test", lines[0])
+	assert.True(strings.HasPrefix(lines[1], "  #!! encPassword v2:"))
+	assert.True(strings.HasSuffix(lines[1], " # comment\r"))
+	assert.True(strings.HasPrefix(lines[2], "  encOtpSecret = v2:"))
+	assert.Equal("  #!! encPassword "+encoded, lines[3])
+	decoded, err = decodeSecret(strings.Fields(lines[1])[2])
+	require.NoError(t, err)
+	assert.Equal("legacy secret", decoded)
+}
+
+func TestServeMasterKey(t *testing.T) {
+	assert := assert.New(t)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	key := []byte(strings.Repeat("k", kMasterKeySize))
+	done := make(chan struct{})
+	go func() {
+		serveMasterKey(listener, key, 500*time.Millisecond)
+		close(done)
+	}()
+
+	for range 2 {
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		require.NoError(t, err)
+		line, err := bufio.NewReader(conn).ReadString('\n')
+		require.NoError(t, err)
+		assert.Equal(hex.EncodeToString(key)+"\n", line)
+		_ = conn.Close()
+	}
+
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		assert.Fail("master key cache should exit after the timeout")
+	}
+}
+
+func TestLoadSessionMasterKey(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("XDG_RUNTIME_DIR is not supported on windows")
+	}
+	assert := assert.New(t)
+	oriUserConfig, oriUserHomeDir, oriEnableWarning := userConfig, userHomeDir, enableWarningLogging
+	userConfig = &tsshConfig{secretMasterKey: "keyring", masterKeyCacheTimeout: "1h"}
+	userHomeDir, enableWarningLogging = t.TempDir(), false
+	defer func() {
+		userConfig, userHomeDir, enableWarningLogging = oriUserConfig, oriUserHomeDir, oriEnableWarning
+	}()
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+
+	addr, err := getUserSocketPath("tssh-master-key")
+	require.NoError(t, err)
+	serve := func(key []byte) func() {
+		listener, err := listenAgent(addr)
+		require.NoError(t, err)
+		go serveMasterKey(listener, key, time.Minute)
+		return func() { _ = listener.Close() }
+	}
+
+	key := []byte(strings.Repeat("k", kMasterKeySize))
+	stop := serve(key)
+	assert.Nil(loadSessionMasterKey(), "the key should not be trusted without the check value")
+	saveKeyringMasterKeyCheck(key)
+	assert.Equal(key, loadSessionMasterKey())
+	stop()
+
+	stop = serve([]byte(strings.Repeat("x", kMasterKeySize)))
+	defer stop()
+	assert.Nil(loadSessionMasterKey(), "the key of another listener should not be trusted")
+}
@@ -256,6 +256,10 @@ type sshConnection struct {
 	exited    atomic.Bool
 	waitWarn  sync.WaitGroup
 	startEOF  bool
+
+	autoForwarder atomic.Pointer[autoPortForwarder]
+	remoteBrowser *remoteBrowser
+	remoteEnvs    []*sshEnv
 }
 
 func (c *sshConnection) Close() {
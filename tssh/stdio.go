@@ -200,7 +200,7 @@ func getEscapeConfig(args *sshArgs) (byte, time.Duration) {
 		} else if len(escCh) == 1 {
 			b := escCh[0]
 			switch b {
-			case 'j', 'k', 'q', '.', 'B', 'C', 'R', 'V', 'v', '#', '&', '?', 'd':
+			case 'j', 'k', 'q', '.', 'B', 'C', 'R', 'V', 'v', '#', '&', '?', 'd', 'p', 'o':
 				warning("EscapeChar [%s] conflicts with other shortcuts", escCh)
 			default:
 				if b <= ' ' || b > '~' {
@@ -165,3 +165,8 @@ func injectConsoleSpace() error {
 func windowsDnsServers() []dnsServer {
 	return nil
 }
+
+// setDetachedProcess makes the process keep running after the terminal is closed.
+func setDetachedProcess(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+}
@@ -41,6 +41,8 @@ import (
 
 const kDefaultSshSkHelperPath = `C:\Windows\System32\OpenSSH\ssh-sk-helper.exe`
 
+const kDefaultSshPkcs11HelperPath = `C:\Windows\System32\OpenSSH\ssh-pkcs11-helper.exe`
+
 var isRunningOnOldWindows atomic.Bool
 
 type stdinState struct {
@@ -351,3 +353,10 @@ func splitCommandLine(command string) ([]string, error) {
 
 func suspendProcess() {
 }
+
+// setDetachedProcess makes the process keep running after the console is closed.
+func setDetachedProcess(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		CreationFlags: windows.CREATE_NEW_PROCESS_GROUP | windows.DETACHED_PROCESS,
+	}
+}
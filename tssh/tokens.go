@@ -28,6 +28,7 @@ import (
 	"crypto/sha1"
 	"fmt"
 	"os"
+	"os/user"
 	"regexp"
 	"strings"
 	"unicode"
@@ -85,6 +86,11 @@ var getHostname = func() string {
 }
 
 func expandTokens(str string, param *sshParam, tokens string) (string, error) {
+	return expandHostKeyTokens(str, param, tokens, nil)
+}
+
+// expandHostKeyTokens expands the tokens as expandTokens, and the %H %I %K %f %t tokens with the host key tokens.
+func expandHostKeyTokens(str string, param *sshParam, tokens string, hostKey *hostKeyTokens) (string, error) {
 	if !strings.ContainsRune(str, '%') {
 		return str, nil
 	}
@@ -143,6 +149,19 @@ func expandTokens(str string, param *sshParam, tokens string) (string, error) {
 				}
 				buf.WriteString(proxy)
 			}
+		case 'u':
+			currentUser, err := user.Current()
+			if err != nil {
+				return "", fmt.Errorf("get current user failed: %v", err)
+			}
+			userName := currentUser.Username
+			if idx := strings.LastIndexByte(userName, '\\'); idx >= 0 {
+				userName = userName[idx+1:]
+			}
+			if !isUserValid(userName) {
+				return "", fmt.Errorf("local user [%s] contains invalid characters", userName)
+			}
+			buf.WriteString(userName)
 		case 'C':
 			hashStr := fmt.Sprintf("%s%s%s%s", getHostname(), param.host, param.port, param.user)
 			if len(param.proxies) > 0 && strings.ContainsRune(tokens, 'j') {
@@ -161,6 +180,8 @@ func expandTokens(str string, param *sshParam, tokens string) (string, error) {
 				}
 				buf.WriteString(param.args.Destination)
 			}
+		case 'H', 'I', 'K', 'f', 't':
+			buf.WriteString(hostKey.expand(c))
 		default:
 			return "", fmt.Errorf("token [%%%c] in [%s] is not supported yet", c, str)
 		}
@@ -532,6 +532,16 @@ func execLocalTools(args *sshArgs) (int, bool) {
 	switch {
 	case args.EncSecret:
 		return execEncodeSecret()
+	case args.MigrateSecrets:
+		return execMigrateSecrets()
+	case args.MasterKeyCache:
+		return execMasterKeyCache()
+	case args.KnownHosts:
+		return execKnownHosts(args)
+	case args.SSHFP || args.SSHFPCheck:
+		return execSSHFP(args)
+	case args.Agent:
+		return execAgent(args)
 	case args.NewHost || args.Destination == "" && isFileNotExistOrEmpty(userConfig.configPath):
 		return execNewHost(args)
 	case args.ListHosts:
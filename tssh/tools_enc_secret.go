@@ -31,6 +31,12 @@ import (
 )
 
 func execEncodeSecret() (int, bool) {
+	if getSecretMasterKeyMode() != "" {
+		if _, err := getMasterKey(true); err != nil {
+			toolsErrorExit("get master key failed: %v", err)
+		}
+	}
+
 	state, err := makeStdinRaw()
 	if err != nil {
 		toolsErrorExit("make stdin raw failed: %v", err)
@@ -0,0 +1,435 @@
+/*
+MIT License
+
+Copyright (c) 2023-2026 The Trzsz SSH Authors.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package tssh
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/skeema/knownhosts"
+	"golang.org/x/crypto/ssh"
+	xkh "golang.org/x/crypto/ssh/knownhosts"
+)
+
+const kKnownHostsUsage = `Usage: tssh --known-hosts <command> [argument]
+
+Commands:
+  list                     list all entries with key type, fingerprint, file and line
+  search <host>            list the entries of the host
+  remove <host|SHA256:xx>  remove the entries of the host or with the fingerprint
+  dedup                    remove the duplicate entries
+  hash                     hash the plaintext host names, like HashKnownHosts
+  check                    report the malformed lines
+  verify <host>            verify the current key of the host against the stored one
+`
+
+type knownHostsEntry struct {
+	path   string
+	line   int
+	marker string
+	hosts  string
+	key    ssh.PublicKey
+	fields [][]byte
+}
+
+// parseKnownHostsEntry parses a known_hosts line, returns nil for blank, comment or malformed lines.
+func parseKnownHostsEntry(line []byte) *knownHostsEntry {
+	trimmedLine := bytes.TrimSpace(line)
+	if len(trimmedLine) == 0 || trimmedLine[0] == '#' {
+		return nil
+	}
+	entry := &knownHostsEntry{}
+	fields := bytes.Fields(trimmedLine)
+	if fields[0][0] == '@' {
+		entry.marker = string(fields[0])
+		fields = fields[1:]
+	}
+	if len(fields) < 3 {
+		return nil
+	}
+	keyBytes, err := base64.StdEncoding.DecodeString(string(fields[2]))
+	if err != nil {
+		return nil
+	}
+	if entry.key, err = ssh.ParsePublicKey(keyBytes); err != nil {
+		return nil
+	}
+	entry.hosts = string(fields[0])
+	entry.fields = fields
+	return entry
+}
+
+func readKnownHostsEntries(path string) ([]*knownHostsEntry, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var entries []*knownHostsEntry
+	for i, line := range bytes.Split(content, []byte{'\n'}) {
+		if entry := parseKnownHostsEntry(line); entry != nil {
+			entry.path, entry.line = path, i+1
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+func (e *knownHostsEntry) matchHost(host string) bool {
+	return matchKnownHosts(e.hosts, knownhosts.Normalize(host))
+}
+
+func (e *knownHostsEntry) matchFingerprint(fingerprint string) bool {
+	if strings.HasPrefix(fingerprint, "MD5:") {
+		return strings.EqualFold(ssh.FingerprintLegacyMD5(e.key), strings.TrimPrefix(fingerprint, "MD5:"))
+	}
+	return ssh.FingerprintSHA256(e.key) == fingerprint
+}
+
+// editKnownHosts replaces each line of the known_hosts file with the lines returned by the edit function,
+// and saves the original file to path.old if anything changed. It returns the number of changed lines.
+func editKnownHosts(path string, edit func(line []byte) [][]byte) (int, string, error) {
+	input, err := os.ReadFile(path)
+	if err != nil {
+		return 0, "", fmt.Errorf("read known_hosts %q failed: %v", path, err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, "", fmt.Errorf("stat known_hosts %q failed: %v", path, err)
+	}
+	filePerm := info.Mode().Perm()
+
+	inputLines := bytes.Split(input, []byte{'\n'})
+	outputLines := make([][]byte, 0, len(inputLines))
+	changedCount := 0
+	for _, line := range inputLines {
+		lines := edit(line)
+		if len(lines) != 1 || !bytes.Equal(lines[0], line) {
+			changedCount++
+		}
+		outputLines = append(outputLines, lines...)
+	}
+	if changedCount == 0 {
+		return 0, "", nil
+	}
+
+	backupPath := path + ".old"
+	if err := os.WriteFile(backupPath, input, filePerm); err != nil {
+		return 0, "", fmt.Errorf("create backup %q failed: %v", backupPath, err)
+	}
+	if err := os.WriteFile(path, bytes.Join(outputLines, []byte{'\n'}), filePerm); err != nil {
+		return 0, "", fmt.Errorf("update known_hosts %q failed: %v", path, err)
+	}
+	return changedCount, backupPath, nil
+}
+
+func execKnownHosts(args *sshArgs) (int, bool) {
+	command, target := args.Destination, args.Command
+	param := &sshParam{args: &sshArgs{Option: args.Option, ConfigFile: args.ConfigFile}}
+	userFiles, err := getUserKnownHostsFiles(param)
+	if err != nil {
+		toolsErrorExit("%v", err)
+	}
+	globalFiles, err := getGlobalKnownHostsFiles(param)
+	if err != nil {
+		toolsErrorExit("%v", err)
+	}
+	allFiles := append(append([]string{}, userFiles...), globalFiles...)
+
+	requireTarget := func(name string) {
+		if target == "" {
+			toolsErrorExit("Usage: tssh --known-hosts %s <%s>", command, name)
+		}
+	}
+	switch command {
+	case "list":
+		listKnownHosts(allFiles, func(*knownHostsEntry) bool { return true })
+	case "search":
+		requireTarget("host")
+		listKnownHosts(allFiles, func(entry *knownHostsEntry) bool { return entry.matchHost(target) })
+	case "remove":
+		requireTarget("host|fingerprint")
+		removeKnownHosts(userFiles, target)
+	case "dedup":
+		dedupKnownHosts(userFiles)
+	case "hash":
+		hashKnownHosts(userFiles)
+	case "check":
+		checkKnownHosts(allFiles)
+	case "verify":
+		requireTarget("host")
+		args.Destination, args.Command = target, ""
+		return verifyKnownHost(args)
+	case "":
+		fmt.Print(strings.ReplaceAll(kKnownHostsUsage, "\n", "\r\n"))
+	default:
+		toolsErrorExit("unknown known hosts command: %s\r\n%s", command, strings.ReplaceAll(kKnownHostsUsage, "\n", "\r\n"))
+	}
+	return 0, true
+}
+
+func listKnownHosts(files []string, filter func(*knownHostsEntry) bool) {
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, path := range files {
+		entries, err := readKnownHostsEntries(path)
+		if err != nil {
+			warning("read known_hosts [%s] failed: %v", path, err)
+			continue
+		}
+		for _, entry := range entries {
+			if !filter(entry) {
+				continue
+			}
+			hosts := entry.hosts
+			if entry.marker != "" {
+				hosts = entry.marker + " " + hosts
+			}
+			fmt.Fprintf(writer, "%s\t%s\t%s\t%s:%d\r\n", hosts, shortKeyType(entry.key.Type()),
+				ssh.FingerprintSHA256(entry.key), entry.path, entry.line)
+		}
+	}
+	_ = writer.Flush()
+}
+
+func removeKnownHosts(files []string, target string) {
+	byFingerprint := strings.HasPrefix(target, "SHA256:") || strings.HasPrefix(target, "MD5:")
+	for _, path := range files {
+		count, backupPath, err := editKnownHosts(path, func(line []byte) [][]byte {
+			entry := parseKnownHostsEntry(line)
+			if entry != nil && (byFingerprint && entry.matchFingerprint(target) || !byFingerprint && entry.matchHost(target)) {
+				return nil
+			}
+			return [][]byte{line}
+		})
+		if err != nil {
+			toolsErrorExit("%v", err)
+		}
+		if count > 0 {
+			fmt.Printf("Removed %d entries of %s from %s (backup saved to %s)\r\n", count, target, path, backupPath)
+		}
+	}
+}
+
+func dedupKnownHosts(files []string) {
+	for _, path := range files {
+		seen := make(map[string]bool)
+		count, backupPath, err := editKnownHosts(path, func(line []byte) [][]byte {
+			entry := parseKnownHostsEntry(line)
+			if entry == nil {
+				return [][]byte{line}
+			}
+			id := entry.marker + " " + entry.hosts + " " + string(entry.key.Marshal())
+			if seen[id] {
+				return nil
+			}
+			seen[id] = true
+			return [][]byte{line}
+		})
+		if err != nil {
+			toolsErrorExit("%v", err)
+		}
+		if count > 0 {
+			fmt.Printf("Removed %d duplicate entries from %s (backup saved to %s)\r\n", count, path, backupPath)
+		}
+	}
+}
+
+// hashKnownHostsLine hashes each plaintext host of the entry to a separate line.
+// Entries with host patterns are kept as they are, since patterns can't be hashed.
+func hashKnownHostsLine(path string, lineNo int, line []byte) [][]byte {
+	entry := parseKnownHostsEntry(line)
+	if entry == nil {
+		return [][]byte{line}
+	}
+	hosts := strings.Split(entry.hosts, ",")
+	plaintext := false
+	for _, host := range hosts {
+		if strings.ContainsAny(host, "*?!") {
+			warning("%s:%d: ignoring host name with wildcard: %s", path, lineNo, host)
+			return [][]byte{line}
+		}
+		if !strings.HasPrefix(host, "|1|") {
+			plaintext = true
+		}
+	}
+	if !plaintext {
+		return [][]byte{line}
+	}
+	rest := string(bytes.Join(entry.fields[1:], []byte{' '}))
+	var lines [][]byte
+	for _, host := range hosts {
+		if !strings.HasPrefix(host, "|1|") {
+			host = xkh.HashHostname(host)
+		}
+		hashedLine := host + " " + rest
+		if entry.marker != "" {
+			hashedLine = entry.marker + " " + hashedLine
+		}
+		lines = append(lines, []byte(hashedLine))
+	}
+	return lines
+}
+
+func hashKnownHosts(files []string) {
+	for _, path := range files {
+		lineNo := 0
+		count, backupPath, err := editKnownHosts(path, func(line []byte) [][]byte {
+			lineNo++
+			return hashKnownHostsLine(path, lineNo, line)
+		})
+		if err != nil {
+			toolsErrorExit("%v", err)
+		}
+		if count > 0 {
+			fmt.Printf("Hashed %d entries in %s (backup saved to %s)\r\n", count, path, backupPath)
+		}
+	}
+}
+
+func checkKnownHosts(files []string) {
+	total := 0
+	for _, path := range files {
+		_, malformed, err := newKnownHostsDB(path)
+		if err != nil {
+			warning("check known_hosts [%s] failed: %v", path, err)
+			continue
+		}
+		for _, entry := range malformed {
+			fmt.Printf("%s:%d: %s\r\n", entry.path, entry.line, entry.reason)
+		}
+		total += len(malformed)
+	}
+	if total == 0 {
+		fmt.Printf("No malformed lines found in %s\r\n", strings.Join(files, ", "))
+	}
+}
+
+// fetchHostKey connects to the server and returns its host key without logging in.
+func fetchHostKey(param *sshParam, algorithms []string) (ssh.PublicKey, net.Addr, error) {
+	timeout := getConnectTimeout(param.args)
+	dialer := net.Dialer{Timeout: timeout}
+	network, _, _ := getNetworkAddressFamily(param.args)
+	conn, err := dialer.Dial(network, param.addr)
+	if err != nil {
+		return nil, nil, fmt.Errorf("dial [%s] [%s] failed: %v", network, param.addr, err)
+	}
+	defer func() { _ = conn.Close() }()
+	_ = conn.SetDeadline(time.Now().Add(timeout))
+
+	var hostKey ssh.PublicKey
+	errHostKeyFetched := errors.New("host key fetched")
+	config := &ssh.ClientConfig{
+		User:              param.user,
+		HostKeyAlgorithms: algorithms,
+		HostKeyCallback: func(_ string, _ net.Addr, key ssh.PublicKey) error {
+			hostKey = key
+			return errHostKeyFetched
+		},
+	}
+	_, _, _, err = ssh.NewClientConn(conn, param.addr, config)
+	if hostKey == nil {
+		return nil, nil, fmt.Errorf("get host key of [%s] failed: %v", param.addr, err)
+	}
+	return hostKey, conn.RemoteAddr(), nil
+}
+
+// getDirectSshParam returns the ssh param of the tools which connect to the server directly.
+func getDirectSshParam(args *sshArgs) *sshParam {
+	setupCustomDNS(args)
+	param, err := getSshParam(args, false)
+	if err != nil {
+		toolsErrorExit("%v", err)
+	}
+	if param.command != "" || len(param.proxies) > 0 {
+		toolsErrorExit("connecting to %s via ProxyCommand or ProxyJump is not supported", args.Destination)
+	}
+	return param
+}
+
+// checkKnownHostKey checks the host key as the login does, with the known hosts files, the KnownHostsBundle,
+// and the KnownHostsCommand output for the host key if any, otherwise the output for ORDER in the db.
+func checkKnownHostKey(param *sshParam, files []string, bundleData knownHostsData, db *knownhosts.HostKeyDB,
+	host string, remote net.Addr, key ssh.PublicKey) error {
+	if output := runKnownHostsCommand(param, host, "HOSTNAME", key); output != nil {
+		cmdDB, _, err := newKnownHostsDBWithData(files, bundleData, knownHostsData{"KnownHostsCommand", output})
+		if err != nil {
+			return fmt.Errorf("merge KnownHostsCommand output failed: %v", err)
+		}
+		db = cmdDB
+	}
+	return db.HostKeyCallback()(host, remote, key)
+}
+
+func verifyKnownHost(args *sshArgs) (int, bool) {
+	param := getDirectSshParam(args)
+	hostKeyAddr := param.hostKeyAddr()
+	userFiles, err := getUserKnownHostsFiles(param)
+	if err != nil {
+		toolsErrorExit("%v", err)
+	}
+	globalFiles, err := getGlobalKnownHostsFiles(param)
+	if err != nil {
+		toolsErrorExit("%v", err)
+	}
+	files := append(userFiles, globalFiles...)
+	bundleData := getKnownHostsBundleData(param)
+	db, _, err := newKnownHostsDBWithData(files, bundleData,
+		knownHostsData{"KnownHostsCommand", runKnownHostsCommand(param, hostKeyAddr, "ORDER", nil)})
+	if err != nil {
+		toolsErrorExit("new knownhosts failed: %v", err)
+	}
+	key, remote, err := fetchHostKey(param, db.HostKeyAlgorithms(hostKeyAddr))
+	if err != nil {
+		toolsErrorExit("%v", err)
+	}
+	fingerprint := ssh.FingerprintSHA256(key)
+
+	err = checkKnownHostKey(param, files, bundleData, db, hostKeyAddr, remote, key)
+	var keyErr *xkh.KeyError
+	switch {
+	case err == nil:
+		fmt.Printf("The %s host key of %s matches the known hosts: %s\r\n",
+			shortKeyType(key.Type()), knownhosts.Normalize(hostKeyAddr), fingerprint)
+	case knownhosts.IsHostKeyChanged(err) && errors.As(err, &keyErr):
+		for _, known := range keyErr.Want {
+			fmt.Fprintf(os.Stderr, "Offending %s key in %s:%d: %s\r\n", shortKeyType(known.Key.Type()),
+				known.Filename, known.Line, ssh.FingerprintSHA256(known.Key))
+		}
+		toolsErrorExit("The %s host key of %s has changed: %s", shortKeyType(key.Type()),
+			knownhosts.Normalize(hostKeyAddr), fingerprint)
+	case knownhosts.IsHostUnknown(err):
+		toolsErrorExit("The %s host key of %s is not known: %s", shortKeyType(key.Type()),
+			knownhosts.Normalize(hostKeyAddr), fingerprint)
+	default:
+		toolsErrorExit("verify the host key of %s failed: %v", knownhosts.Normalize(hostKeyAddr), err)
+	}
+	return 0, true
+}
@@ -0,0 +1,174 @@
+/*
+MIT License
+
+Copyright (c) 2023-2026 The Trzsz SSH Authors.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package tssh
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/skeema/knownhosts"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestKnownHostsTools(t *testing.T) {
+	assert := assert.New(t)
+	oriEnableWarning := enableWarningLogging
+	enableWarningLogging = false
+	defer func() { enableWarningLogging = oriEnableWarning }()
+
+	key1, key2 := newKnownHostsTestKey(t), newKnownHostsTestKey(t)
+	keyLine := func(key ssh.PublicKey) string {
+		return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+	}
+	content := strings.Join([]string{
+		"# comment",
+		"example.com,192.0.2.1 " + keyLine(key1),
+		"*.example.org " + keyLine(key2),
+		"[example.com]:2222 " + keyLine(key2),
+		"example.com,192.0.2.1 " + keyLine(key1),
+		"@cert-authority other.com " + keyLine(key2),
+		"invalid.com ssh-ed25519",
+		"",
+	}, "\n")
+	path := writeKnownHostsTestFile(t, t.TempDir(), "known_hosts", content)
+	readKnownHosts := func() string {
+		content, err := os.ReadFile(path)
+		require.NoError(t, err)
+		return string(content)
+	}
+
+	entries, err := readKnownHostsEntries(path)
+	require.NoError(t, err)
+	require.Len(t, entries, 5)
+	assert.Equal(2, entries[0].line)
+	assert.Equal("@cert-authority", entries[4].marker)
+	assert.Equal("other.com", entries[4].hosts)
+	assert.True(entries[0].matchHost("192.0.2.1"))
+	assert.True(entries[2].matchHost("example.com:2222"))
+	assert.False(entries[2].matchHost("example.com"))
+	assert.True(entries[1].matchFingerprint(ssh.FingerprintSHA256(key2)))
+	assert.True(entries[1].matchFingerprint("MD5:" + ssh.FingerprintLegacyMD5(key2)))
+	assert.False(entries[0].matchFingerprint(ssh.FingerprintSHA256(key2)))
+
+	_, malformed, err := newKnownHostsDB(path)
+	require.NoError(t, err)
+	require.Len(t, malformed, 1)
+	assert.Equal(7, malformed[0].line)
+
+	dedupKnownHosts([]string{path})
+	assert.Equal(strings.Replace(content, "\nexample.com,192.0.2.1 "+keyLine(key1)+"\n@", "\n@", 1), readKnownHosts())
+	backup, err := os.ReadFile(path + ".old")
+	require.NoError(t, err)
+	assert.Equal(content, string(backup))
+
+	removeKnownHosts([]string{path}, ssh.FingerprintSHA256(key2))
+	assert.Equal("# comment\nexample.com,192.0.2.1 "+keyLine(key1)+"\ninvalid.com ssh-ed25519\n", readKnownHosts())
+
+	hashKnownHosts([]string{path})
+	entries, err = readKnownHostsEntries(path)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.True(strings.HasPrefix(entries[0].hosts, "|1|"))
+	assert.True(entries[0].matchHost("example.com"))
+	assert.True(entries[1].matchHost("192.0.2.1"))
+	assert.Equal("*.example.org "+keyLine(key2), string(hashKnownHostsLine(path, 1, []byte("*.example.org "+keyLine(key2)))[0]))
+
+	removeKnownHosts([]string{path}, "192.0.2.1")
+	assert.Equal("# comment\n"+entries[0].hosts+" "+keyLine(key1)+"\ninvalid.com ssh-ed25519\n", readKnownHosts())
+}
+
+func TestFetchHostKey(t *testing.T) {
+	assert := assert.New(t)
+	oriUserConfig := userConfig
+	userConfig = &tsshConfig{}
+	defer func() { userConfig = oriUserConfig }()
+
+	hostKey := newTestHostKey(t)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer func() { _ = listener.Close() }()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer func() { _ = conn.Close() }()
+		config := &ssh.ServerConfig{NoClientAuth: true}
+		config.AddHostKey(hostKey)
+		_, _, _, _ = ssh.NewServerConn(conn, config)
+	}()
+
+	args := &sshArgs{}
+	require.NoError(t, args.Option.UnmarshalText([]byte("ConnectTimeout=5")))
+	param := &sshParam{args: args, user: "test", addr: listener.Addr().String()}
+	key, remote, err := fetchHostKey(param, nil)
+	require.NoError(t, err)
+	assert.Equal(hostKey.PublicKey().Marshal(), key.Marshal())
+	assert.Equal(listener.Addr().String(), remote.String())
+
+	path := writeKnownHostsTestFile(t, t.TempDir(), "known_hosts", knownhosts.Line([]string{param.addr}, key)+"\n")
+	db, _, err := newKnownHostsDB(path)
+	require.NoError(t, err)
+	assert.Nil(db.HostKeyCallback()(param.addr, remote, key))
+}
+
+func TestCheckKnownHostKey(t *testing.T) {
+	assert := assert.New(t)
+	oriEnableWarning, oriUserConfig := enableWarningLogging, userConfig
+	enableWarningLogging, userConfig = false, &tsshConfig{}
+	defer func() { enableWarningLogging, userConfig = oriEnableWarning, oriUserConfig }()
+
+	key, bundleKey := newKnownHostsTestKey(t), newKnownHostsTestKey(t)
+	files := []string{writeKnownHostsTestFile(t, t.TempDir(), "known_hosts", "")}
+	bundleData := knownHostsData{"KnownHostsBundle", []byte(knownhosts.Line([]string{"example.com"}, bundleKey) + "\n")}
+	remote := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 22}
+	newParam := func(command string) *sshParam {
+		args := &sshArgs{Destination: "example.com"}
+		require.NoError(t, args.Option.UnmarshalText([]byte("KnownHostsCommand="+command)))
+		return &sshParam{args: args, host: "example.com", port: "22", addr: "example.com:22"}
+	}
+
+	param := newParam("none")
+	db, _, err := newKnownHostsDBWithData(files, bundleData)
+	require.NoError(t, err)
+	assert.Nil(checkKnownHostKey(param, files, bundleData, db, "example.com:22", remote, bundleKey))
+	assert.True(knownhosts.IsHostKeyChanged(checkKnownHostKey(param, files, bundleData, db, "example.com:22", remote, key)))
+
+	if runtime.GOOS == "windows" {
+		return
+	}
+	// the command trusts the key for HOSTNAME only, and prints nothing for ORDER
+	script := filepath.Join(t.TempDir(), "known_hosts_command.sh")
+	require.NoError(t, os.WriteFile(script, []byte("#!/bin/sh\n[ \"$2\" = HOSTNAME ] && echo \"$1 $3 $4\"\nexit 0\n"), 0700))
+	param = newParam(script + " %H %I %t %K")
+	assert.Nil(checkKnownHostKey(param, files, bundleData, db, "example.com:22", remote, key))
+	assert.Nil(checkKnownHostKey(param, files, bundleData, db, "example.com:22", remote, bundleKey))
+}
@@ -0,0 +1,159 @@
+/*
+MIT License
+
+Copyright (c) 2023-2026 The Trzsz SSH Authors.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package tssh
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"slices"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// sshfpHostKeyAlgorithms are negotiated one by one to collect all the host keys of the server.
+var sshfpHostKeyAlgorithms = []string{
+	ssh.KeyAlgoRSASHA512,
+	ssh.KeyAlgoRSA, // the old servers which don't support rsa-sha2-512
+	ssh.KeyAlgoECDSA256,
+	ssh.KeyAlgoECDSA384,
+	ssh.KeyAlgoECDSA521,
+	ssh.KeyAlgoED25519,
+}
+
+// newSSHFPRecords returns the SHA-1 and SHA-256 SSHFP records of the host key.
+func newSSHFPRecords(key ssh.PublicKey) []sshfpRecord {
+	algorithm := sshfpAlgorithm(key.Type())
+	if algorithm == 0 {
+		return nil
+	}
+	blob := key.Marshal()
+	sha1Sum := sha1.Sum(blob)
+	sha256Sum := sha256.Sum256(blob)
+	return []sshfpRecord{
+		{algorithm: algorithm, fpType: sshfpTypeSHA1, fingerprint: sha1Sum[:]},
+		{algorithm: algorithm, fpType: sshfpTypeSHA256, fingerprint: sha256Sum[:]},
+	}
+}
+
+func (r *sshfpRecord) equal(other *sshfpRecord) bool {
+	return r.algorithm == other.algorithm && r.fpType == other.fpType && slices.Equal(r.fingerprint, other.fingerprint)
+}
+
+func (r *sshfpRecord) String() string {
+	return fmt.Sprintf("%d %d %s", r.algorithm, r.fpType, hex.EncodeToString(r.fingerprint))
+}
+
+// fetchAllHostKeys negotiates each host key algorithm to collect all the host keys the server offers.
+func fetchAllHostKeys(param *sshParam) []ssh.PublicKey {
+	var keys []ssh.PublicKey
+	for _, algorithm := range sshfpHostKeyAlgorithms {
+		key, _, err := fetchHostKey(param, []string{algorithm})
+		if err != nil {
+			debug("fetch %s host key failed: %v", algorithm, err)
+			continue
+		}
+		if !slices.ContainsFunc(keys, func(k ssh.PublicKey) bool { return string(k.Marshal()) == string(key.Marshal()) }) {
+			debug("fetched %s host key: %s", key.Type(), ssh.FingerprintSHA256(key))
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// compareSSHFPRecords returns the records which are missing in DNS, and the stale records in DNS.
+func compareSSHFPRecords(records, published []sshfpRecord) (missing, stale []sshfpRecord) {
+	contains := func(records []sshfpRecord, record sshfpRecord) bool {
+		return slices.ContainsFunc(records, func(r sshfpRecord) bool { return r.equal(&record) })
+	}
+	for _, record := range records {
+		if !contains(published, record) {
+			missing = append(missing, record)
+		}
+	}
+	for _, record := range published {
+		if !contains(records, record) {
+			stale = append(stale, record)
+		}
+	}
+	return
+}
+
+func execSSHFP(args *sshArgs) (int, bool) {
+	if args.Destination == "" {
+		toolsErrorExit("Usage: tssh --sshfp [--sshfp-check] <destination>")
+	}
+	param := getDirectSshParam(args)
+	if net.ParseIP(param.host) != nil {
+		toolsErrorExit("SSHFP records are keyed by host name, but %s is an IP address", param.host)
+	}
+
+	keys := fetchAllHostKeys(param)
+	if len(keys) == 0 {
+		toolsErrorExit("no host keys fetched from %s", param.addr)
+	}
+	var records []sshfpRecord
+	for _, key := range keys {
+		keyRecords := newSSHFPRecords(key)
+		if keyRecords == nil {
+			warning("SSHFP does not support %s host key: %s", key.Type(), ssh.FingerprintSHA256(key))
+			continue
+		}
+		for _, record := range keyRecords {
+			fmt.Printf("%s IN SSHFP %s\n", param.host, record.String())
+		}
+		records = append(records, keyRecords...)
+	}
+
+	if !args.SSHFPCheck {
+		return 0, true
+	}
+	published, authenticate, err := lookupSSHFP(param.args, param.host)
+	if err != nil {
+		toolsErrorExit("SSHFP lookup for '%s' failed: %v", param.host, err)
+	}
+	if len(published) == 0 {
+		toolsErrorExit("No SSHFP records found in DNS for %s", param.host)
+	}
+	missing, stale := compareSSHFPRecords(records, published)
+	for _, record := range missing {
+		fmt.Printf("Missing in DNS: %s IN SSHFP %s\n", param.host, record.String())
+	}
+	for _, record := range stale {
+		fmt.Printf("Stale in DNS: %s IN SSHFP %s\n", param.host, record.String())
+	}
+	if authenticate() {
+		fmt.Printf("The SSHFP records in DNS are validated through DNSSEC.\n")
+	} else {
+		fmt.Printf("The SSHFP records in DNS are not validated through DNSSEC.\n")
+	}
+	if len(missing) > 0 || len(stale) > 0 {
+		toolsErrorExit("The SSHFP records in DNS for %s are out of date", param.host)
+	}
+	fmt.Printf("The SSHFP records in DNS for %s are up to date.\n", param.host)
+	return 0, true
+}
@@ -0,0 +1,138 @@
+/*
+MIT License
+
+Copyright (c) 2023-2026 The Trzsz SSH Authors.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package tssh
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestSSHFPRecords(t *testing.T) {
+	assert := assert.New(t)
+	oriUserConfig := userConfig
+	userConfig = &tsshConfig{}
+	defer func() { userConfig = oriUserConfig }()
+
+	ed25519Key := newTestHostKey(t)
+	ecdsaPriKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	ecdsaKey, err := ssh.NewSignerFromKey(ecdsaPriKey)
+	require.NoError(t, err)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer func() { _ = listener.Close() }()
+	go func() {
+		config := &ssh.ServerConfig{NoClientAuth: true}
+		config.AddHostKey(ed25519Key)
+		config.AddHostKey(ecdsaKey)
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer func() { _ = conn.Close() }()
+				_, _, _, _ = ssh.NewServerConn(conn, config)
+			}()
+		}
+	}()
+
+	args := &sshArgs{}
+	require.NoError(t, args.Option.UnmarshalText([]byte("ConnectTimeout=5")))
+	keys := fetchAllHostKeys(&sshParam{args: args, user: "test", addr: listener.Addr().String()})
+	require.Len(t, keys, 2)
+	assert.Equal(ecdsaKey.PublicKey().Marshal(), keys[0].Marshal())
+	assert.Equal(ed25519Key.PublicKey().Marshal(), keys[1].Marshal())
+
+	ecdsaRecords := newSSHFPRecords(keys[0])
+	ed25519Records := newSSHFPRecords(keys[1])
+	require.Len(t, ed25519Records, 2)
+	assert.Equal(uint8(4), ed25519Records[0].algorithm)
+	assert.Equal(uint8(sshfpTypeSHA1), ed25519Records[0].fpType)
+	assert.Len(ed25519Records[0].fingerprint, 20)
+	assert.Equal(uint8(sshfpTypeSHA256), ed25519Records[1].fpType)
+	assert.Len(ed25519Records[1].fingerprint, 32)
+	assert.True(matchSSHFP(ed25519Records[:1], keys[1]))
+	assert.True(matchSSHFP(ed25519Records[1:], keys[1]))
+	assert.False(matchSSHFP(ed25519Records, keys[0]))
+	assert.Regexp(`^3 2 [0-9a-f]{64}$`, ecdsaRecords[1].String())
+
+	records := append(ecdsaRecords, ed25519Records...)
+	stale := sshfpRecord{algorithm: 1, fpType: sshfpTypeSHA256, fingerprint: make([]byte, 32)}
+	missing, staleRecords := compareSSHFPRecords(records, []sshfpRecord{ecdsaRecords[0], ecdsaRecords[1], ed25519Records[1], stale})
+	assert.Equal([]sshfpRecord{ed25519Records[0]}, missing)
+	assert.Equal([]sshfpRecord{stale}, staleRecords)
+	missing, staleRecords = compareSSHFPRecords(records, records)
+	assert.Empty(missing)
+	assert.Empty(staleRecords)
+}
+
+func TestSSHFPLegacyRSA(t *testing.T) {
+	oriUserConfig := userConfig
+	userConfig = &tsshConfig{}
+	defer func() { userConfig = oriUserConfig }()
+
+	rsaPriKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	rsaSigner, err := ssh.NewSignerFromKey(rsaPriKey)
+	require.NoError(t, err)
+	// the old servers only sign with ssh-rsa
+	legacySigner, err := ssh.NewSignerWithAlgorithms(rsaSigner.(ssh.AlgorithmSigner), []string{ssh.KeyAlgoRSA})
+	require.NoError(t, err)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer func() { _ = listener.Close() }()
+	go func() {
+		config := &ssh.ServerConfig{NoClientAuth: true}
+		config.AddHostKey(legacySigner)
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer func() { _ = conn.Close() }()
+				_, _, _, _ = ssh.NewServerConn(conn, config)
+			}()
+		}
+	}()
+
+	args := &sshArgs{}
+	require.NoError(t, args.Option.UnmarshalText([]byte("ConnectTimeout=5")))
+	keys := fetchAllHostKeys(&sshParam{args: args, user: "test", addr: listener.Addr().String()})
+	require.Len(t, keys, 1)
+	assert.Equal(t, rsaSigner.PublicKey().Marshal(), keys[0].Marshal())
+	assert.Equal(t, uint8(1), newSSHFPRecords(keys[0])[0].algorithm)
+}
@@ -0,0 +1,381 @@
+/*
+MIT License
+
+Copyright (c) 2023-2026 The Trzsz SSH Authors.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package tssh
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/skeema/knownhosts"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	kHostKeysRequest      = "hostkeys-00@openssh.com"
+	kHostKeysProveRequest = "hostkeys-prove-00@openssh.com"
+)
+
+// verifiedHostKey is the host key verified by the user known_hosts file during the key exchange.
+type verifiedHostKey struct {
+	host string
+	key  ssh.PublicKey
+	path string
+}
+
+// hostKeysChanges are the host keys to be learned and deprecated in the user known_hosts file.
+type hostKeysChanges struct {
+	host      string
+	path      string
+	newKeys   []ssh.PublicKey
+	staleKeys []ssh.PublicKey
+}
+
+func getUpdateHostKeysMode(args *sshArgs) string {
+	switch strings.ToLower(getOptionConfig(args, "UpdateHostKeys")) {
+	case "yes", "true":
+		return "yes"
+	case "ask":
+		return "ask"
+	default:
+		return "no"
+	}
+}
+
+// handleHostKeysRequests takes over the hostkeys-00@openssh.com requests sent by the server after login,
+// and passes the other global requests through.
+func handleHostKeysRequests(param *sshParam, conn ssh.Conn, reqs <-chan *ssh.Request) <-chan *ssh.Request {
+	mode := getUpdateHostKeysMode(param.args)
+	if mode == "no" {
+		debug("UpdateHostKeys is disabled")
+		return reqs
+	}
+
+	pending := &pendingHostKeysChanges{}
+	if mode == "ask" {
+		addAfterLoginFunc(func() { pending.confirm(param.args) })
+		addOnExitFunc(func() { pending.confirm(param.args) })
+	}
+
+	out := make(chan *ssh.Request)
+	go func() {
+		defer close(out)
+		received := false
+		for req := range reqs {
+			if req.Type != kHostKeysRequest {
+				out <- req
+				continue
+			}
+			if req.WantReply {
+				_ = req.Reply(false, nil)
+			}
+			if received {
+				debug("ignore the duplicate %s request", kHostKeysRequest)
+				continue
+			}
+			received = true
+			payload := req.Payload
+			go func() {
+				changes, err := getHostKeysChanges(param, conn, payload)
+				if err != nil {
+					debug("UpdateHostKeys skipped: %v", err)
+				}
+				if mode == "yes" {
+					if changes != nil {
+						updateKnownHosts(param.args, changes)
+					}
+					return
+				}
+				if changes != nil {
+					pending.set(changes)
+				}
+			}()
+		}
+	}()
+	return out
+}
+
+// pendingHostKeysChanges are the host keys changes received when UpdateHostKeys is ask.
+// They are confirmed at the next point where prompting is safe: at the end of the login,
+// or when tssh exits if they are received after the session starts.
+type pendingHostKeysChanges struct {
+	mutex   sync.Mutex
+	changes *hostKeysChanges
+}
+
+func (p *pendingHostKeysChanges) set(changes *hostKeysChanges) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.changes = changes
+}
+
+func (p *pendingHostKeysChanges) confirm(args *sshArgs) {
+	p.mutex.Lock()
+	changes := p.changes
+	p.changes = nil
+	p.mutex.Unlock()
+	if changes != nil && confirmHostKeysChanges(changes) {
+		updateKnownHosts(args, changes)
+	}
+}
+
+func parseHostKeysPayload(payload []byte) ([]ssh.PublicKey, error) {
+	var keys []ssh.PublicKey
+	seen := make(map[string]struct{})
+	for len(payload) > 0 {
+		var blob struct {
+			Key  []byte
+			Rest []byte `ssh:"rest"`
+		}
+		if err := ssh.Unmarshal(payload, &blob); err != nil {
+			return nil, fmt.Errorf("parse %s request failed: %v", kHostKeysRequest, err)
+		}
+		payload = blob.Rest
+		if _, ok := seen[string(blob.Key)]; ok {
+			return nil, fmt.Errorf("server sent duplicate host key")
+		}
+		seen[string(blob.Key)] = struct{}{}
+		key, err := ssh.ParsePublicKey(blob.Key)
+		if err != nil {
+			debug("skip unsupported host key from server: %v", err)
+			continue
+		}
+		if _, ok := key.(*ssh.Certificate); ok {
+			debug("skip host certificate from server")
+			continue
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// getKnownHostKeys returns the keys of the host in the user known_hosts file and their line indexes,
+// only from the lines for this host only, which could be updated safely.
+func getKnownHostKeys(path, host string) ([]ssh.PublicKey, []int, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	target := knownhosts.Normalize(host)
+	var keys []ssh.PublicKey
+	var lines []int
+	for idx, line := range bytes.Split(content, []byte{'\n'}) {
+		fields := bytes.Fields(line)
+		if len(fields) < 3 || fields[0][0] == '#' || fields[0][0] == '@' {
+			continue
+		}
+		hostField := string(fields[0])
+		if strings.ContainsRune(hostField, ',') || !matchKnownHosts(hostField, target) {
+			continue
+		}
+		key, _, _, _, err := ssh.ParseAuthorizedKey(bytes.Join(fields[1:], []byte{' '}))
+		if err != nil {
+			continue
+		}
+		keys = append(keys, key)
+		lines = append(lines, idx)
+	}
+	return keys, lines, nil
+}
+
+func containsHostKey(keys []ssh.PublicKey, key ssh.PublicKey) bool {
+	for _, k := range keys {
+		if bytes.Equal(k.Marshal(), key.Marshal()) {
+			return true
+		}
+	}
+	return false
+}
+
+func getHostKeysChanges(param *sshParam, conn ssh.Conn, payload []byte) (*hostKeysChanges, error) {
+	verified := param.verifiedHostKey
+	if verified == nil || verified.path == "" {
+		return nil, fmt.Errorf("the host key was not verified by the user known_hosts file")
+	}
+
+	serverKeys, err := parseHostKeysPayload(payload)
+	if err != nil {
+		return nil, err
+	}
+	if !containsHostKey(serverKeys, verified.key) {
+		return nil, fmt.Errorf("server did not report the host key used in the key exchange")
+	}
+
+	knownKeys, _, err := getKnownHostKeys(verified.path, verified.host)
+	if err != nil {
+		return nil, err
+	}
+	if !containsHostKey(knownKeys, verified.key) {
+		return nil, fmt.Errorf("the host key is not in a line for '%s' only in %s", verified.host, verified.path)
+	}
+
+	changes := &hostKeysChanges{host: verified.host, path: verified.path}
+	for _, key := range serverKeys {
+		if !containsHostKey(knownKeys, key) {
+			changes.newKeys = append(changes.newKeys, key)
+		}
+	}
+	for _, key := range knownKeys {
+		if !containsHostKey(serverKeys, key) {
+			changes.staleKeys = append(changes.staleKeys, key)
+		}
+	}
+	if len(changes.newKeys) == 0 && len(changes.staleKeys) == 0 {
+		debug("host keys for '%s' are up to date", verified.host)
+		return nil, nil
+	}
+
+	if len(changes.newKeys) > 0 {
+		if err := proveHostKeys(conn, changes.newKeys); err != nil {
+			return nil, err
+		}
+	}
+	return changes, nil
+}
+
+func getHostKeysProveData(sessionID []byte, key ssh.PublicKey) []byte {
+	return ssh.Marshal(struct {
+		Request   string
+		SessionID []byte
+		Key       []byte
+	}{kHostKeysProveRequest, sessionID, key.Marshal()})
+}
+
+// proveHostKeys asks the server to prove the possession of the private keys of the new host keys.
+func proveHostKeys(conn ssh.Conn, keys []ssh.PublicKey) error {
+	var payload []byte
+	for _, key := range keys {
+		payload = append(payload, ssh.Marshal(struct{ Key []byte }{key.Marshal()})...)
+	}
+	ok, reply, err := conn.SendRequest(kHostKeysProveRequest, true, payload)
+	if err != nil {
+		return fmt.Errorf("send %s request failed: %v", kHostKeysProveRequest, err)
+	}
+	if !ok {
+		return fmt.Errorf("server refused the %s request", kHostKeysProveRequest)
+	}
+	return verifyHostKeysProof(conn.SessionID(), keys, reply)
+}
+
+func verifyHostKeysProof(sessionID []byte, keys []ssh.PublicKey, reply []byte) error {
+	for _, key := range keys {
+		var blob struct {
+			Signature []byte
+			Rest      []byte `ssh:"rest"`
+		}
+		if err := ssh.Unmarshal(reply, &blob); err != nil {
+			return fmt.Errorf("parse %s reply failed: %v", kHostKeysProveRequest, err)
+		}
+		reply = blob.Rest
+		var signature ssh.Signature
+		if err := ssh.Unmarshal(blob.Signature, &signature); err != nil {
+			return fmt.Errorf("parse %s signature failed: %v", kHostKeysProveRequest, err)
+		}
+		if err := key.Verify(getHostKeysProveData(sessionID, key), &signature); err != nil {
+			return fmt.Errorf("server failed to prove the %s host key %s: %v",
+				shortKeyType(key.Type()), ssh.FingerprintSHA256(key), err)
+		}
+	}
+	if len(reply) > 0 {
+		return fmt.Errorf("unexpected data in %s reply", kHostKeysProveRequest)
+	}
+	return nil
+}
+
+func confirmHostKeysChanges(changes *hostKeysChanges) bool {
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "The server '%s' has updated its host keys.\r\n", changes.host)
+	for _, key := range changes.newKeys {
+		fmt.Fprintf(&buf, "Learn new %s key %s\r\n", shortKeyType(key.Type()), ssh.FingerprintSHA256(key))
+	}
+	for _, key := range changes.staleKeys {
+		fmt.Fprintf(&buf, "Remove obsolete %s key %s\r\n", shortKeyType(key.Type()), ssh.FingerprintSHA256(key))
+	}
+	buf.WriteString("Accept updated host keys?")
+	return confirmYesNo(buf.String())
+}
+
+// updateKnownHosts removes the obsolete host keys and appends the new host keys to the user known_hosts file.
+func updateKnownHosts(args *sshArgs, changes *hostKeysChanges) {
+	addHostKeyMutex.Lock()
+	defer addHostKeyMutex.Unlock()
+
+	if len(changes.staleKeys) > 0 {
+		if err := removeKnownHostsLines(changes.path, changes.host, changes.staleKeys); err != nil {
+			warning("Failed to remove the obsolete host keys from %s: %v", changes.path, err)
+			return
+		}
+		for _, key := range changes.staleKeys {
+			warning("Removed obsolete host key '%s' (%s) %s from %s.", changes.host,
+				shortKeyType(key.Type()), ssh.FingerprintSHA256(key), changes.path)
+		}
+	}
+	for _, key := range changes.newKeys {
+		if err := writeKnownHost(args, changes.path, changes.host, "", key); err != nil {
+			warning("Failed to add the new host key to %s: %v", changes.path, err)
+			return
+		}
+		warning("Learned new host key '%s' (%s) %s.", changes.host, shortKeyType(key.Type()), ssh.FingerprintSHA256(key))
+	}
+}
+
+func removeKnownHostsLines(path, host string, staleKeys []ssh.PublicKey) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	// read the lines again, in case the file has been changed since the changes were computed
+	knownKeys, knownLines, err := getKnownHostKeys(path, host)
+	if err != nil {
+		return err
+	}
+	removed := make(map[int]struct{})
+	for i, key := range knownKeys {
+		if containsHostKey(staleKeys, key) {
+			removed[knownLines[i]] = struct{}{}
+		}
+	}
+	if len(removed) == 0 {
+		return nil
+	}
+
+	lines := bytes.Split(content, []byte{'\n'})
+	output := make([][]byte, 0, len(lines))
+	for idx, line := range lines {
+		if _, ok := removed[idx]; !ok {
+			output = append(output, line)
+		}
+	}
+	if err := os.WriteFile(path+".old", content, info.Mode().Perm()); err != nil {
+		return err
+	}
+	return os.WriteFile(path, bytes.Join(output, []byte{'\n'}), info.Mode().Perm())
+}
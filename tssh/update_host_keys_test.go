@@ -0,0 +1,276 @@
+/*
+MIT License
+
+Copyright (c) 2023-2026 The Trzsz SSH Authors.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package tssh
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/skeema/knownhosts"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+func newTestHostKey(t *testing.T) ssh.Signer {
+	_, priKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(priKey)
+	require.NoError(t, err)
+	return signer
+}
+
+// serveHostKeys runs a fake server which advertises the host keys and proves the possession of them.
+func serveHostKeys(conn net.Conn, hostKey ssh.Signer, advertised []ssh.Signer) {
+	config := &ssh.ServerConfig{NoClientAuth: true}
+	config.AddHostKey(hostKey)
+	sconn, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go func() {
+		for ch := range chans {
+			_ = ch.Reject(ssh.Prohibited, "no channels")
+		}
+	}()
+
+	var payload []byte
+	for _, signer := range advertised {
+		payload = append(payload, ssh.Marshal(struct{ Key []byte }{signer.PublicKey().Marshal()})...)
+	}
+	_, _, _ = sconn.SendRequest(kHostKeysRequest, false, payload)
+
+	for req := range reqs {
+		if req.Type != kHostKeysProveRequest {
+			_ = req.Reply(false, nil)
+			continue
+		}
+		var reply []byte
+		rest := req.Payload
+		for len(rest) > 0 {
+			var blob struct {
+				Key  []byte
+				Rest []byte `ssh:"rest"`
+			}
+			if err := ssh.Unmarshal(rest, &blob); err != nil {
+				_ = req.Reply(false, nil)
+				break
+			}
+			rest = blob.Rest
+			for _, signer := range advertised {
+				if string(signer.PublicKey().Marshal()) != string(blob.Key) {
+					continue
+				}
+				sig, err := signer.Sign(rand.Reader, getHostKeysProveData(sconn.SessionID(), signer.PublicKey()))
+				if err != nil {
+					continue
+				}
+				reply = append(reply, ssh.Marshal(struct{ Sig []byte }{ssh.Marshal(sig)})...)
+			}
+		}
+		_ = req.Reply(true, reply)
+	}
+}
+
+// connectHostKeysServer logs in to a fake server which advertises the host keys, and returns the closer.
+func connectHostKeysServer(t *testing.T, param *sshParam, hostKey ssh.Signer, advertised []ssh.Signer) func() {
+	hostKeyCallback, _, err := getHostKeyCallback(param)
+	require.NoError(t, err)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() {
+		if conn, err := listener.Accept(); err == nil {
+			serveHostKeys(conn, hostKey, advertised)
+		}
+	}()
+	clientConn, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	ncc, chans, reqs, err := ssh.NewClientConn(clientConn, param.addr, &ssh.ClientConfig{
+		User:            "test",
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         5 * time.Second,
+	})
+	require.NoError(t, err)
+	client := ssh.NewClient(ncc, chans, handleHostKeysRequests(param, ncc, reqs))
+	return func() {
+		_ = client.Close()
+		_ = listener.Close()
+	}
+}
+
+func waitKnownHostsLine(t *testing.T, path, line string) {
+	require.Eventually(t, func() bool {
+		content, _ := os.ReadFile(path)
+		return strings.Contains(string(content), line)
+	}, 5*time.Second, 10*time.Millisecond)
+	// wait for the update to finish, which holds the mutex
+	addHostKeyMutex.Lock()
+	addHostKeyMutex.Unlock()
+}
+
+func TestUpdateHostKeys(t *testing.T) {
+	assert := assert.New(t)
+	oriEnableWarning, oriUserConfig := enableWarningLogging, userConfig
+	enableWarningLogging, userConfig = false, &tsshConfig{}
+	defer func() { enableWarningLogging, userConfig = oriEnableWarning, oriUserConfig }()
+
+	hostKey, newKey, staleKey, otherKey := newTestHostKey(t), newTestHostKey(t), newTestHostKey(t), newTestHostKey(t)
+	path := filepath.Join(t.TempDir(), "known_hosts")
+	content := "# comment\n" +
+		knownhosts.Line([]string{"example.com"}, hostKey.PublicKey()) + "\n" +
+		knownhosts.Line([]string{"example.com"}, staleKey.PublicKey()) + "\n" +
+		knownhosts.Line([]string{"example.com", "10.0.0.1"}, otherKey.PublicKey()) + "\n" +
+		knownhosts.Line([]string{"other.com"}, staleKey.PublicKey()) + "\n"
+	require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+
+	args := &sshArgs{Destination: "example.com"}
+	for _, option := range []string{"UpdateHostKeys=yes", "UserKnownHostsFile=" + path, "GlobalKnownHostsFile=none"} {
+		require.NoError(t, args.Option.UnmarshalText([]byte(option)))
+	}
+	param := &sshParam{args: args, host: "example.com", port: "22", addr: "example.com:22"}
+	defer connectHostKeysServer(t, param, hostKey, []ssh.Signer{hostKey, newKey})()
+	waitKnownHostsLine(t, path, knownhosts.Line([]string{"example.com"}, newKey.PublicKey()))
+
+	keys, _, err := getKnownHostKeys(path, "example.com")
+	require.NoError(t, err)
+	assert.Len(keys, 2)
+	assert.True(containsHostKey(keys, hostKey.PublicKey()))
+	assert.True(containsHostKey(keys, newKey.PublicKey()))
+	updated, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(string(updated), knownhosts.Line([]string{"example.com", "10.0.0.1"}, otherKey.PublicKey()))
+	assert.Contains(string(updated), knownhosts.Line([]string{"other.com"}, staleKey.PublicKey()))
+	backup, err := os.ReadFile(path + ".old")
+	require.NoError(t, err)
+	assert.Equal(content, string(backup))
+}
+
+func TestUpdateHostKeysWithCheckHostIP(t *testing.T) {
+	assert := assert.New(t)
+	oriEnableWarning, oriUserConfig := enableWarningLogging, userConfig
+	enableWarningLogging, userConfig = false, &tsshConfig{}
+	defer func() { enableWarningLogging, userConfig = oriEnableWarning, oriUserConfig }()
+
+	hostKey, newKey := newTestHostKey(t), newTestHostKey(t)
+	path := filepath.Join(t.TempDir(), "known_hosts")
+	require.NoError(t, os.WriteFile(path, nil, 0600))
+	args := &sshArgs{Destination: "example.com"}
+	for _, option := range []string{"UpdateHostKeys=yes", "CheckHostIP=yes", "StrictHostKeyChecking=accept-new",
+		"UserKnownHostsFile=" + path, "GlobalKnownHostsFile=none"} {
+		require.NoError(t, args.Option.UnmarshalText([]byte(option)))
+	}
+
+	// the new host is accepted along with its IP, and then its host keys are rotated
+	param := &sshParam{args: args, host: "example.com", port: "22", addr: "example.com:22"}
+	defer connectHostKeysServer(t, param, hostKey, []ssh.Signer{hostKey, newKey})()
+	waitKnownHostsLine(t, path, knownhosts.Line([]string{"example.com"}, newKey.PublicKey()))
+
+	keys, _, err := getKnownHostKeys(path, "example.com")
+	require.NoError(t, err)
+	assert.Len(keys, 2)
+	assert.True(containsHostKey(keys, hostKey.PublicKey()))
+	assert.True(containsHostKey(keys, newKey.PublicKey()))
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Regexp(`(?m)^\[127\.0\.0\.1\]:\d+ `+regexp.QuoteMeta(strings.TrimSpace(string(ssh.MarshalAuthorizedKey(hostKey.PublicKey())))), string(content))
+}
+
+func TestUpdateHostKeysAsk(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the askpass program true is not available on windows")
+	}
+	oriEnableWarning, oriUserConfig := enableWarningLogging, userConfig
+	enableWarningLogging, userConfig = false, &tsshConfig{}
+	defer func() { enableWarningLogging, userConfig = oriEnableWarning, oriUserConfig }()
+	oriAfterLoginFuncs, oriOnExitFuncs := afterLoginFuncs, onExitFuncs
+	afterLoginFuncs, onExitFuncs = nil, nil
+	defer func() { afterLoginFuncs, onExitFuncs = oriAfterLoginFuncs, oriOnExitFuncs }()
+	askpass, err := exec.LookPath("true")
+	require.NoError(t, err)
+	t.Setenv("SSH_ASKPASS", askpass)
+	t.Setenv("SSH_ASKPASS_REQUIRE", "force")
+
+	hostKey, newKey := newTestHostKey(t), newTestHostKey(t)
+	path := filepath.Join(t.TempDir(), "known_hosts")
+	require.NoError(t, os.WriteFile(path, []byte(knownhosts.Line([]string{"example.com"}, hostKey.PublicKey())+"\n"), 0600))
+	args := &sshArgs{Destination: "example.com"}
+	for _, option := range []string{"UpdateHostKeys=ask", "UserKnownHostsFile=" + path, "GlobalKnownHostsFile=none"} {
+		require.NoError(t, args.Option.UnmarshalText([]byte(option)))
+	}
+	param := &sshParam{args: args, host: "example.com", port: "22", addr: "example.com:22"}
+	defer connectHostKeysServer(t, param, hostKey, []ssh.Signer{hostKey, newKey})()
+	require.Len(t, afterLoginFuncs, 1)
+	require.Len(t, onExitFuncs, 1)
+
+	// the received changes are not confirmed until the next prompt-safe point
+	newLine := knownhosts.Line([]string{"example.com"}, newKey.PublicKey())
+	require.Never(t, func() bool {
+		content, _ := os.ReadFile(path)
+		return strings.Contains(string(content), newLine)
+	}, 300*time.Millisecond, 10*time.Millisecond)
+
+	// the changes received after the login are confirmed on exit
+	confirmOnExit := onExitFuncs[0]
+	require.Eventually(t, func() bool {
+		confirmOnExit()
+		content, _ := os.ReadFile(path)
+		return strings.Contains(string(content), newLine)
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestVerifyHostKeysProof(t *testing.T) {
+	assert := assert.New(t)
+	key1, key2 := newTestHostKey(t), newTestHostKey(t)
+	sessionID := []byte("session id")
+
+	sign := func(signer ssh.Signer, sessionID []byte) []byte {
+		sig, err := signer.Sign(rand.Reader, getHostKeysProveData(sessionID, signer.PublicKey()))
+		require.NoError(t, err)
+		return ssh.Marshal(struct{ Sig []byte }{ssh.Marshal(sig)})
+	}
+	keys := []ssh.PublicKey{key1.PublicKey(), key2.PublicKey()}
+
+	assert.Nil(verifyHostKeysProof(sessionID, keys, append(sign(key1, sessionID), sign(key2, sessionID)...)))
+	assert.Error(verifyHostKeysProof(sessionID, keys, append(sign(key2, sessionID), sign(key1, sessionID)...)))
+	assert.Error(verifyHostKeysProof(sessionID, keys, append(sign(key1, sessionID), sign(key2, []byte("other"))...)))
+	assert.Error(verifyHostKeysProof(sessionID, keys, sign(key1, sessionID)))
+	assert.Error(verifyHostKeysProof(sessionID, keys[:1], append(sign(key1, sessionID), sign(key2, sessionID)...)))
+
+	_, err := parseHostKeysPayload(ssh.Marshal(struct{ A, B []byte }{key1.PublicKey().Marshal(), key1.PublicKey().Marshal()}))
+	assert.Error(err)
+	parsed, err := parseHostKeysPayload(ssh.Marshal(struct{ A, B []byte }{key1.PublicKey().Marshal(), []byte("unknown")}))
+	require.NoError(t, err)
+	assert.Len(parsed, 1)
+}
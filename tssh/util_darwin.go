@@ -35,6 +35,8 @@ import (
 
 const kDefaultSshSkHelperPath = "/usr/libexec/ssh-sk-helper"
 
+const kDefaultSshPkcs11HelperPath = "/usr/libexec/ssh-pkcs11-helper"
+
 func isRemoteSshEnv(pid int) bool {
 	for range 1000 {
 		kinfo, err := unix.SysctlKinfoProc("kern.proc.pid", pid)
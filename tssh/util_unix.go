@@ -37,6 +37,8 @@ import (
 
 const kDefaultSshSkHelperPath = "/usr/lib/openssh/ssh-sk-helper"
 
+const kDefaultSshPkcs11HelperPath = "/usr/lib/openssh/ssh-pkcs11-helper"
+
 func isRemoteSshEnv(pid int) bool {
 	for range 1000 {
 		stat, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
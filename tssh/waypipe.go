@@ -219,12 +219,12 @@ func remoteForwardSocket(client SshClient, clientSocket, serverSocket string) er
 				break
 			}
 			if err != nil {
-				debug("waypipe remote accept failed: %v", err)
+				debug("remote socket [%s] accept failed: %v", serverSocket, err)
 				break
 			}
 			local, err := net.DialTimeout("unix", clientSocket, time.Second)
 			if err != nil {
-				debug("waypipe dial unix [%s] failed: %v", clientSocket, err)
+				debug("remote socket dial unix [%s] failed: %v", clientSocket, err)
 				_ = remote.Close()
 				continue
 			}